@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 
+	"github.com/ClappFormOrg/AI-CO/go/internal/audit"
 	"github.com/ClappFormOrg/AI-CO/go/internal/server"
+	"github.com/ClappFormOrg/AI-CO/go/pkg/kube/client"
 	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
 
 	"github.com/kelseyhightower/envconfig"
@@ -46,6 +52,11 @@ func init() {
 	signal.Notify(stop, SignalTerminate, os.Interrupt)
 }
 
+// userAgent identifies aico and its build to the Kubernetes API servers it talks to.
+func userAgent() string {
+	return fmt.Sprintf("%s/%s (%s/%s) %s", Program, Version, runtime.GOOS, runtime.GOARCH, Commit)
+}
+
 func terminate() {
 	p, err := os.FindProcess(os.Getpid())
 	if err != nil {
@@ -57,6 +68,98 @@ func terminate() {
 	}
 }
 
+// newAuthenticator builds the API authenticator from the static token file and
+// the OIDC settings in spec. When both are configured a request is accepted by
+// either of them. It returns nil when no authentication is configured.
+func newAuthenticator(spec *Specification) (server.Authenticator, error) {
+	var chain server.ChainAuthenticator
+
+	if spec.AuthTokensFile != "" {
+		static, err := server.NewStaticTokenAuthenticatorFromFile(spec.AuthTokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("load static tokens: %w", err)
+		}
+		chain = append(chain, static)
+	}
+
+	if spec.OIDCJWKSFile != "" || spec.OIDCJWKSURL != "" {
+		jwt, err := server.NewJWTAuthenticator(server.JWTConfig{
+			Issuer:       spec.OIDCIssuer,
+			Audience:     spec.OIDCAudience,
+			JWKSFile:     spec.OIDCJWKSFile,
+			JWKSURL:      spec.OIDCJWKSURL,
+			SubjectClaim: spec.OIDCSubjectClaim,
+			GroupsClaim:  spec.OIDCGroupsClaim,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configure OIDC: %w", err)
+		}
+		chain = append(chain, jwt)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// newClusterStore builds the cluster store selected by spec.ClusterStore,
+// either "file" or "secret". It returns nil when no store is selected.
+func newClusterStore(spec *Specification) (server.ClusterStore, error) {
+	if spec.ClusterStore == "" {
+		return nil, nil
+	}
+
+	if spec.ClusterStoreKeyFile == "" {
+		return nil, errors.New("a key file is required to encrypt stored clusters")
+	}
+	encoded, err := os.ReadFile(spec.ClusterStoreKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key file %q: %w", spec.ClusterStoreKeyFile, err)
+	}
+
+	switch spec.ClusterStore {
+	case "file":
+		return server.NewFileClusterStore(spec.ClusterStoreFile, key)
+	case "secret":
+		clientset, _, err := client.CreateKubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		return server.NewSecretClusterStore(clientset, spec.ClusterStoreNamespace, key)
+	default:
+		return nil, fmt.Errorf("unknown cluster store %q, expected \"file\" or \"secret\"", spec.ClusterStore)
+	}
+}
+
+// newAuditSink builds the audit sinks configured in spec: the rotating file
+// when AuditFile is set and stdout when AuditStdout is set. It returns nil
+// when auditing is disabled.
+func newAuditSink(spec *Specification) (audit.Sink, error) {
+	var sinks audit.MultiSink
+
+	if spec.AuditFile != "" {
+		file, err := audit.NewFileSink(spec.AuditFile, spec.AuditMaxSize, spec.AuditMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, file)
+	}
+
+	if spec.AuditStdout {
+		sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -90,7 +193,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	opts := []server.Option{server.WithLogger(logger)}
+	opts := []server.Option{
+		server.WithLogger(logger),
+		server.WithHealthCheckInterval(spec.HealthCheckInterval),
+		server.WithHealthCheckTimeout(spec.HealthCheckTimeout),
+		server.WithKubeconfigExecPlugins(spec.KubeconfigAllowExec),
+		server.WithClientDefaults(server.ClientSettings{
+			QPS:       spec.ClusterClientQPS,
+			Burst:     spec.ClusterClientBurst,
+			Timeout:   spec.ClusterClientTimeout,
+			UserAgent: userAgent(),
+		}),
+	}
 
 	if spec.TLSKeyFile != "" {
 		keyFileBytes, err := os.ReadFile(spec.TLSKeyFile)
@@ -108,6 +222,44 @@ func main() {
 		opts = append(opts, server.WithSecretTLSCert(crtFileBytes))
 	}
 
+	authenticator, err := newAuthenticator(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to configure authentication: %s\n", err)
+		os.Exit(1)
+	}
+	if authenticator != nil {
+		opts = append(opts, server.WithAuthenticator(authenticator))
+	}
+
+	store, err := newClusterStore(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to configure cluster store: %s\n", err)
+		os.Exit(1)
+	}
+	if store != nil {
+		opts = append(opts, server.WithClusterStore(store))
+	}
+
+	if spec.AuthzPolicyFile != "" {
+		policy, err := server.NewPolicyFromFile(spec.AuthzPolicyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to load authorization policy: %s\n", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithAuthorizer(policy))
+	}
+
+	auditSink, err := newAuditSink(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to configure audit log: %s\n", err)
+		os.Exit(1)
+	}
+	if auditSink != nil {
+		opts = append(opts, server.WithAuditSink(auditSink))
+	} else {
+		logger.Warn("audit log disabled, mutating API calls are not recorded")
+	}
+
 	handler, err := server.NewHandler(opts...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: failed to create server handler: %s\n", err)
@@ -15,4 +15,26 @@ type Specification struct {
 	TerminationGracePeriod time.Duration `default:"5s" split_words:"true"`
 	TLSKeyFile             string        `default:"" split_words:"true"`
 	TLSCertFile            string        `default:"" split_words:"true"`
+	ClusterStore           string        `default:"" split_words:"true"`
+	ClusterStoreFile       string        `default:"clusters.json" split_words:"true"`
+	ClusterStoreNamespace  string        `default:"nl-appstore-registry" split_words:"true"`
+	ClusterStoreKeyFile    string        `default:"" split_words:"true"`
+	AuthTokensFile         string        `default:"" split_words:"true"`
+	AuthzPolicyFile        string        `default:"" split_words:"true"`
+	OIDCIssuer             string        `default:"" split_words:"true"`
+	OIDCAudience           string        `default:"" split_words:"true"`
+	OIDCJWKSFile           string        `default:"" envconfig:"OIDC_JWKS_FILE"`
+	OIDCJWKSURL            string        `default:"" envconfig:"OIDC_JWKS_URL"`
+	OIDCSubjectClaim       string        `default:"sub" split_words:"true"`
+	OIDCGroupsClaim        string        `default:"groups" split_words:"true"`
+	HealthCheckInterval    time.Duration `default:"30s" split_words:"true"`
+	HealthCheckTimeout     time.Duration `default:"5s" split_words:"true"`
+	KubeconfigAllowExec    bool          `default:"false" split_words:"true"`
+	ClusterClientQPS       float32       `default:"20" envconfig:"CLUSTER_CLIENT_QPS"`
+	ClusterClientBurst     int           `default:"40" split_words:"true"`
+	ClusterClientTimeout   time.Duration `default:"30s" split_words:"true"`
+	AuditFile              string        `default:"" split_words:"true"`
+	AuditMaxSize           int64         `default:"104857600" split_words:"true"`
+	AuditMaxBackups        int           `default:"5" split_words:"true"`
+	AuditStdout            bool          `default:"true" split_words:"true"`
 }
@@ -0,0 +1,179 @@
+// Package audit records the mutating calls made against the aico API.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Outcome summarises how an audited request ended.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeDenied  Outcome = "denied"  // Authentication or authorization failed.
+	OutcomeFailure Outcome = "failure" // Any other error response.
+)
+
+// OutcomeFor maps an HTTP status code to an Outcome.
+func OutcomeFor(status int) Outcome {
+	switch {
+	case status < 400:
+		return OutcomeSuccess
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	default:
+		return OutcomeFailure
+	}
+}
+
+// Event is a single audited API call.
+type Event struct {
+	Time          time.Time `json:"time"`
+	Actor         string    `json:"actor"`
+	Groups        []string  `json:"groups,omitempty"`
+	RemoteAddr    string    `json:"remoteAddr,omitempty"`
+	Method        string    `json:"method"`
+	Route         string    `json:"route"` // The matched route pattern, e.g. "DELETE /clusters/{clusterName}".
+	Path          string    `json:"path"`
+	Cluster       string    `json:"cluster,omitempty"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Objects       []string  `json:"objects,omitempty"`
+	RequestDigest string    `json:"requestDigest,omitempty"` // "sha256:<hex>" of the request body.
+	Status        int       `json:"status"`
+	Outcome       Outcome   `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Sink receives audit events.
+type Sink interface {
+	Write(ctx context.Context, event Event) error
+}
+
+// Querier is implemented by sinks that can read back the events they wrote.
+type Querier interface {
+	Query(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+// ErrNotQueryable is returned by Query when no configured sink can be queried.
+var ErrNotQueryable = errors.New("audit log is not queryable")
+
+// Filter selects events in Query. Zero fields match everything.
+type Filter struct {
+	Actor     string
+	Cluster   string
+	Namespace string
+	Method    string
+	Outcome   Outcome
+	Since     time.Time
+	Until     time.Time
+	Limit     int // Maximum number of events, newest first. Zero means DefaultQueryLimit.
+
+	// Visible, when set, must also accept an event for it to match.
+	Visible func(Event) bool
+}
+
+const (
+	// DefaultQueryLimit is the number of events Query returns when no limit is set.
+	DefaultQueryLimit = 100
+	// MaxQueryLimit caps the number of events a single Query returns.
+	MaxQueryLimit = 1000
+)
+
+// Match reports whether event is selected by f, ignoring the limit.
+func (f Filter) Match(event Event) bool {
+	return (f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Cluster == "" || event.Cluster == f.Cluster) &&
+		(f.Namespace == "" || event.Namespace == f.Namespace) &&
+		(f.Method == "" || event.Method == f.Method) &&
+		(f.Outcome == "" || event.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !event.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || event.Time.Before(f.Until)) &&
+		(f.Visible == nil || f.Visible(event))
+}
+
+func (f Filter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultQueryLimit
+	case f.Limit > MaxQueryLimit:
+		return MaxQueryLimit
+	default:
+		return f.Limit
+	}
+}
+
+// MultiSink writes every event to all of its sinks and queries the first one
+// that implements Querier.
+type MultiSink []Sink
+
+// Write writes event to every sink and joins their errors.
+func (m MultiSink) Write(ctx context.Context, event Event) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Write(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Query reads from the first queryable sink.
+func (m MultiSink) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	for _, sink := range m {
+		if q, ok := sink.(Querier); ok {
+			return q.Query(ctx, filter)
+		}
+	}
+	return nil, ErrNotQueryable
+}
+
+// Close closes every sink that implements io.Closer and joins their errors.
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriterSink writes events as JSON lines to an io.Writer such as os.Stdout.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Write writes event as a single JSON line.
+func (s *WriterSink) Write(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// newestFirst sorts events by time, newest first, and applies the filter limit.
+func newestFirst(events []Event, filter Filter) []Event {
+	slices.SortStableFunc(events, func(a, b Event) int { return b.Time.Compare(a.Time) })
+	if n := filter.limit(); len(events) > n {
+		events = events[:n]
+	}
+	return events
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutcomeFor(t *testing.T) {
+	tests := map[int]Outcome{
+		200: OutcomeSuccess,
+		204: OutcomeSuccess,
+		400: OutcomeFailure,
+		401: OutcomeDenied,
+		403: OutcomeDenied,
+		409: OutcomeFailure,
+		500: OutcomeFailure,
+	}
+	for status, want := range tests {
+		if got := OutcomeFor(status); got != want {
+			t.Errorf("OutcomeFor(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	event := testEvent(4)
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "Empty", filter: Filter{}, want: true},
+		{name: "Actor", filter: Filter{Actor: "user-0"}, want: true},
+		{name: "Other actor", filter: Filter{Actor: "user-1"}, want: false},
+		{name: "Cluster and method", filter: Filter{Cluster: "clappform", Method: "POST"}, want: true},
+		{name: "Namespace", filter: Filter{Namespace: "apps"}, want: false},
+		{name: "Outcome", filter: Filter{Outcome: OutcomeFailure}, want: false},
+		{name: "Since is inclusive", filter: Filter{Since: event.Time}, want: true},
+		{name: "Until is exclusive", filter: Filter{Until: event.Time}, want: false},
+		{name: "Window", filter: Filter{Since: event.Time.Add(-time.Second), Until: event.Time.Add(time.Second)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	var buf bytes.Buffer
+	stdout := NewWriterSink(&buf)
+
+	if _, err := (MultiSink{stdout}).Query(context.Background(), Filter{}); !errors.Is(err, ErrNotQueryable) {
+		t.Fatalf("expected ErrNotQueryable, got %v", err)
+	}
+
+	if err := (MultiSink{stdout, stdout}).Write(context.Background(), testEvent(1)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected an event per sink, got %d lines", len(lines))
+	}
+	var event Event
+	if err := json.Unmarshal(lines[0], &event); err != nil || event.Objects[0] != "secret-1" {
+		t.Errorf("expected a JSON event line, got %q (%v)", lines[0], err)
+	}
+}
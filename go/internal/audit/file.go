@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+)
+
+const (
+	// DefaultMaxFileSize is the size at which a FileSink rotates its file.
+	DefaultMaxFileSize int64 = 100 << 20 // 100 MiB
+	// DefaultMaxBackups is the number of rotated files a FileSink keeps.
+	DefaultMaxBackups = 5
+)
+
+// FileSink appends events as JSON lines to a file. When the file would grow
+// beyond maxSize it is renamed to <path>.1, earlier backups shift up by one
+// and the oldest beyond maxBackups is removed.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens or creates the audit file at path. Zero or negative
+// limits fall back to DefaultMaxFileSize and DefaultMaxBackups.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat audit file: %w", err)
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) backup(n int) string { return fmt.Sprintf("%s.%d", s.path, n) }
+
+// rotate moves the current file to the first backup and opens a new one.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("close audit file: %w", err)
+	}
+	if err := os.Remove(s.backup(s.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove oldest audit file: %w", err)
+	}
+	for n := s.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(s.backup(n), s.backup(n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("rotate audit file: %w", err)
+		}
+	}
+	if err := os.Rename(s.path, s.backup(1)); err != nil {
+		return fmt.Errorf("rotate audit file: %w", err)
+	}
+	return s.open()
+}
+
+// Write appends event to the file, rotating it first when it is full.
+func (s *FileSink) Write(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Query reads events from the current file and its backups, newest first.
+// The files are opened under the lock, so a rotation cannot move them in
+// between, and read after it is released so writes go on meanwhile.
+func (s *FileSink) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	files, err := s.snapshot()
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		matched, err := readEvents(f, filter)
+		if err != nil {
+			return nil, fmt.Errorf("read audit file: %w", err)
+		}
+		slices.Reverse(matched)
+		events = append(events, matched...)
+		if len(events) >= filter.limit() {
+			break
+		}
+	}
+	return newestFirst(events, filter), nil
+}
+
+// snapshotFile is an audit file opened for reading, up to the size it had
+// when it was opened.
+type snapshotFile struct {
+	*os.File
+	size int64
+}
+
+// snapshot opens the current file and its existing backups, newest first.
+// Only what the current file held by then is read, not later writes.
+func (s *FileSink) snapshot() ([]snapshotFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []snapshotFile
+	for n := 0; n <= s.maxBackups; n++ {
+		path := s.path
+		if n > 0 {
+			path = s.backup(n)
+		}
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("open audit file: %w", err)
+		}
+		size := s.size
+		if n > 0 {
+			info, err := f.Stat()
+			if err != nil {
+				_ = f.Close()
+				return files, fmt.Errorf("stat audit file: %w", err)
+			}
+			size = info.Size()
+		}
+		files = append(files, snapshotFile{File: f, size: size})
+	}
+	return files, nil
+}
+
+// readEvents returns the events in f that match filter, in file order. Lines
+// that cannot be parsed are skipped.
+func readEvents(f snapshotFile, filter Filter) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(io.LimitReader(f, f.size))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if filter.Match(event) {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// Close closes the audit file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testEvent(i int) Event {
+	return Event{
+		Time:    time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+		Actor:   fmt.Sprintf("user-%d", i%2),
+		Method:  "POST",
+		Route:   "POST /secrets",
+		Path:    "/secrets",
+		Cluster: "clappform",
+		Objects: []string{fmt.Sprintf("secret-%d", i)},
+		Status:  201,
+		Outcome: OutcomeSuccess,
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	ctx := context.Background()
+
+	// Small enough to hold about two events per file
+	sink, err := NewFileSink(path, 400, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+
+	for i := range 10 {
+		if err := sink.Write(ctx, testEvent(i)); err != nil {
+			t.Fatalf("Write(%d): %v", i, err)
+		}
+	}
+
+	t.Run("Rotates and drops old backups", func(t *testing.T) {
+		for _, p := range []string{path, path + ".1", path + ".2"} {
+			info, err := os.Stat(p)
+			if err != nil {
+				t.Fatalf("expected %s to exist: %v", p, err)
+			}
+			if info.Size() > 400 {
+				t.Errorf("%s grew beyond the limit: %d bytes", p, info.Size())
+			}
+		}
+		if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+			t.Errorf("expected no third backup, got %v", err)
+		}
+	})
+
+	t.Run("Query is newest first across files", func(t *testing.T) {
+		events, err := sink.Query(ctx, Filter{})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if len(events) == 0 || events[0].Objects[0] != "secret-9" {
+			t.Fatalf("expected newest event first, got %+v", events)
+		}
+		for i := 1; i < len(events); i++ {
+			if events[i].Time.After(events[i-1].Time) {
+				t.Fatalf("events out of order at %d", i)
+			}
+		}
+	})
+
+	t.Run("Query filters and limits", func(t *testing.T) {
+		events, err := sink.Query(ctx, Filter{Actor: "user-1", Limit: 2})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if len(events) != 2 || events[0].Objects[0] != "secret-9" || events[1].Objects[0] != "secret-7" {
+			t.Fatalf("expected secret-9 and secret-7, got %+v", events)
+		}
+	})
+
+	t.Run("Query alongside rotating writes", func(t *testing.T) {
+		done := make(chan error)
+		go func() {
+			for i := 10; i < 40; i++ {
+				if err := sink.Write(ctx, testEvent(i)); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+		for range 20 {
+			events, err := sink.Query(ctx, Filter{})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			for _, e := range events {
+				if len(e.Objects) != 1 {
+					t.Fatalf("read a partial event: %+v", e)
+				}
+			}
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	})
+
+	t.Run("Reopen appends", func(t *testing.T) {
+		before, _ := os.Stat(path)
+		reopened, err := NewFileSink(path, 400, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer reopened.Close()
+		after, _ := os.Stat(path)
+		if before.Size() != after.Size() {
+			t.Errorf("reopening truncated the file: %d -> %d", before.Size(), after.Size())
+		}
+		if perm := after.Mode().Perm(); perm != 0o600 {
+			t.Errorf("expected file mode 0600, got %o", perm)
+		}
+	})
+}
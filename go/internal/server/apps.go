@@ -0,0 +1,746 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FieldManager is the field manager aico creates and applies objects with.
+const FieldManager string = "aico"
+
+var (
+	deploymentGVR       = appsv1.SchemeGroupVersion.WithResource("deployments")
+	serviceGVR          = corev1.SchemeGroupVersion.WithResource("services")
+	ingressGVR          = networkingv1.SchemeGroupVersion.WithResource("ingresses")
+	autoscalerGVR       = autoscalingv2.SchemeGroupVersion.WithResource("horizontalpodautoscalers")
+	disruptionBudgetGVR = policyv1.SchemeGroupVersion.WithResource("poddisruptionbudgets")
+	middlewareGVR       = schema.GroupVersionResource{Group: "traefik.io", Version: "v1alpha1", Resource: "middlewares"}
+
+	ingressRouteGVR   = schema.GroupVersionResource{Group: "traefik.io", Version: "v1alpha1", Resource: "ingressroutes"}
+	traefikServiceGVR = schema.GroupVersionResource{Group: "traefik.io", Version: "v1alpha1", Resource: "traefikservices"}
+)
+
+// middlewaresAnnotation lists the Traefik Middlewares an Ingress routes through.
+const middlewaresAnnotation = "traefik.ingress.kubernetes.io/router.middlewares"
+
+// appNames are the names of the objects an app is made of.
+type appNames struct {
+	Deployment string
+	Service    string
+	Middleware string
+	Ingress    string
+	Autoscaler string
+	PathPrefix string
+
+	DisruptionBudget string
+
+	// Release runs the next version of the app during a release, behind
+	// ReleaseService; ReleaseRoute splits its traffic.
+	Release        string
+	ReleaseService string
+	ReleaseRoute   string
+}
+
+func appNamesFor(app string) appNames {
+	deployment := app + "-deployment"
+	return appNames{
+		Deployment: deployment,
+		Service:    deployment + "-service",
+		Middleware: "strip-" + deployment + "-prefix",
+		Ingress:    app + "-ingress",
+		Autoscaler: deployment,
+		PathPrefix: "/" + deployment,
+
+		DisruptionBudget: deployment,
+
+		Release:        app + "-next-deployment",
+		ReleaseService: app + "-next-deployment-service",
+		ReleaseRoute:   app + "-release",
+	}
+}
+
+// appLabels returns the labels on every object of app.
+func appLabels(app string) map[string]string {
+	return map[string]string{"app": app, ManagedByLabel: ManagedByValue, AppLabel: app}
+}
+
+// appSelector selects every object of app.
+func appSelector(app string) string {
+	return labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedByValue, AppLabel: app}).String()
+}
+
+// appDeployment renders the Deployment of the app described by in. The
+// resource quantities must have been validated by validateDeploymentRequestBody.
+func appDeployment(in DeploymentRequest) *appsv1.Deployment {
+	appLabel := map[string]string{"app": in.DeploymentName}
+	containers := []corev1.Container{
+		{
+			Name:      mainContainerName(in.DeploymentName),
+			Image:     in.Image,
+			Ports:     containerPorts(in.Ports),
+			Resources: in.Resources.requirements(),
+			Env:       envVars(in.Env),
+			EnvFrom:   envFromSources(in.EnvFrom),
+		},
+	}
+	containers[0].ReadinessProbe, containers[0].LivenessProbe, containers[0].StartupProbe = appProbes(in)
+	var volumes []corev1.Volume
+	for _, v := range in.Volumes {
+		volumes = append(volumes, v.volume())
+		if v.MountPath != "" {
+			containers[0].VolumeMounts = append(containers[0].VolumeMounts,
+				corev1.VolumeMount{Name: v.Name, MountPath: v.MountPath, ReadOnly: v.ReadOnly})
+		}
+	}
+	for _, c := range in.Sidecars {
+		containers = append(containers, c.container())
+	}
+	var initContainers []corev1.Container
+	for _, c := range in.InitContainers {
+		initContainers = append(initContainers, c.container())
+	}
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appNamesFor(in.DeploymentName).Deployment,
+			Namespace: in.Namespace,
+			Labels:    appLabels(in.DeploymentName),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(appReplicas(in)),
+			Selector: &metav1.LabelSelector{MatchLabels: appLabel},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: appLabel},
+				Spec: corev1.PodSpec{
+					InitContainers:            initContainers,
+					Containers:                containers,
+					Volumes:                   volumes,
+					TopologySpreadConstraints: appTopologySpread(in),
+				},
+			},
+		},
+	}
+}
+
+// appPort is a port of the app container with the defaults of its
+// PortRequest filled in.
+type appPort struct {
+	Index    int // In DeploymentRequest.Ports.
+	Name     string
+	Number   int32
+	Protocol corev1.Protocol
+	Public   bool
+	Host     string // Empty routes the port on the cluster domain.
+	Path     string
+}
+
+// appPorts returns the ports of the app described by in.
+func appPorts(in DeploymentRequest) []appPort {
+	prefix := appNamesFor(in.DeploymentName).PathPrefix
+	var out []appPort
+	for i, p := range in.Ports {
+		port := appPort{
+			Index:    i,
+			Name:     p.name(),
+			Number:   p.ContainerPort,
+			Protocol: p.protocol(),
+			Public:   p.Expose == ExposePublic || (p.Expose == "" && i == 0),
+			Host:     p.Host,
+			Path:     p.Path,
+		}
+		switch {
+		case !port.Public || port.Path != "":
+		case port.Host != "":
+			port.Path = "/"
+		case i == 0:
+			port.Path = prefix
+		default:
+			port.Path = prefix + "/" + port.Name
+		}
+		out = append(out, port)
+	}
+	return out
+}
+
+// strippedPrefixes returns the paths the app is served under on the cluster
+// domain, longest first so that a nested path is stripped whole.
+func strippedPrefixes(ports []appPort) []string {
+	var prefixes []string
+	for _, p := range ports {
+		if p.Public && p.Host == "" {
+			prefixes = append(prefixes, p.Path)
+		}
+	}
+	slices.SortFunc(prefixes, func(a, b string) int { return len(b) - len(a) })
+	return slices.Compact(prefixes)
+}
+
+// appService renders the Service in front of every port of the app container.
+func appService(in DeploymentRequest) *corev1.Service {
+	appLabel := map[string]string{"app": in.DeploymentName}
+	var ports []corev1.ServicePort
+	for _, p := range appPorts(in) {
+		ports = append(ports, corev1.ServicePort{
+			Name:       p.Name,
+			Protocol:   p.Protocol,
+			Port:       p.Number,
+			TargetPort: intstr.FromInt32(p.Number),
+		})
+	}
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appNamesFor(in.DeploymentName).Service,
+			Namespace: in.Namespace,
+			Labels:    appLabels(in.DeploymentName),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: appLabel,
+			Ports:    ports,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// appMiddleware renders the Traefik Middleware stripping the app's paths on
+// the cluster domain, or returns nil when the app has none.
+func appMiddleware(in DeploymentRequest) *unstructured.Unstructured {
+	prefixes := strippedPrefixes(appPorts(in))
+	if len(prefixes) == 0 {
+		return nil
+	}
+	var regex []any
+	for _, prefix := range prefixes {
+		regex = append(regex, "^"+regexp.QuoteMeta(prefix))
+	}
+	names := appNamesFor(in.DeploymentName)
+	mw := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "traefik.io/v1alpha1",
+			"kind":       "Middleware",
+			"metadata":   map[string]any{"name": names.Middleware, "namespace": in.Namespace},
+			"spec": map[string]any{
+				"stripPrefixRegex": map[string]any{"regex": regex},
+			},
+		},
+	}
+	mw.SetLabels(appLabels(in.DeploymentName))
+	return mw
+}
+
+// appIngress renders the Traefik Ingress routing the app's public ports to
+// its Service, or returns nil when every port is internal. Ports without a
+// host are routed on domain.
+func appIngress(in DeploymentRequest, domain DomainConfig) *networkingv1.Ingress {
+	names := appNamesFor(in.DeploymentName)
+	ports := appPorts(in)
+
+	var rules []networkingv1.IngressRule
+	hosts := []string{}
+	for _, p := range ports {
+		if !p.Public {
+			continue
+		}
+		host := cmp.Or(p.Host, domain.Domain)
+		i := slices.Index(hosts, host)
+		if i < 0 {
+			hosts = append(hosts, host)
+			rules = append(rules, networkingv1.IngressRule{
+				Host:             host,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{}},
+			})
+			i = len(rules) - 1
+		}
+		rules[i].HTTP.Paths = append(rules[i].HTTP.Paths, networkingv1.HTTPIngressPath{
+			Path:     p.Path,
+			PathType: ptrPathType(networkingv1.PathTypePrefix),
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: names.Service,
+					Port: networkingv1.ServiceBackendPort{Number: p.Number},
+				},
+			},
+		})
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	annotations := map[string]string{
+		"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
+		"traefik.ingress.kubernetes.io/router.tls":         "true",
+	}
+	if len(strippedPrefixes(ports)) > 0 {
+		annotations[middlewaresAnnotation] = fmt.Sprintf("%s-%s@kubernetescrd", in.Namespace, names.Middleware)
+	}
+	return &networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        names.Ingress,
+			Namespace:   in.Namespace,
+			Labels:      appLabels(in.DeploymentName),
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: rules,
+			TLS: []networkingv1.IngressTLS{
+				{Hosts: hosts, SecretName: TLSSecretName},
+			},
+		},
+	}
+}
+
+// appObject is a rendered object together with the resource it is served as.
+type appObject struct {
+	GVR    schema.GroupVersionResource
+	Object *unstructured.Unstructured
+}
+
+// appManifests renders every object of the app described by in, in the
+// order they are applied. The Middleware goes before the Ingress referring to
+// it; both are left out when the app has nothing to route or strip, the
+// HorizontalPodAutoscaler when it is not autoscaled and the
+// PodDisruptionBudget when it has none.
+func appManifests(in DeploymentRequest, domain DomainConfig) ([]appObject, error) {
+	deployment, err := toUnstructured(appDeployment(in))
+	if err != nil {
+		return nil, err
+	}
+	service, err := toUnstructured(appService(in))
+	if err != nil {
+		return nil, err
+	}
+	objects := []appObject{
+		{GVR: deploymentGVR, Object: deployment},
+		{GVR: serviceGVR, Object: service},
+	}
+	if hpa := appAutoscaler(in); hpa != nil {
+		autoscaler, err := toUnstructured(hpa)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, appObject{GVR: autoscalerGVR, Object: autoscaler})
+	}
+	if pdb := appDisruptionBudget(in); pdb != nil {
+		budget, err := toUnstructured(pdb)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, appObject{GVR: disruptionBudgetGVR, Object: budget})
+	}
+	if mw := appMiddleware(in); mw != nil {
+		objects = append(objects, appObject{GVR: middlewareGVR, Object: mw})
+	}
+	if ing := appIngress(in, domain); ing != nil {
+		ingress, err := toUnstructured(ing)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, appObject{GVR: ingressGVR, Object: ingress})
+	}
+	return objects, nil
+}
+
+// toUnstructured converts a typed object into the form applied through the
+// dynamic client.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("convert %T: %w", obj, err)
+	}
+	// Neither is ours to set; applying them would claim ownership
+	unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u, "status")
+	return &unstructured.Unstructured{Object: u}, nil
+}
+
+// ensureNamespace creates namespace unless it already exists, and reports
+// whether it did. With dryRun it only reports whether it would.
+func ensureNamespace(ctx context.Context, cs kubernetes.Interface, namespace string, dryRun bool) (bool, error) {
+	if err := validateNamespaceExists(cs, namespace); err == nil {
+		return false, nil
+	}
+	_, err := cs.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace},
+	}, createOptions(dryRun))
+	if apierrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ensureTLSSecret creates the TLSSecretName secret for domain in namespace
+// unless it already exists, and reports whether it did. With dryRun it only
+// reports whether it would. Certificate changes are rolled out by
+// rotateTLSSecrets, not here.
+func ensureTLSSecret(ctx context.Context, cs kubernetes.Interface, namespace string, domain DomainConfig, dryRun bool) (bool, error) {
+	if _, err := cs.CoreV1().Secrets(namespace).Get(ctx, TLSSecretName, metav1.GetOptions{}); err == nil {
+		return false, nil
+	}
+	_, err := cs.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: TLSSecretName, Namespace: namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": slices.Clone(domain.Certificate),
+			"tls.key": slices.Clone(domain.PrivateKey),
+		},
+	}, createOptions(dryRun))
+	if apierrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// appResources are the kinds of object an app is made of, in the order they
+// are deleted, with the name each object had before it carried AppLabel.
+// Kinds that always carried it have no LegacyName.
+var appResources = []struct {
+	Kind       string
+	GVR        schema.GroupVersionResource
+	LegacyName func(appNames) string
+}{
+	{"IngressRoute", ingressRouteGVR, nil},
+	{"TraefikService", traefikServiceGVR, nil},
+	{"Ingress", ingressGVR, func(n appNames) string { return n.Ingress }},
+	{"Middleware", middlewareGVR, func(n appNames) string { return n.Middleware }},
+	{"HorizontalPodAutoscaler", autoscalerGVR, func(n appNames) string { return n.Autoscaler }},
+	{"PodDisruptionBudget", disruptionBudgetGVR, func(n appNames) string { return n.DisruptionBudget }},
+	{"Service", serviceGVR, func(n appNames) string { return n.Service }},
+	{"Deployment", deploymentGVR, func(n appNames) string { return n.Deployment }},
+}
+
+// appObjectRef names a single object of an app.
+type appObjectRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// resolveAppName returns the app called name. Callers may name an app either
+// directly or through its Deployment.
+func resolveAppName(ctx context.Context, dc dynamic.Interface, namespace, name string) string {
+	dep, err := dc.Resource(deploymentGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil || dep.GetLabels()[ManagedByLabel] != ManagedByValue {
+		return name
+	}
+	if app := dep.GetLabels()[AppLabel]; app != "" {
+		return app
+	}
+	if app := dep.GetLabels()["app"]; app != "" {
+		return app
+	}
+	return name
+}
+
+// findAppObjects returns every object of app in namespace in appResources
+// order, found by appSelector. Objects created before they carried AppLabel
+// are found by name instead. Kinds whose CRD is not installed are skipped.
+func findAppObjects(ctx context.Context, dc dynamic.Interface, namespace, app string) ([]appObject, error) {
+	names := appNamesFor(app)
+	var found []appObject
+	for _, res := range appResources {
+		client := dc.Resource(res.GVR).Namespace(namespace)
+		list, err := client.List(ctx, metav1.ListOptions{LabelSelector: appSelector(app)})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list %s objects: %w", res.Kind, err)
+		}
+		items := list.Items
+		if res.LegacyName != nil {
+			legacy := res.LegacyName(names)
+			if !slices.ContainsFunc(items, func(u unstructured.Unstructured) bool { return u.GetName() == legacy }) {
+				obj, err := client.Get(ctx, legacy, metav1.GetOptions{})
+				switch {
+				case err == nil:
+					items = append(items, *obj)
+				case !apierrors.IsNotFound(err):
+					return nil, fmt.Errorf("get %s %s: %w", res.Kind, legacy, err)
+				}
+			}
+		}
+		for _, item := range items {
+			item.SetAPIVersion(res.GVR.GroupVersion().String())
+			item.SetKind(res.Kind)
+			found = append(found, appObject{GVR: res.GVR, Object: &item})
+		}
+	}
+	return found, nil
+}
+
+// deleteApp deletes every object findAppObjects finds for app. Deletion
+// carries on past errors, which are joined in the returned error.
+func deleteApp(ctx context.Context, dc dynamic.Interface, namespace, app string) ([]appObjectRef, error) {
+	objects, err := findAppObjects(ctx, dc, namespace, app)
+	if err != nil {
+		return []appObjectRef{}, err
+	}
+
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+	deleted := []appObjectRef{}
+	var errs []error
+	for _, o := range objects {
+		kind, name := o.Object.GetKind(), o.Object.GetName()
+		err := dc.Resource(o.GVR).Namespace(namespace).Delete(ctx, name, opts)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete %s %s: %w", kind, name, err))
+			continue
+		}
+		deleted = append(deleted, appObjectRef{Kind: kind, Name: name})
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// ErrMissingReference is returned when an app refers to a ConfigMap, Secret
+// or key that does not exist.
+var ErrMissingReference = errors.New("referenced objects do not exist")
+
+// checkReferences verifies that every ConfigMap and Secret spec refers to
+// exists in namespace, along with the keys its env vars select.
+func checkReferences(ctx context.Context, cs kubernetes.Interface, namespace string, spec corev1.PodSpec) error {
+	configMapNames, secretNames := podSpecReferences(spec)
+	configMaps := map[string]map[string]bool{}
+	for _, name := range configMapNames {
+		if _, ok := configMaps[name]; ok {
+			continue
+		}
+		cm, err := cs.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configMaps[name] = nil
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get configmap %s: %w", name, err)
+		}
+		keys := map[string]bool{}
+		for key := range cm.Data {
+			keys[key] = true
+		}
+		for key := range cm.BinaryData {
+			keys[key] = true
+		}
+		configMaps[name] = keys
+	}
+	secrets := map[string]map[string]bool{}
+	for _, name := range secretNames {
+		if _, ok := secrets[name]; ok {
+			continue
+		}
+		secret, err := cs.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			secrets[name] = nil
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get secret %s: %w", name, err)
+		}
+		keys := map[string]bool{}
+		for key := range secret.Data {
+			keys[key] = true
+		}
+		secrets[name] = keys
+	}
+
+	var missing []string
+	for _, name := range slices.Sorted(maps.Keys(configMaps)) {
+		if configMaps[name] == nil {
+			missing = append(missing, "configmap "+name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(secrets)) {
+		if secrets[name] == nil {
+			missing = append(missing, "secret "+name)
+		}
+	}
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil && configMaps[ref.Name] != nil && !configMaps[ref.Name][ref.Key] {
+				missing = append(missing, fmt.Sprintf("key %s in configmap %s", ref.Key, ref.Name))
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil && secrets[ref.Name] != nil && !secrets[ref.Name][ref.Key] {
+				missing = append(missing, fmt.Sprintf("key %s in secret %s", ref.Key, ref.Name))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w in namespace %s: %s", ErrMissingReference, namespace, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+type appliedObject struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// handleApplyApp creates or updates every object of an app with server-side
+// apply. Applying the same body twice leaves the objects untouched; changing
+// it updates them in place.
+func (h *Handler) handleApplyApp() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace, name := r.PathValue("namespace"), r.PathValue("name")
+
+		var in DeploymentRequest
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+		// The path names the app; the body may repeat it but not contradict it
+		if (in.Namespace != "" && in.Namespace != namespace) || (in.DeploymentName != "" && in.DeploymentName != name) {
+			http.Error(w, "namespace and deploymentName must match the path", http.StatusBadRequest)
+			return
+		}
+		in.Namespace, in.DeploymentName = namespace, name
+		if err := validateDeploymentRequestBody(in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain := h.domainFor(cluster)
+		inProgress, err := releaseInProgress(r.Context(), cluster, namespace, name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get release: %v", err), kubeErrorStatus(err))
+			return
+		}
+		if inProgress {
+			http.Error(w, fmt.Sprintf("a release of app %s is in progress; promote or abort it first", name), http.StatusConflict)
+			return
+		}
+
+		// A paused app stays paused, and an autoscaled app keeps the replica
+		// count its autoscaler settled on
+		live, err := cluster.Clientset.AppsV1().Deployments(namespace).Get(r.Context(), appNamesFor(name).Deployment, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), kubeErrorStatus(err))
+			return
+		case paused(live):
+			http.Error(w, fmt.Sprintf("deployment %s is paused; resume it first", live.Name), http.StatusConflict)
+			return
+		case in.Autoscaling != nil && live.Spec.Replicas != nil:
+			in.Replicas = *live.Spec.Replicas
+		}
+
+		if in, err = withAvailabilityTier(r.Context(), cluster.Clientset, in); err != nil {
+			http.Error(w, fmt.Sprintf("failed to resolve availability tier: %v", err), kubeErrorStatus(err))
+			return
+		}
+
+		objects, err := appManifests(in, domain)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to render app: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// appManifests renders the Deployment first
+		objects[0].Object.SetAnnotations(changeAnnotations(r, "applied image "+in.Image))
+
+		// Referenced ConfigMaps and Secrets must exist before anything is created
+		if err := checkReferences(r.Context(), cluster.Clientset, namespace, appDeployment(in).Spec.Template.Spec); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrMissingReference) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if _, err := ensureNamespace(r.Context(), cluster.Clientset, namespace, false); err != nil {
+			http.Error(w, fmt.Sprintf("failed to create namespace: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if _, err := ensureTLSSecret(r.Context(), cluster.Clientset, namespace, domain, false); err != nil {
+			http.Error(w, fmt.Sprintf("failed to create TLS secret: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Force takes over fields last written by other managers, such as a
+		// replica count scaled through PUT /deployments
+		opts := metav1.ApplyOptions{FieldManager: FieldManager, Force: true}
+		applied := make([]appliedObject, 0, len(objects))
+		for _, o := range objects {
+			res, err := cluster.Dynamic.Resource(o.GVR).Namespace(namespace).Apply(r.Context(), o.Object.GetName(), o.Object, opts)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to apply %s %s: %v", o.Object.GetKind(), o.Object.GetName(), err), http.StatusInternalServerError)
+				return
+			}
+			applied = append(applied, appliedObject{Kind: o.Object.GetKind(), Name: res.GetName(), ResourceVersion: res.GetResourceVersion()})
+		}
+
+		// An app whose ports are no longer public keeps no routing behind, one
+		// no longer autoscaled no autoscaler, and one without a disruption
+		// budget no PodDisruptionBudget
+		deleted := []appObjectRef{}
+		for _, res := range appResources {
+			switch res.GVR {
+			case middlewareGVR, ingressGVR, autoscalerGVR, disruptionBudgetGVR:
+			default:
+				continue
+			}
+			if slices.ContainsFunc(objects, func(o appObject) bool { return o.GVR == res.GVR }) {
+				continue
+			}
+			stale := res.LegacyName(appNamesFor(name))
+			err := cluster.Dynamic.Resource(res.GVR).Namespace(namespace).Delete(r.Context(), stale, metav1.DeleteOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to delete %s %s: %v", res.Kind, stale, err), http.StatusInternalServerError)
+				return
+			}
+			deleted = append(deleted, appObjectRef{Kind: res.Kind, Name: stale})
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "app applied",
+			"cluster", cluster.Name, "namespace", namespace, "app", name)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, appNamesFor(name).Deployment, wait, struct {
+			Namespace string          `json:"namespace"`
+			Name      string          `json:"name"`
+			Objects   []appliedObject `json:"objects"`
+			Deleted   []appObjectRef  `json:"deleted,omitempty"`
+		}{namespace, name, applied, deleted})
+	}
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// recordApplies makes dc accept every apply and remembers the patches, keyed
+// by "<resource>/<name>".
+func recordApplies(dc *dynamicfake.FakeDynamicClient) func() map[string]string {
+	var mu sync.Mutex
+	applied := map[string]string{}
+	dc.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		mu.Lock()
+		applied[patch.GetResource().Resource+"/"+patch.GetName()] = string(patch.GetPatch())
+		mu.Unlock()
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		obj.SetResourceVersion("1")
+		return true, obj, nil
+	})
+	return func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(applied)
+	}
+}
+
+func TestHandleApplyApp(t *testing.T) {
+	s := newTestServer(t)
+	s.handle("PUT /apps/{namespace}/{name}", s.h.handleApplyApp())
+	applied := recordApplies(s.dynamic)
+	apply := func(target, body string) *httptest.ResponseRecorder {
+		return s.serve(http.MethodPut, target, body)
+	}
+
+	const body = `{"image":"nginx:1.27","replicas":2,"ports":[{"containerPort":8080}],
+		"resources":{"cpuLimits":"500m","cpuRequests":"100m","memoryLimits":"256Mi","memoryRequests":"128Mi"}}`
+
+	rec := apply("/apps/apps/web", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	first := applied()
+	for _, key := range []string{
+		"deployments/web-deployment",
+		"services/web-deployment-service",
+		"middlewares/strip-web-deployment-prefix",
+		"ingresses/web-ingress",
+	} {
+		if _, ok := first[key]; !ok {
+			t.Errorf("expected %s to be applied, got %v", key, first)
+		}
+	}
+	if _, err := s.clientset.CoreV1().Namespaces().Get(t.Context(), "apps", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected namespace to be created: %v", err)
+	}
+	if _, err := s.clientset.CoreV1().Secrets("apps").Get(t.Context(), TLSSecretName, metav1.GetOptions{}); err != nil {
+		t.Errorf("expected TLS secret to be created: %v", err)
+	}
+	if strings.Contains(first["deployments/web-deployment"], "creationTimestamp") {
+		t.Errorf("applied deployment claims creationTimestamp: %s", first["deployments/web-deployment"])
+	}
+
+	t.Run("Same body applies the same objects", func(t *testing.T) {
+		if rec := apply("/apps/apps/web", body); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		for key, patch := range applied() {
+			if first[key] != patch {
+				t.Errorf("%s changed between identical applies", key)
+			}
+		}
+	})
+
+	t.Run("Changed body updates in place", func(t *testing.T) {
+		changed := strings.Replace(body, "nginx:1.27", "nginx:1.28", 1)
+		if rec := apply("/apps/apps/web", changed); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if patch := applied()["deployments/web-deployment"]; !strings.Contains(patch, "nginx:1.28") {
+			t.Errorf("expected the new image to be applied, got %s", patch)
+		}
+	})
+
+	t.Run("Internal ports only drop the routing", func(t *testing.T) {
+		var deleted []string
+		s.dynamic.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			// The app never had an autoscaler, which the tracker reports
+			if action.GetResource() == autoscalerGVR {
+				return false, nil, nil
+			}
+			deleted = append(deleted, action.GetResource().Resource+"/"+action.(k8stesting.DeleteAction).GetName())
+			return true, nil, nil
+		})
+		internal := strings.Replace(body, `{"containerPort":8080}`, `{"containerPort":8080,"expose":"internal"}`, 1)
+		rec := apply("/apps/apps/web", internal)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		want := []string{"ingresses/web-ingress", "middlewares/strip-web-deployment-prefix"}
+		if !slices.Equal(deleted, want) {
+			t.Errorf("expected %v to be deleted, got %v", want, deleted)
+		}
+		var got struct{ Deleted []appObjectRef }
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got.Deleted) != 2 {
+			t.Errorf("expected the deleted objects in the response, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Autoscaling", func(t *testing.T) {
+		autoscaled := strings.Replace(body, `"replicas":2,`, `"autoscaling":{"minReplicas":3,"maxReplicas":6,"targetCPUUtilization":70},`, 1)
+		if rec := apply("/apps/apps/web", autoscaled); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if patch := applied()["horizontalpodautoscalers/web-deployment"]; !strings.Contains(patch, `"maxReplicas":6`) {
+			t.Errorf("expected the autoscaler to be applied, got %s", patch)
+		}
+		if patch := applied()["deployments/web-deployment"]; !strings.Contains(patch, `"replicas":3`) {
+			t.Errorf("expected the deployment to start at minReplicas, got %s", patch)
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		for name, tc := range map[string]struct{ target, body string }{
+			"Body contradicts path": {"/apps/apps/web", `{"deploymentName":"api"}`},
+			"Invalid quantity":      {"/apps/apps/web", strings.Replace(body, "500m", "lots", 1)},
+			"Missing image":         {"/apps/apps/web", strings.Replace(body, `"image":"nginx:1.27",`, "", 1)},
+		} {
+			if rec := apply(tc.target, tc.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d: %s", name, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
+
+func TestHandleDeploymentDeletion(t *testing.T) {
+	object := func(apiVersion, kind, name string, labels map[string]string) runtime.Object {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace("apps")
+		u.SetName(name)
+		u.SetLabels(labels)
+		return u
+	}
+	newServer := func(t *testing.T, objects ...runtime.Object) *testServer {
+		t.Helper()
+		s := newTestServer(t, append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}})...)
+		s.handle("DELETE /deployments/{namespace}/{deploymentName}", s.h.handleDeploymentDeletion())
+		return s
+	}
+	remove := func(s *testServer, name string) *httptest.ResponseRecorder {
+		return s.serve(http.MethodDelete, "/deployments/apps/"+name, "")
+	}
+	type report struct {
+		App              string         `json:"app"`
+		Deleted          []appObjectRef `json:"deleted"`
+		NamespaceDeleted bool           `json:"namespaceDeleted"`
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) report {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var got report
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+
+	web := appLabels("web")
+	objects := []runtime.Object{
+		object("apps/v1", "Deployment", "web-deployment", web),
+		object("v1", "Service", "web-deployment-service", web),
+		object("networking.k8s.io/v1", "Ingress", "web-ingress", web),
+		object("traefik.io/v1alpha1", "Middleware", "strip-web-deployment-prefix", web),
+		object("v1", "Service", "web-extra", web),
+		object("v1", "Service", "api-deployment-service", appLabels("api")),
+	}
+
+	t.Run("Deletes every labelled object", func(t *testing.T) {
+		s := newServer(t, objects...)
+		got := decode(t, remove(s, "web"))
+		if got.App != "web" || len(got.Deleted) != 5 {
+			t.Fatalf("expected the five web objects to be deleted, got %+v", got)
+		}
+		if !slices.Contains(got.Deleted, appObjectRef{Kind: "Middleware", Name: "strip-web-deployment-prefix"}) {
+			t.Errorf("expected the middleware to be deleted, got %+v", got.Deleted)
+		}
+		services, _ := s.dynamic.Resource(serviceGVR).Namespace("apps").List(t.Context(), metav1.ListOptions{})
+		if len(services.Items) != 1 || services.Items[0].GetName() != "api-deployment-service" {
+			t.Errorf("expected only the api service to remain, got %d services", len(services.Items))
+		}
+	})
+
+	t.Run("Resolves the app from its deployment name", func(t *testing.T) {
+		s := newServer(t, objects...)
+		if got := decode(t, remove(s, "web-deployment")); got.App != "web" || len(got.Deleted) != 5 {
+			t.Errorf("expected app web to be deleted, got %+v", got)
+		}
+	})
+
+	t.Run("Finds objects created before labels", func(t *testing.T) {
+		s := newServer(t,
+			object("apps/v1", "Deployment", "old-deployment", map[string]string{"app": "old", ManagedByLabel: ManagedByValue}),
+			object("v1", "Service", "old-deployment-service", map[string]string{"app": "old"}),
+			object("traefik.io/v1alpha1", "Middleware", "strip-old-deployment-prefix", nil),
+		)
+		got := decode(t, remove(s, "old"))
+		if len(got.Deleted) != 3 {
+			t.Errorf("expected 3 legacy objects to be deleted, got %+v", got.Deleted)
+		}
+		if !got.NamespaceDeleted {
+			t.Error("expected the emptied namespace to be deleted")
+		}
+		if _, err := s.clientset.CoreV1().Namespaces().Get(t.Context(), "apps", metav1.GetOptions{}); err == nil {
+			t.Error("expected namespace apps to be gone")
+		}
+	})
+
+	t.Run("Unknown app", func(t *testing.T) {
+		s := newServer(t, objects...)
+		if rec := remove(s, "missing"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestCheckReferences(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "apps"}, Data: map[string]string{"mode": "fast"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}, Data: map[string][]byte{"password": []byte("x")}},
+	)
+	check := func(modify func(*DeploymentRequest)) error {
+		req := testDeploymentRequest()
+		modify(&req)
+		return checkReferences(t.Context(), clientset, "apps", appDeployment(req).Spec.Template.Spec)
+	}
+
+	if err := check(func(r *DeploymentRequest) {
+		r.Env = []EnvVar{
+			{Name: "MODE", ConfigMapKeyRef: &KeyRef{Name: "settings", Key: "mode"}},
+			{Name: "PASSWORD", SecretKeyRef: &KeyRef{Name: "db", Key: "password"}},
+		}
+		r.Volumes = []VolumeRequest{{Name: "config", MountPath: "/etc/app", ConfigMap: "settings"}}
+	}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := check(func(r *DeploymentRequest) {
+		r.EnvFrom = []EnvFromSource{{Secret: "api-keys"}}
+		r.Env = []EnvVar{{Name: "USER", SecretKeyRef: &KeyRef{Name: "db", Key: "user"}}}
+		r.Volumes = []VolumeRequest{{Name: "config", MountPath: "/etc/app", ConfigMap: "other"}}
+	})
+	if !errors.Is(err, ErrMissingReference) {
+		t.Fatalf("expected ErrMissingReference, got %v", err)
+	}
+	for _, want := range []string{"configmap other", "secret api-keys", "key user in secret db"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestAppPorts(t *testing.T) {
+	domain := DomainConfig{Domain: "apps.example.com"}
+	req := testDeploymentRequest()
+	req.Ports = []PortRequest{
+		{ContainerPort: 8080},
+		{Name: "metrics", ContainerPort: 9090, Expose: ExposePublic},
+		{ContainerPort: 9000, Expose: ExposePublic, Host: "grpc.example.com"},
+		{ContainerPort: 5353, Protocol: corev1.ProtocolUDP},
+	}
+
+	t.Run("Every port is a named Service port", func(t *testing.T) {
+		var got []string
+		for _, p := range appService(req).Spec.Ports {
+			got = append(got, fmt.Sprintf("%s=%d/%s", p.Name, p.Port, p.Protocol))
+		}
+		want := []string{"tcp-8080=8080/TCP", "metrics=9090/TCP", "tcp-9000=9000/TCP", "udp-5353=5353/UDP"}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+		if ports := appDeployment(req).Spec.Template.Spec.Containers[0].Ports; ports[3].Name != "udp-5353" || ports[3].Protocol != corev1.ProtocolUDP {
+			t.Errorf("unexpected container ports: %+v", ports)
+		}
+	})
+
+	t.Run("Public ports are routed", func(t *testing.T) {
+		ing := appIngress(req, domain)
+		var got []string
+		for _, rule := range ing.Spec.Rules {
+			for _, path := range rule.HTTP.Paths {
+				got = append(got, fmt.Sprintf("%s%s=%d", rule.Host, path.Path, path.Backend.Service.Port.Number))
+			}
+		}
+		want := []string{
+			"apps.example.com/web-deployment=8080",
+			"apps.example.com/web-deployment/metrics=9090",
+			"grpc.example.com/=9000",
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+		if hosts := ing.Spec.TLS[0].Hosts; !slices.Equal(hosts, []string{"apps.example.com", "grpc.example.com"}) {
+			t.Errorf("unexpected TLS hosts: %v", hosts)
+		}
+		// The nested path is stripped before its parent can match
+		regex, _, _ := unstructured.NestedSlice(appMiddleware(req).Object, "spec", "stripPrefixRegex", "regex")
+		if !slices.Equal(regex, []any{"^/web-deployment/metrics", "^/web-deployment"}) {
+			t.Errorf("unexpected strip regexes: %v", regex)
+		}
+	})
+
+	t.Run("Internal ports only", func(t *testing.T) {
+		internal := testDeploymentRequest()
+		internal.Ports[0].Expose = ExposeInternal
+		if appIngress(internal, domain) != nil || appMiddleware(internal) != nil {
+			t.Error("expected no Ingress or Middleware for an app without public ports")
+		}
+		objects, err := appManifests(internal, domain)
+		if err != nil || len(objects) != 2 {
+			t.Errorf("expected only the Deployment and Service, got %d objects (%v)", len(objects), err)
+		}
+	})
+
+	t.Run("Host routes need no middleware", func(t *testing.T) {
+		hosted := testDeploymentRequest()
+		hosted.Ports[0].Host = "web.example.com"
+		if appMiddleware(hosted) != nil {
+			t.Error("expected no Middleware")
+		}
+		if _, ok := appIngress(hosted, domain).Annotations["traefik.ingress.kubernetes.io/router.middlewares"]; ok {
+			t.Error("expected the Ingress not to refer to a Middleware")
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/internal/audit"
+)
+
+// maxAuditedBody is the number of request body bytes covered by the request
+// digest. It matches the largest body the handlers accept.
+const maxAuditedBody = 1 << 20
+
+// maxAuditedError is the number of bytes of an error response kept in an event.
+const maxAuditedError = 512
+
+// auditEntry collects what handlers learn about a request while it is served.
+type auditEntry struct {
+	mu       sync.Mutex
+	identity *Identity
+	scope    *Scope
+	objects  []string
+}
+
+type auditEntryKey struct{}
+
+func auditEntryFrom(ctx context.Context) *auditEntry {
+	e, _ := ctx.Value(auditEntryKey{}).(*auditEntry)
+	return e
+}
+
+// auditIdentity records the authenticated caller of the request in ctx.
+func auditIdentity(ctx context.Context, id *Identity) {
+	if e := auditEntryFrom(ctx); e != nil {
+		e.mu.Lock()
+		e.identity = id
+		e.mu.Unlock()
+	}
+}
+
+// auditScope records the cluster and namespace the request acts on.
+func auditScope(ctx context.Context, scope Scope) {
+	if e := auditEntryFrom(ctx); e != nil {
+		e.mu.Lock()
+		e.scope = &scope
+		e.mu.Unlock()
+	}
+}
+
+// auditObjects records the names of the objects the request acts on.
+func auditObjects(ctx context.Context, names ...string) {
+	if e := auditEntryFrom(ctx); e != nil {
+		e.mu.Lock()
+		e.objects = append(e.objects, names...)
+		e.mu.Unlock()
+	}
+}
+
+// auditResponseWriter captures the status and the start of an error body.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.body.Len() < maxAuditedError {
+		w.body.Write(b[:min(len(b), maxAuditedError-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// audited reports whether requests with method change state and are audited.
+func audited(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// serveAudited serves r through the mux and writes an audit event afterwards.
+func (h *Handler) serveAudited(w http.ResponseWriter, r *http.Request) {
+	entry := new(auditEntry)
+	r = r.WithContext(context.WithValue(r.Context(), auditEntryKey{}, entry))
+
+	// Digest the body as it is read, and whatever the handler left unread
+	digest := sha256.New()
+	body := r.Body
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(io.LimitReader(body, maxAuditedBody), digest), body}
+
+	start := time.Now()
+	rw := &auditResponseWriter{ResponseWriter: w}
+	h.mux.ServeHTTP(rw, r)
+	_, _ = io.Copy(digest, io.LimitReader(body, maxAuditedBody))
+
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	event := audit.Event{
+		Time:          start.UTC(),
+		Actor:         "anonymous",
+		RemoteAddr:    r.RemoteAddr,
+		Method:        r.Method,
+		Route:         r.Pattern,
+		Path:          r.URL.Path,
+		Cluster:       clusterFromRequest(r),
+		Namespace:     r.PathValue("namespace"),
+		RequestDigest: "sha256:" + hex.EncodeToString(digest.Sum(nil)),
+		Status:        rw.status,
+		Outcome:       audit.OutcomeFor(rw.status),
+	}
+	if rw.status >= 400 {
+		event.Error = strings.TrimSpace(rw.body.String())
+	}
+
+	entry.mu.Lock()
+	if entry.identity != nil {
+		event.Actor = entry.identity.Subject
+		event.Groups = entry.identity.Groups
+	}
+	if entry.scope != nil {
+		event.Cluster, event.Namespace = entry.scope.Cluster, entry.scope.Namespace
+	}
+	event.Objects = slices.Clone(entry.objects)
+	entry.mu.Unlock()
+
+	// Fall back to the names in the path when the handler did not say
+	if len(event.Objects) == 0 {
+		for _, key := range []string{"deploymentName", "clusterName", "podname", "name"} {
+			if v := r.PathValue(key); v != "" {
+				event.Objects = append(event.Objects, v)
+			}
+		}
+	}
+
+	// The request is done; a slow sink must not be cancelled with it
+	if err := h.auditSink.Write(context.WithoutCancel(r.Context()), event); err != nil {
+		h.logger.ErrorCtx(r.Context(), "failed to write audit event",
+			"method", event.Method, "path", event.Path, "actor", event.Actor, "err", err)
+	}
+}
+
+func (h *Handler) handleAuditQuery() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		querier, ok := h.auditSink.(audit.Querier)
+		if !ok {
+			http.Error(w, audit.ErrNotQueryable.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		q := r.URL.Query()
+		filter := audit.Filter{
+			Actor:     q.Get("actor"),
+			Cluster:   q.Get("cluster"),
+			Namespace: q.Get("namespace"),
+			Method:    strings.ToUpper(q.Get("method")),
+			Outcome:   audit.Outcome(q.Get("outcome")),
+		}
+		for key, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := q.Get(key); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					http.Error(w, key+" must be an RFC 3339 timestamp", http.StatusBadRequest)
+					return
+				}
+				*dst = t
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		// Only return events from clusters the caller may read the audit log of
+		if filter.Cluster != "" && !h.authorizeScope(w, r, PermAuditRead, Scope{Cluster: filter.Cluster}) {
+			return
+		}
+		id, _ := IdentityFromContext(r.Context())
+		filter.Visible = func(event audit.Event) bool {
+			return h.authorizer.Authorize(id, PermAuditRead, Scope{Cluster: event.Cluster}) == nil
+		}
+
+		events, err := querier.Query(r.Context(), filter)
+		if errors.Is(err, audit.ErrNotQueryable) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "failed to query audit log", "err", err)
+			http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+			return
+		}
+		if events == nil {
+			events = []audit.Event{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(events)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ClappFormOrg/AI-CO/go/internal/audit"
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+)
+
+func TestAudit(t *testing.T) {
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+
+	authenticator, err := NewStaticTokenAuthenticator([]StaticToken{{Token: "alice-token", Subject: "alice"}, {Token: "bob-token", Subject: "bob"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &Handler{
+		mux:           new(http.ServeMux),
+		logger:        log.NewNoOpLogger(),
+		auditSink:     sink,
+		authenticator: authenticator,
+		authorizer: &Policy{Bindings: []RoleBinding{
+			{Role: RoleClusterAdmin, Subjects: []string{"alice"}},
+			{Role: RoleClusterAdmin, Subjects: []string{"bob"}, Clusters: []string{"edge"}},
+		}},
+	}
+	h.mux.HandleFunc("POST /secrets", AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ Namespace, SecretName string }
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		auditObjects(r.Context(), in.SecretName)
+		if !h.authorize(w, r, PermSecretsWrite, in.Namespace) {
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}, h.authenticator, h.logger))
+	h.mux.HandleFunc("DELETE /deployments/{namespace}/{deploymentName}", h.protect(PermDeploymentsWrite, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+	}))
+	h.mux.HandleFunc("GET /audit", AuthMiddleware(h.handleAuditQuery(), h.authenticator, h.logger))
+
+	do := func(method, target, token, cluster, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if cluster != "" {
+			r.Header.Set("cluster-name", cluster)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	body := `{"namespace":"apps","secretName":"db"}`
+	do(http.MethodPost, "/secrets", "alice-token", "", body)
+	do(http.MethodPost, "/secrets", "bob-token", "", body)
+	do(http.MethodDelete, "/deployments/apps/web", "bob-token", "edge", "")
+	do(http.MethodPost, "/secrets", "", "", body)
+	do(http.MethodGet, "/audit", "alice-token", "", "")
+
+	events, err := sink.Query(context.Background(), audit.Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 audited requests (GET is not audited), got %d: %+v", len(events), events)
+	}
+
+	digest := sha256.Sum256([]byte(body))
+	created := events[3]
+	if created.Actor != "alice" || created.Route != "POST /secrets" || created.Cluster != DefaultClusterName ||
+		created.Namespace != "apps" || strings.Join(created.Objects, ",") != "db" ||
+		created.Status != http.StatusCreated || created.Outcome != audit.OutcomeSuccess ||
+		created.RequestDigest != "sha256:"+hex.EncodeToString(digest[:]) {
+		t.Errorf("unexpected event for created secret: %+v", created)
+	}
+	if denied := events[2]; denied.Actor != "bob" || denied.Outcome != audit.OutcomeDenied || !strings.Contains(denied.Error, "forbidden") {
+		t.Errorf("unexpected event for denied secret: %+v", denied)
+	}
+	if failed := events[1]; failed.Cluster != "edge" || failed.Namespace != "apps" || strings.Join(failed.Objects, ",") != "web" ||
+		failed.Outcome != audit.OutcomeFailure || failed.Error != "deployment not found" {
+		t.Errorf("unexpected event for failed delete: %+v", failed)
+	}
+	if anonymous := events[0]; anonymous.Actor != "anonymous" || anonymous.Status != http.StatusUnauthorized {
+		t.Errorf("unexpected event for unauthenticated request: %+v", anonymous)
+	}
+
+	query := func(token, params string) []audit.Event {
+		t.Helper()
+		rec := do(http.MethodGet, "/audit?"+params, token, "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /audit?%s: expected 200, got %d: %s", params, rec.Code, rec.Body.String())
+		}
+		var got []audit.Event
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+
+	t.Run("Filters", func(t *testing.T) {
+		if got := query("alice-token", "actor=bob&outcome=denied"); len(got) != 1 || got[0].Route != "POST /secrets" {
+			t.Errorf("expected bob's denied request, got %+v", got)
+		}
+		if got := query("alice-token", "limit=2"); len(got) != 2 {
+			t.Errorf("expected 2 events, got %d", len(got))
+		}
+	})
+
+	t.Run("Only visible clusters", func(t *testing.T) {
+		got := query("bob-token", "")
+		if len(got) != 1 || got[0].Cluster != "edge" {
+			t.Errorf("expected only the edge event for bob, got %+v", got)
+		}
+		if rec := do(http.MethodGet, "/audit?cluster="+DefaultClusterName, "bob-token", "", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for a cluster bob cannot audit, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		for _, params := range []string{"since=yesterday", "limit=0"} {
+			if rec := do(http.MethodGet, "/audit?"+params, "alice-token", "", ""); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", params, rec.Code)
+			}
+		}
+	})
+}
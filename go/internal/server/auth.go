@@ -0,0 +1,540 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+)
+
+var (
+	// ErrMissingCredentials is returned when a request carries no bearer token.
+	ErrMissingCredentials = errors.New("missing bearer token")
+
+	// ErrInvalidCredentials is returned when a bearer token is not accepted by
+	// the configured authenticator.
+	ErrInvalidCredentials = errors.New("invalid bearer token")
+)
+
+// Identity describes the authenticated caller of a request.
+type Identity struct {
+	Subject string   `json:"subject"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// Authenticator resolves the Identity of the caller from an incoming request.
+//
+// Implementations return ErrMissingCredentials when the request carries no
+// credentials and an error wrapping ErrInvalidCredentials when the credentials
+// are rejected.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityContextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx by AuthMiddleware.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, error) {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if header == "" {
+		return "", ErrMissingCredentials
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", fmt.Errorf("%w: expected \"Bearer <token>\"", ErrInvalidCredentials)
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// AuthMiddleware authenticates the request with the given Authenticator and
+// stores the resulting Identity in the request context.
+func AuthMiddleware(next http.HandlerFunc, authenticator Authenticator, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := authenticator.Authenticate(r)
+		if err != nil {
+			logger.WarnCtx(r.Context(), "request rejected by authenticator",
+				"method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="aico"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logger.DebugCtx(r.Context(), "request authenticated",
+			"method", r.Method, "path", r.URL.Path, "subject", id.Subject)
+		auditIdentity(r.Context(), id)
+		next(w, r.WithContext(WithIdentity(r.Context(), id)))
+	}
+}
+
+// ChainAuthenticator tries each authenticator in order and returns the first
+// identity that is accepted.
+type ChainAuthenticator []Authenticator
+
+func (c ChainAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	err := ErrMissingCredentials
+	for _, a := range c {
+		id, aerr := a.Authenticate(r)
+		if aerr == nil {
+			return id, nil
+		}
+		if !errors.Is(aerr, ErrMissingCredentials) {
+			err = aerr
+		}
+	}
+	return nil, err
+}
+
+// StaticToken binds a bearer token to the identity it authenticates as.
+type StaticToken struct {
+	Token   string   `json:"token"`
+	Subject string   `json:"subject"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// StaticTokenAuthenticator authenticates requests against a fixed set of
+// bearer tokens. Only SHA-256 digests of the tokens are kept in memory.
+type StaticTokenAuthenticator struct {
+	tokens map[[sha256.Size]byte]Identity
+}
+
+// NewStaticTokenAuthenticator creates an authenticator for the given tokens.
+// An authenticator without tokens rejects every request.
+func NewStaticTokenAuthenticator(tokens []StaticToken) (*StaticTokenAuthenticator, error) {
+	a := &StaticTokenAuthenticator{tokens: make(map[[sha256.Size]byte]Identity, len(tokens))}
+	for i, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("tokens[%d].token is required", i)
+		}
+		if t.Subject == "" {
+			return nil, fmt.Errorf("tokens[%d].subject is required", i)
+		}
+		sum := sha256.Sum256([]byte(t.Token))
+		if _, exists := a.tokens[sum]; exists {
+			return nil, fmt.Errorf("tokens[%d] is a duplicate token", i)
+		}
+		a.tokens[sum] = Identity{Subject: t.Subject, Groups: slices.Clone(t.Groups)}
+	}
+	return a, nil
+}
+
+// NewStaticTokenAuthenticatorFromFile loads a JSON array of StaticToken from path.
+func NewStaticTokenAuthenticatorFromFile(path string) (*StaticTokenAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []StaticToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse token file %q: %w", path, err)
+	}
+	return NewStaticTokenAuthenticator(tokens)
+}
+
+func (a *StaticTokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(token))
+	for digest, id := range a.tokens {
+		if subtle.ConstantTimeCompare(digest[:], sum[:]) == 1 {
+			return &Identity{Subject: id.Subject, Groups: slices.Clone(id.Groups)}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// JWTConfig configures a JWTAuthenticator.
+type JWTConfig struct {
+	Issuer        string        // Expected "iss" claim, skipped when empty.
+	Audience      string        // Required entry in the "aud" claim, skipped when empty.
+	JWKSFile      string        // Path to a JSON Web Key Set, mutually exclusive with JWKSURL.
+	JWKSURL       string        // URL of a JSON Web Key Set, refreshed on unknown key IDs.
+	SubjectClaim  string        // Claim holding the subject, defaults to "sub".
+	GroupsClaim   string        // Claim holding the groups, defaults to "groups".
+	Leeway        time.Duration // Allowed clock skew for "exp" and "nbf".
+	RefreshPeriod time.Duration // Minimum time between two JWKS downloads.
+	HTTPClient    *http.Client  // Client used to download JWKSURL.
+}
+
+// JWTAuthenticator validates OIDC ID tokens and other JWT bearer tokens
+// against a JSON Web Key Set.
+type JWTAuthenticator struct {
+	cfg JWTConfig
+	now func() time.Time
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+}
+
+// NewJWTAuthenticator creates a JWTAuthenticator and loads its initial key set.
+func NewJWTAuthenticator(cfg JWTConfig) (*JWTAuthenticator, error) {
+	if (cfg.JWKSFile == "") == (cfg.JWKSURL == "") {
+		return nil, errors.New("exactly one of JWKS file or JWKS URL must be configured")
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = time.Minute
+	}
+	if cfg.RefreshPeriod == 0 {
+		cfg.RefreshPeriod = 5 * time.Minute
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	a := &JWTAuthenticator{cfg: cfg, now: time.Now}
+	if err := a.refreshKeys(context.Background()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *JWTAuthenticator) refreshKeys(ctx context.Context) error {
+	var data []byte
+	if a.cfg.JWKSFile != "" {
+		var err error
+		data, err = os.ReadFile(a.cfg.JWKSFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.JWKSURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := a.cfg.HTTPClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("fetch JWKS: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("fetch JWKS: unexpected status %s", resp.Status)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return fmt.Errorf("read JWKS: %w", err)
+		}
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.lastFetch = a.now()
+	a.mu.Unlock()
+	return nil
+}
+
+// key returns the public key for kid, refreshing a remote key set at most
+// once per RefreshPeriod when the key is unknown.
+func (a *JWTAuthenticator) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	a.mu.RLock()
+	key, ok := a.lookupKey(kid)
+	stale := a.now().Sub(a.lastFetch) >= a.cfg.RefreshPeriod
+	a.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if a.cfg.JWKSURL != "" && stale {
+		if err := a.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		a.mu.RLock()
+		key, ok = a.lookupKey(kid)
+		a.mu.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey must be called with a.mu held. A token without a key ID matches
+// only when the set contains exactly one key.
+func (a *JWTAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, k := range a.keys {
+			return k, true
+		}
+	}
+	k, ok := a.keys[kid]
+	return k, ok
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := a.verify(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	subject, _ := claims[a.cfg.SubjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: claim %q is missing", ErrInvalidCredentials, a.cfg.SubjectClaim)
+	}
+	return &Identity{Subject: subject, Groups: stringsClaim(claims[a.cfg.GroupsClaim])}, nil
+}
+
+func (a *JWTAuthenticator) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+
+	now := a.now()
+	exp, ok := numericClaim(claims["exp"])
+	if !ok {
+		return nil, errors.New("claim \"exp\" is missing")
+	}
+	if now.After(exp.Add(a.cfg.Leeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := numericClaim(claims["nbf"]); ok && now.Add(a.cfg.Leeway).Before(nbf) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if a.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.cfg.Issuer {
+			return nil, fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+	if a.cfg.Audience != "" && !slices.Contains(stringsClaim(claims["aud"]), a.cfg.Audience) {
+		return nil, fmt.Errorf("token is not issued for audience %q", a.cfg.Audience)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func numericClaim(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// stringsClaim accepts both a single string and an array of strings.
+func stringsClaim(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[0] {
+		case 'R':
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case 'P':
+			return rsa.VerifyPSS(k, hash, digest, signature, nil)
+		}
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signing algorithm %q does not match key type %T", alg, key)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the RSA and EC signing keys of a JSON Web Key Set.
+// Keys of other types or meant for encryption are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaPublicKey()
+		case "EC":
+			key, err = jwk.ecdsaPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS keys[%d]: %w", i, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent out of range")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (jwk jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("decode x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decode y: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) > size || len(y) > size {
+		return nil, errors.New("coordinates out of range")
+	}
+	point := make([]byte, 1+2*size)
+	point[0] = 4 // uncompressed
+	copy(point[1+size-len(x):1+size], x)
+	copy(point[1+2*size-len(y):], y)
+	return ecdsa.ParseUncompressedPublicKey(curve, point)
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+)
+
+// --- Helpers to build signed tokens ---
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func writeJWKS(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) string {
+	t.Helper()
+	ecPub, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatalf("encode EC key: %v", err)
+	}
+	set := map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(rsaKey.N.Bytes()),
+			"e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64(ecPub[1:33]),
+			"y": b64(ecPub[33:]),
+		},
+		{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write JWKS: %v", err)
+	}
+	return path
+}
+
+func requestWithToken(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/clusters", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	a, err := NewStaticTokenAuthenticator([]StaticToken{
+		{Token: "s3cr3t", Subject: "ci", Groups: []string{"deployers"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		id, err := a.Authenticate(requestWithToken("s3cr3t"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id.Subject != "ci" || !slices.Equal(id.Groups, []string{"deployers"}) {
+			t.Errorf("expected ci/[deployers], got %s/%v", id.Subject, id.Groups)
+		}
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		if _, err := a.Authenticate(requestWithToken("nope")); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected ErrInvalidCredentials, got %v", err)
+		}
+	})
+
+	t.Run("Missing header", func(t *testing.T) {
+		if _, err := a.Authenticate(requestWithToken("")); !errors.Is(err, ErrMissingCredentials) {
+			t.Errorf("expected ErrMissingCredentials, got %v", err)
+		}
+	})
+
+	t.Run("Raw token without scheme", func(t *testing.T) {
+		r := requestWithToken("")
+		r.Header.Set("Authorization", "s3cr3t")
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected ErrInvalidCredentials, got %v", err)
+		}
+	})
+
+	t.Run("Rejects duplicates", func(t *testing.T) {
+		_, err := NewStaticTokenAuthenticator([]StaticToken{
+			{Token: "a", Subject: "one"},
+			{Token: "a", Subject: "two"},
+		})
+		if err == nil {
+			t.Fatal("expected error for duplicate token, got nil")
+		}
+	})
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+
+	a, err := NewJWTAuthenticator(JWTConfig{
+		Issuer:   "https://issuer.example",
+		Audience: "aico",
+		JWKSFile: writeJWKS(t, rsaKey, ecKey),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	claims := func(mutate func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":    "https://issuer.example",
+			"aud":    []string{"aico", "other"},
+			"sub":    "alice",
+			"groups": []string{"platform"},
+			"exp":    now.Add(time.Hour).Unix(),
+			"nbf":    now.Add(-time.Minute).Unix(),
+		}
+		if mutate != nil {
+			mutate(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "RS256", token: signToken(t, "RS256", "rsa", rsaKey, claims(nil))},
+		{name: "ES256", token: signToken(t, "ES256", "ec", ecKey, claims(nil))},
+		{
+			name:  "Single string audience",
+			token: signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { c["aud"] = "aico" })),
+		},
+		{
+			name:    "Expired",
+			token:   signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "Not valid yet",
+			token:   signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { c["nbf"] = now.Add(time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong issuer",
+			token:   signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { c["iss"] = "https://evil.example" })),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong audience",
+			token:   signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { c["aud"] = "someone-else" })),
+			wantErr: true,
+		},
+		{
+			name:    "Missing subject",
+			token:   signToken(t, "RS256", "rsa", rsaKey, claims(func(c map[string]any) { delete(c, "sub") })),
+			wantErr: true,
+		},
+		{
+			name:    "Signed by unknown key",
+			token:   signToken(t, "RS256", "rsa", otherKey, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "Algorithm does not match key",
+			token:   signToken(t, "ES256", "rsa", ecKey, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown key ID",
+			token:   signToken(t, "RS256", "missing", rsaKey, claims(nil)),
+			wantErr: true,
+		},
+		{name: "Malformed", token: "not-a-jwt", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := a.Authenticate(requestWithToken(tc.token))
+			if tc.wantErr {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("expected ErrInvalidCredentials, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id.Subject != "alice" || !slices.Equal(id.Groups, []string{"platform"}) {
+				t.Errorf("expected alice/[platform], got %s/%v", id.Subject, id.Groups)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	static, err := NewStaticTokenAuthenticator([]StaticToken{{Token: "s3cr3t", Subject: "ci"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got *Identity
+	next := func(w http.ResponseWriter, r *http.Request) {
+		got, _ = IdentityFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		token         string
+		wantStatus    int
+		wantSubject   string
+	}{
+		{name: "Authenticated", authenticator: ChainAuthenticator{static}, token: "s3cr3t", wantStatus: http.StatusOK, wantSubject: "ci"},
+		{name: "Rejected", authenticator: ChainAuthenticator{static}, token: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "No authenticators", authenticator: ChainAuthenticator{}, token: "s3cr3t", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			rec := httptest.NewRecorder()
+			AuthMiddleware(next, tc.authenticator, log.NewNoOpLogger())(rec, requestWithToken(tc.token))
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if tc.wantSubject == "" {
+				if got != nil {
+					t.Errorf("expected handler not to be called, got identity %+v", got)
+				}
+				return
+			}
+			if got == nil || got.Subject != tc.wantSubject {
+				t.Errorf("expected subject %q in context, got %+v", tc.wantSubject, got)
+			}
+		})
+	}
+}
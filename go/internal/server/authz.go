@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+)
+
+// Permission names a single action on a kind of resource.
+type Permission string
+
+const (
+	PermPodsRead         Permission = "pods:read"
+	PermDeploymentsRead  Permission = "deployments:read"
+	PermDeploymentsWrite Permission = "deployments:write"
+	PermConfigMapsRead   Permission = "configmaps:read"
+	PermConfigMapsWrite  Permission = "configmaps:write"
+	PermSecretsRead      Permission = "secrets:read"
+	PermSecretsWrite     Permission = "secrets:write"
+	PermClustersRead     Permission = "clusters:read"
+	PermClustersWrite    Permission = "clusters:write"
+	PermAuditRead        Permission = "audit:read"
+)
+
+// Role is a named set of permissions that can be bound to callers.
+type Role string
+
+const (
+	RoleViewer        Role = "viewer"
+	RoleDeployer      Role = "deployer"
+	RoleSecretManager Role = "secret-manager"
+	RoleClusterAdmin  Role = "cluster-admin"
+)
+
+var viewerPermissions = []Permission{
+	PermPodsRead,
+	PermDeploymentsRead,
+	PermConfigMapsRead,
+	PermClustersRead,
+}
+
+// rolePermissions lists the permissions granted by each built-in role.
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:        viewerPermissions,
+	RoleDeployer:      append(slices.Clone(viewerPermissions), PermDeploymentsWrite, PermConfigMapsWrite),
+	RoleSecretManager: append(slices.Clone(viewerPermissions), PermSecretsRead, PermSecretsWrite),
+	RoleClusterAdmin: append(slices.Clone(viewerPermissions),
+		PermDeploymentsWrite, PermConfigMapsWrite, PermSecretsRead, PermSecretsWrite, PermClustersWrite, PermAuditRead),
+}
+
+// Scope identifies the cluster and namespace a permission is checked against.
+// An empty Namespace denotes a cluster-wide action.
+type Scope struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ErrForbidden is returned when an identity lacks a permission in a scope.
+type ErrForbidden struct {
+	Subject    string
+	Permission Permission
+	Scope      Scope
+}
+
+func (e *ErrForbidden) Error() string {
+	if e.Scope.Namespace == "" {
+		return fmt.Sprintf("forbidden: %q is missing permission %q on cluster %q",
+			e.Subject, e.Permission, e.Scope.Cluster)
+	}
+	return fmt.Sprintf("forbidden: %q is missing permission %q on cluster %q namespace %q",
+		e.Subject, e.Permission, e.Scope.Cluster, e.Scope.Namespace)
+}
+
+// Authorizer decides whether an identity holds a permission in a scope.
+// It returns an *ErrForbidden when the permission is not granted.
+type Authorizer interface {
+	Authorize(id *Identity, perm Permission, scope Scope) error
+}
+
+// RoleBinding grants a role to subjects and groups, restricted to clusters and
+// namespaces matching the given path.Match patterns. An empty pattern list
+// matches everything; cluster-wide actions are only granted by bindings
+// that match every namespace.
+type RoleBinding struct {
+	Role       Role     `json:"role"`
+	Subjects   []string `json:"subjects,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Clusters   []string `json:"clusters,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Policy is an Authorizer built from a list of role bindings.
+// A policy without bindings denies every request.
+type Policy struct {
+	Bindings []RoleBinding `json:"bindings"`
+}
+
+// NewPolicy validates the bindings and returns a Policy.
+func NewPolicy(bindings []RoleBinding) (*Policy, error) {
+	for i, b := range bindings {
+		if _, ok := rolePermissions[b.Role]; !ok {
+			return nil, fmt.Errorf("bindings[%d].role %q is unknown", i, b.Role)
+		}
+		if len(b.Subjects) == 0 && len(b.Groups) == 0 {
+			return nil, fmt.Errorf("bindings[%d] must name at least one subject or group", i)
+		}
+		for _, p := range slices.Concat(b.Clusters, b.Namespaces) {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("bindings[%d] has invalid pattern %q: %w", i, p, err)
+			}
+		}
+	}
+	return &Policy{Bindings: slices.Clone(bindings)}, nil
+}
+
+// NewPolicyFromFile loads a JSON encoded Policy from path.
+func NewPolicyFromFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy file %q: %w", path, err)
+	}
+	return NewPolicy(p.Bindings)
+}
+
+func (p *Policy) Authorize(id *Identity, perm Permission, scope Scope) error {
+	if id != nil {
+		for _, b := range p.Bindings {
+			if b.appliesTo(id) && b.grants(perm, scope) {
+				return nil
+			}
+		}
+	}
+
+	subject := ""
+	if id != nil {
+		subject = id.Subject
+	}
+	return &ErrForbidden{Subject: subject, Permission: perm, Scope: scope}
+}
+
+func (b RoleBinding) appliesTo(id *Identity) bool {
+	if slices.Contains(b.Subjects, id.Subject) {
+		return true
+	}
+	return slices.ContainsFunc(id.Groups, func(g string) bool { return slices.Contains(b.Groups, g) })
+}
+
+func (b RoleBinding) grants(perm Permission, scope Scope) bool {
+	if !slices.Contains(rolePermissions[b.Role], perm) {
+		return false
+	}
+	if !matchesAny(b.Clusters, scope.Cluster) {
+		return false
+	}
+	if scope.Namespace == "" {
+		return len(b.Namespaces) == 0 || slices.Contains(b.Namespaces, "*")
+	}
+	return matchesAny(b.Namespaces, scope.Namespace)
+}
+
+// matchesAny reports whether name matches one of patterns. An empty list of
+// patterns matches every name.
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterFromRequest returns the cluster a request targets: the {clusterName}
+// path value, the "cluster-name" header, or the default cluster.
+func clusterFromRequest(r *http.Request) string {
+	if name := r.PathValue("clusterName"); name != "" {
+		return name
+	}
+	if name := r.Header.Get("cluster-name"); name != "" {
+		return name
+	}
+	return DefaultClusterName
+}
+
+// authorize checks perm for the caller of r on the target cluster and the
+// given namespace. On denial it writes a 403 response and returns false.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, perm Permission, namespace string) bool {
+	return h.authorizeScope(w, r, perm, Scope{Cluster: clusterFromRequest(r), Namespace: namespace})
+}
+
+func (h *Handler) authorizeScope(w http.ResponseWriter, r *http.Request, perm Permission, scope Scope) bool {
+	auditScope(r.Context(), scope)
+	id, _ := IdentityFromContext(r.Context())
+	if err := h.authorizer.Authorize(id, perm, scope); err != nil {
+		h.requestLogger(r).WarnCtx(r.Context(), "request denied by authorizer",
+			"method", r.Method, "path", r.URL.Path, "err", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requirePermission wraps next with an authorization check for perm. The
+// namespace is taken from the {namespace} path value when the route has one;
+// routes that carry the namespace in the body check it in the handler.
+func (h *Handler) requirePermission(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorize(w, r, perm, r.PathValue("namespace")) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// protect authenticates the caller and checks perm before calling next.
+func (h *Handler) protect(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(h.requirePermission(perm, next), h.authenticator, h.logger)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindings []RoleBinding
+		wantErr  bool
+	}{
+		{
+			name:     "Valid binding",
+			bindings: []RoleBinding{{Role: RoleDeployer, Groups: []string{"team-a"}, Namespaces: []string{"team-a-*"}}},
+		},
+		{
+			name:     "Unknown role",
+			bindings: []RoleBinding{{Role: "owner", Subjects: []string{"alice"}}},
+			wantErr:  true,
+		},
+		{
+			name:     "No subjects or groups",
+			bindings: []RoleBinding{{Role: RoleViewer}},
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid pattern",
+			bindings: []RoleBinding{{Role: RoleViewer, Subjects: []string{"alice"}, Clusters: []string{"prod-["}}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPolicy(tc.bindings)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("expected error=%v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := NewPolicy([]RoleBinding{
+		{Role: RoleViewer, Groups: []string{"everyone"}},
+		{Role: RoleDeployer, Groups: []string{"team-a"}, Clusters: []string{"prod-*"}, Namespaces: []string{"team-a-*"}},
+		{Role: RoleSecretManager, Subjects: []string{"vault-sync"}, Clusters: []string{DefaultClusterName}},
+		{Role: RoleClusterAdmin, Subjects: []string{"admin"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alice := &Identity{Subject: "alice", Groups: []string{"everyone", "team-a"}}
+	bob := &Identity{Subject: "bob", Groups: []string{"everyone"}}
+	vault := &Identity{Subject: "vault-sync"}
+	admin := &Identity{Subject: "admin"}
+
+	tests := []struct {
+		name    string
+		id      *Identity
+		perm    Permission
+		scope   Scope
+		allowed bool
+	}{
+		{"Viewer reads anywhere", bob, PermDeploymentsRead, Scope{"prod-1", "other"}, true},
+		{"Viewer cannot write", bob, PermDeploymentsWrite, Scope{"prod-1", "team-a-web"}, false},
+		{"Viewer cannot read secrets", bob, PermSecretsRead, Scope{"prod-1", "team-a-web"}, false},
+		{"Deployer writes in own namespace", alice, PermDeploymentsWrite, Scope{"prod-1", "team-a-web"}, true},
+		{"Deployer cannot write other namespace", alice, PermDeploymentsWrite, Scope{"prod-1", "team-b"}, false},
+		{"Deployer cannot write other cluster", alice, PermDeploymentsWrite, Scope{"staging", "team-a-web"}, false},
+		{"Namespaced deployer cannot act cluster wide", alice, PermDeploymentsWrite, Scope{"prod-1", ""}, false},
+		{"Deployer cannot onboard clusters", alice, PermClustersWrite, Scope{Cluster: "prod-2"}, false},
+		{"Secret manager on its cluster", vault, PermSecretsWrite, Scope{DefaultClusterName, "any"}, true},
+		{"Secret manager on other cluster", vault, PermSecretsWrite, Scope{"prod-1", "any"}, false},
+		{"Cluster admin onboards clusters", admin, PermClustersWrite, Scope{Cluster: "new"}, true},
+		{"Anonymous is denied", nil, PermDeploymentsRead, Scope{DefaultClusterName, "default"}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Authorize(tc.id, tc.perm, tc.scope)
+			if tc.allowed {
+				if err != nil {
+					t.Fatalf("expected access, got %v", err)
+				}
+				return
+			}
+
+			var forbidden *ErrForbidden
+			if !errors.As(err, &forbidden) {
+				t.Fatalf("expected *ErrForbidden, got %T: %v", err, err)
+			}
+			if forbidden.Permission != tc.perm || forbidden.Scope != tc.scope {
+				t.Errorf("expected %q on %+v, got %q on %+v", tc.perm, tc.scope, forbidden.Permission, forbidden.Scope)
+			}
+		})
+	}
+
+	t.Run("Empty policy denies everything", func(t *testing.T) {
+		if err := new(Policy).Authorize(admin, PermPodsRead, Scope{Cluster: DefaultClusterName}); err == nil {
+			t.Fatal("expected empty policy to deny, got nil")
+		}
+	})
+}
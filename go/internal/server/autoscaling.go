@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaxScaleDownStabilizationSeconds is the longest stabilisation window
+// Kubernetes accepts.
+const MaxScaleDownStabilizationSeconds = 3600
+
+// AutoscalingRequest scales the app between MinReplicas and MaxReplicas with
+// a HorizontalPodAutoscaler, on the average utilisation of the CPU and
+// memory requests of its pods. While it is set, the autoscaler owns the
+// replica count; DeploymentRequest.Replicas is only where it starts from.
+type AutoscalingRequest struct {
+	MinReplicas int32 `json:"minReplicas"`
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// Target utilisations, in percent of the requests. At least one is required.
+	TargetCPUUtilization    *int32 `json:"targetCPUUtilization,omitempty"`
+	TargetMemoryUtilization *int32 `json:"targetMemoryUtilization,omitempty"`
+
+	// ScaleDownStabilizationSeconds is how far back the autoscaler looks for
+	// a higher recommendation before scaling down. Kubernetes defaults it to 300.
+	ScaleDownStabilizationSeconds *int32 `json:"scaleDownStabilizationSeconds,omitempty"`
+}
+
+// appReplicas returns the replica count the Deployment of the app described
+// by in is rendered with: Replicas, kept within the autoscaling bounds.
+func appReplicas(in DeploymentRequest) int32 {
+	if a := in.Autoscaling; a != nil {
+		return min(max(in.Replicas, a.MinReplicas), a.MaxReplicas)
+	}
+	return in.Replicas
+}
+
+// appAutoscaler renders the HorizontalPodAutoscaler of the app described by
+// in, or returns nil when it is not autoscaled.
+func appAutoscaler(in DeploymentRequest) *autoscalingv2.HorizontalPodAutoscaler {
+	a := in.Autoscaling
+	if a == nil {
+		return nil
+	}
+	names := appNamesFor(in.DeploymentName)
+	var metrics []autoscalingv2.MetricSpec
+	for _, target := range []struct {
+		resource    corev1.ResourceName
+		utilization *int32
+	}{
+		{corev1.ResourceCPU, a.TargetCPUUtilization},
+		{corev1.ResourceMemory, a.TargetMemoryUtilization},
+	} {
+		if target.utilization == nil {
+			continue
+		}
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: target.resource,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: int32Ptr(*target.utilization),
+				},
+			},
+		})
+	}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.Autoscaler,
+			Namespace: in.Namespace,
+			Labels:    appLabels(in.DeploymentName),
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       names.Deployment,
+			},
+			MinReplicas: int32Ptr(a.MinReplicas),
+			MaxReplicas: a.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+	if a.ScaleDownStabilizationSeconds != nil {
+		hpa.Spec.Behavior = &autoscalingv2.HorizontalPodAutoscalerBehavior{
+			ScaleDown: &autoscalingv2.HPAScalingRules{
+				StabilizationWindowSeconds: int32Ptr(*a.ScaleDownStabilizationSeconds),
+			},
+		}
+	}
+	return hpa
+}
+
+// autoscalingRequestFrom describes hpa, which may be nil.
+func autoscalingRequestFrom(hpa *autoscalingv2.HorizontalPodAutoscaler) *AutoscalingRequest {
+	if hpa == nil {
+		return nil
+	}
+	a := &AutoscalingRequest{MinReplicas: 1, MaxReplicas: hpa.Spec.MaxReplicas}
+	if hpa.Spec.MinReplicas != nil {
+		a.MinReplicas = *hpa.Spec.MinReplicas
+	}
+	for _, m := range hpa.Spec.Metrics {
+		if m.Type != autoscalingv2.ResourceMetricSourceType || m.Resource == nil || m.Resource.Target.AverageUtilization == nil {
+			continue
+		}
+		utilization := int32Ptr(*m.Resource.Target.AverageUtilization)
+		switch m.Resource.Name {
+		case corev1.ResourceCPU:
+			a.TargetCPUUtilization = utilization
+		case corev1.ResourceMemory:
+			a.TargetMemoryUtilization = utilization
+		}
+	}
+	if b := hpa.Spec.Behavior; b != nil && b.ScaleDown != nil && b.ScaleDown.StabilizationWindowSeconds != nil {
+		a.ScaleDownStabilizationSeconds = int32Ptr(*b.ScaleDown.StabilizationWindowSeconds)
+	}
+	return a
+}
+
+// autoscalerFor returns the HorizontalPodAutoscaler scaling the Deployment
+// name, whoever created it, or nil when there is none.
+func autoscalerFor(ctx context.Context, cs kubernetes.Interface, namespace, name string) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	list, err := cs.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list horizontalpodautoscalers: %w", err)
+	}
+	i := slices.IndexFunc(list.Items, func(hpa autoscalingv2.HorizontalPodAutoscaler) bool {
+		ref := hpa.Spec.ScaleTargetRef
+		return ref.Kind == "Deployment" && ref.Name == name
+	})
+	if i < 0 {
+		return nil, nil
+	}
+	return &list.Items[i], nil
+}
+
+// validateAutoscaling checks a, which may be nil.
+func validateAutoscaling(a *AutoscalingRequest) []error {
+	if a == nil {
+		return nil
+	}
+	var errs []error
+	if a.MinReplicas < 1 {
+		errs = append(errs, fmt.Errorf("autoscaling.minReplicas must be greater than 0"))
+	}
+	if a.MaxReplicas < a.MinReplicas {
+		errs = append(errs, fmt.Errorf("autoscaling.maxReplicas must be at least minReplicas"))
+	}
+	if a.TargetCPUUtilization == nil && a.TargetMemoryUtilization == nil {
+		errs = append(errs, fmt.Errorf("autoscaling needs a targetCPUUtilization or a targetMemoryUtilization"))
+	}
+	if a.TargetCPUUtilization != nil && *a.TargetCPUUtilization <= 0 {
+		errs = append(errs, fmt.Errorf("autoscaling.targetCPUUtilization must be greater than 0"))
+	}
+	if a.TargetMemoryUtilization != nil && *a.TargetMemoryUtilization <= 0 {
+		errs = append(errs, fmt.Errorf("autoscaling.targetMemoryUtilization must be greater than 0"))
+	}
+	if s := a.ScaleDownStabilizationSeconds; s != nil && (*s < 0 || *s > MaxScaleDownStabilizationSeconds) {
+		errs = append(errs, fmt.Errorf("autoscaling.scaleDownStabilizationSeconds must be between 0 and %d", MaxScaleDownStabilizationSeconds))
+	}
+	return errs
+}
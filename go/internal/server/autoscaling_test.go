@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAppAutoscaler(t *testing.T) {
+	in := testDeploymentRequest()
+	if hpa := appAutoscaler(in); hpa != nil {
+		t.Errorf("expected no autoscaler, got %+v", hpa)
+	}
+
+	in.Autoscaling = &AutoscalingRequest{
+		MinReplicas:                   2,
+		MaxReplicas:                   8,
+		TargetCPUUtilization:          int32Ptr(70),
+		TargetMemoryUtilization:       int32Ptr(80),
+		ScaleDownStabilizationSeconds: int32Ptr(600),
+	}
+	hpa := appAutoscaler(in)
+	if ref := hpa.Spec.ScaleTargetRef; ref.Kind != "Deployment" || ref.Name != "web-deployment" {
+		t.Errorf("expected the autoscaler to scale web-deployment, got %+v", ref)
+	}
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 8 || len(hpa.Spec.Metrics) != 2 {
+		t.Errorf("unexpected spec: %+v", hpa.Spec)
+	}
+	if m := hpa.Spec.Metrics[1]; m.Resource.Name != "memory" || m.Resource.Target.Type != autoscalingv2.UtilizationMetricType || *m.Resource.Target.AverageUtilization != 80 {
+		t.Errorf("unexpected memory metric: %+v", m.Resource)
+	}
+	if got := autoscalingRequestFrom(hpa); !reflect.DeepEqual(got, in.Autoscaling) {
+		t.Errorf("expected %+v to be described back, got %+v", in.Autoscaling, got)
+	}
+
+	for replicas, want := range map[int32]int32{0: 2, 5: 5, 12: 8} {
+		in.Replicas = replicas
+		if got := appReplicas(in); got != want {
+			t.Errorf("replicas %d: expected %d, got %d", replicas, want, got)
+		}
+	}
+}
+
+func TestValidateAutoscaling(t *testing.T) {
+	valid := func() *AutoscalingRequest {
+		return &AutoscalingRequest{MinReplicas: 1, MaxReplicas: 3, TargetCPUUtilization: int32Ptr(60)}
+	}
+	tests := map[string]func(*AutoscalingRequest){
+		"No minimum":             func(a *AutoscalingRequest) { a.MinReplicas = 0 },
+		"Maximum below minimum":  func(a *AutoscalingRequest) { a.MaxReplicas = 0 },
+		"No target":              func(a *AutoscalingRequest) { a.TargetCPUUtilization = nil },
+		"Zero memory target":     func(a *AutoscalingRequest) { a.TargetMemoryUtilization = int32Ptr(0) },
+		"Stabilisation too long": func(a *AutoscalingRequest) { a.ScaleDownStabilizationSeconds = int32Ptr(7200) },
+		"Negative stabilisation": func(a *AutoscalingRequest) { a.ScaleDownStabilizationSeconds = int32Ptr(-1) },
+	}
+	if errs := validateAutoscaling(valid()); len(errs) != 0 {
+		t.Fatalf("expected a valid request, got %v", errs)
+	}
+	for name, modify := range tests {
+		a := valid()
+		modify(a)
+		if errs := validateAutoscaling(a); len(errs) == 0 {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// The autoscaler owns the replica count, which need not be set
+	in := testDeploymentRequest()
+	in.Replicas, in.Autoscaling = 0, valid()
+	if err := validateDeploymentRequestBody(in); err != nil {
+		t.Errorf("expected replicas to be optional with autoscaling, got %v", err)
+	}
+}
+
+func TestHandleDeploymentUpdateAutoscaled(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Autoscaling = &AutoscalingRequest{MinReplicas: 2, MaxReplicas: 5, TargetCPUUtilization: int32Ptr(70)}
+	scale := func(hpa *autoscalingv2.HorizontalPodAutoscaler) *httptest.ResponseRecorder {
+		s := newTestServer(t, appDeployment(in), hpa)
+		s.handle("PUT /deployments/{namespace}/{deploymentName}", s.h.handleDeploymentUpdate())
+		return s.serve(http.MethodPut, "/deployments/apps/web-deployment", `{"replicas":4}`)
+	}
+
+	// Any autoscaler of the deployment counts, not only the one aico made
+	foreign := appAutoscaler(in)
+	foreign.Name = "web-hpa"
+	rec := scale(foreign)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "between 2 and 5 replicas") {
+		t.Errorf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	other := appAutoscaler(in)
+	other.Name, other.Spec.ScaleTargetRef.Name = "api-deployment", "api-deployment"
+	if rec := scale(other); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for a deployment without autoscaler, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPatchAutoscaling(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Replicas = 3
+	clientset := fake.NewClientset(appDeployment(in), appService(in))
+	cluster := &Cluster{Name: DefaultClusterName, Clientset: clientset, Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}
+
+	// PATCH {"autoscaling":{...}} on an app running 3 replicas
+	in.Autoscaling = &AutoscalingRequest{MinReplicas: 4, MaxReplicas: 10, TargetCPUUtilization: int32Ptr(50)}
+	dep, err := updateApp(t.Context(), cluster, in, DomainConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != 4 {
+		t.Errorf("expected the app to be scaled up to minReplicas, got %d", *dep.Spec.Replicas)
+	}
+	hpa, err := autoscalerFor(t.Context(), clientset, "apps", "web-deployment")
+	if err != nil || hpa == nil || hpa.Spec.MaxReplicas != 10 {
+		t.Fatalf("expected the autoscaler to be created, got %+v (%v)", hpa, err)
+	}
+
+	// The autoscaler scales the app out, which a later update keeps
+	dep.Spec.Replicas = int32Ptr(7)
+	if _, err := clientset.AppsV1().Deployments("apps").Update(t.Context(), dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	in.Image = "nginx:1.28"
+	if dep, _ = updateApp(t.Context(), cluster, in, DomainConfig{}, nil); *dep.Spec.Replicas != 7 {
+		t.Errorf("expected the autoscaled replica count to be kept, got %d", *dep.Spec.Replicas)
+	}
+
+	// PATCH {"autoscaling":null} hands the replica count back
+	in.Autoscaling, in.Replicas = nil, 7
+	if _, err := updateApp(t.Context(), cluster, in, DomainConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if hpa, _ := autoscalerFor(t.Context(), clientset, "apps", "web-deployment"); hpa != nil {
+		t.Errorf("expected the autoscaler to be deleted, got %+v", hpa)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AvailabilityTierLabel sets the availability tier of a namespace, which
+// decides the availability settings its apps default to.
+const AvailabilityTierLabel = "aico.clappform.com/availability-tier"
+
+// Availability tiers. A namespace without AvailabilityTierLabel is TierStandard.
+const (
+	// TierBestEffort apps get no disruption budget and no spreading.
+	TierBestEffort = "best-effort"
+	// TierStandard apps get a disruption budget and are spread across nodes.
+	TierStandard = "standard"
+	// TierCritical apps are also spread across zones.
+	TierCritical = "critical"
+)
+
+// ErrUnknownAvailabilityTier is returned for a namespace labelled with a tier
+// that does not exist.
+var ErrUnknownAvailabilityTier = errors.New("unknown availability tier")
+
+// availabilityTiers are the availability settings of each tier.
+var availabilityTiers = map[string]AvailabilityRequest{
+	TierBestEffort: {DisruptionBudget: boolPtr(false), SpreadAcrossNodes: boolPtr(false), SpreadAcrossZones: boolPtr(false)},
+	TierStandard:   {DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(true), SpreadAcrossZones: boolPtr(false)},
+	TierCritical:   {DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(true), SpreadAcrossZones: boolPtr(true)},
+}
+
+// AvailabilityRequest keeps an app serving through voluntary disruptions,
+// such as the node drains of cluster maintenance. It only applies to apps
+// that may run more than one replica. Settings left out default to the
+// availability tier of the namespace.
+type AvailabilityRequest struct {
+	// DisruptionBudget lets voluntary disruptions take down only one pod of
+	// the app at a time, through a PodDisruptionBudget.
+	DisruptionBudget *bool `json:"disruptionBudget,omitempty"`
+
+	// SpreadAcrossNodes and SpreadAcrossZones ask the scheduler to spread the
+	// pods of the app evenly over nodes and zones. Pods are still scheduled
+	// where they cannot be.
+	SpreadAcrossNodes *bool `json:"spreadAcrossNodes,omitempty"`
+	SpreadAcrossZones *bool `json:"spreadAcrossZones,omitempty"`
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// enabled reports whether the setting b is set and on.
+func enabled(b *bool) bool { return b != nil && *b }
+
+// replicated reports whether the app described by in may run more than one
+// replica.
+func replicated(in DeploymentRequest) bool {
+	if a := in.Autoscaling; a != nil {
+		return a.MaxReplicas > 1
+	}
+	return in.Replicas > 1
+}
+
+// appTopologySpread renders the topology spread constraints of the pods of
+// the app described by in. Each rollout is spread on its own, so that the
+// pods of the old ReplicaSet do not skew where the new ones go.
+func appTopologySpread(in DeploymentRequest) []corev1.TopologySpreadConstraint {
+	a := in.Availability
+	if a == nil || !replicated(in) {
+		return nil
+	}
+	var constraints []corev1.TopologySpreadConstraint
+	for _, spread := range []struct {
+		key string
+		on  *bool
+	}{
+		{corev1.LabelHostname, a.SpreadAcrossNodes},
+		{corev1.LabelTopologyZone, a.SpreadAcrossZones},
+	} {
+		if !enabled(spread.on) {
+			continue
+		}
+		constraints = append(constraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       spread.key,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": in.DeploymentName}},
+			MatchLabelKeys:    []string{appsv1.DefaultDeploymentUniqueLabelKey},
+		})
+	}
+	return constraints
+}
+
+// appDisruptionBudget renders the PodDisruptionBudget of the app described
+// by in, or returns nil when it has none. Pods that are not ready can always
+// be evicted, so that a broken rollout does not block a node drain.
+func appDisruptionBudget(in DeploymentRequest) *policyv1.PodDisruptionBudget {
+	if in.Availability == nil || !enabled(in.Availability.DisruptionBudget) || !replicated(in) {
+		return nil
+	}
+	maxUnavailable := intstr.FromInt32(1)
+	alwaysAllow := policyv1.AlwaysAllow
+	return &policyv1.PodDisruptionBudget{
+		TypeMeta: metav1.TypeMeta{APIVersion: "policy/v1", Kind: "PodDisruptionBudget"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appNamesFor(in.DeploymentName).DisruptionBudget,
+			Namespace: in.Namespace,
+			Labels:    appLabels(in.DeploymentName),
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable:             &maxUnavailable,
+			Selector:                   &metav1.LabelSelector{MatchLabels: map[string]string{"app": in.DeploymentName}},
+			UnhealthyPodEvictionPolicy: &alwaysAllow,
+		},
+	}
+}
+
+// availabilityRequestFrom describes the availability settings of dep, the
+// Deployment of the app described by in, and of pdb, its
+// PodDisruptionBudget, which may be nil. An app that runs a single replica
+// has none to describe, which leaves them to the tier should it scale out.
+func availabilityRequestFrom(in DeploymentRequest, dep *appsv1.Deployment, pdb *policyv1.PodDisruptionBudget) *AvailabilityRequest {
+	if !replicated(in) {
+		return nil
+	}
+	spreads := func(key string) *bool {
+		return boolPtr(slices.ContainsFunc(dep.Spec.Template.Spec.TopologySpreadConstraints, func(c corev1.TopologySpreadConstraint) bool {
+			return c.TopologyKey == key
+		}))
+	}
+	return &AvailabilityRequest{
+		DisruptionBudget:  boolPtr(pdb != nil),
+		SpreadAcrossNodes: spreads(corev1.LabelHostname),
+		SpreadAcrossZones: spreads(corev1.LabelTopologyZone),
+	}
+}
+
+// availabilityTier returns the availability tier of namespace. A namespace
+// that does not exist yet is TierStandard, as is one without
+// AvailabilityTierLabel.
+func availabilityTier(ctx context.Context, cs kubernetes.Interface, namespace string) (string, error) {
+	ns, err := cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return TierStandard, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get namespace %s: %w", namespace, err)
+	}
+	tier := cmp.Or(ns.Labels[AvailabilityTierLabel], TierStandard)
+	if _, ok := availabilityTiers[tier]; !ok {
+		return "", fmt.Errorf("%w %q on namespace %s", ErrUnknownAvailabilityTier, tier, namespace)
+	}
+	return tier, nil
+}
+
+// withAvailabilityTier returns in with the availability settings it leaves
+// out set to those of the availability tier of its namespace.
+func withAvailabilityTier(ctx context.Context, cs kubernetes.Interface, in DeploymentRequest) (DeploymentRequest, error) {
+	tier, err := availabilityTier(ctx, cs, in.Namespace)
+	if err != nil {
+		return in, err
+	}
+	defaults := availabilityTiers[tier]
+	var a AvailabilityRequest
+	if in.Availability != nil {
+		a = *in.Availability
+	}
+	a.DisruptionBudget = cmp.Or(a.DisruptionBudget, defaults.DisruptionBudget)
+	a.SpreadAcrossNodes = cmp.Or(a.SpreadAcrossNodes, defaults.SpreadAcrossNodes)
+	a.SpreadAcrossZones = cmp.Or(a.SpreadAcrossZones, defaults.SpreadAcrossZones)
+	in.Availability = &a
+	return in, nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWithAvailabilityTier(t *testing.T) {
+	namespace := func(name, tier string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if tier != "" {
+			ns.Labels = map[string]string{AvailabilityTierLabel: tier}
+		}
+		return ns
+	}
+	clientset := fake.NewClientset(
+		namespace("apps", ""),
+		namespace("batch", TierBestEffort),
+		namespace("payments", TierCritical),
+		namespace("typo", "gold"),
+	)
+	tests := map[string]struct {
+		namespace    string
+		availability *AvailabilityRequest
+		want         AvailabilityRequest
+	}{
+		"Unlabelled namespace": {"apps", nil, availabilityTiers[TierStandard]},
+		"New namespace":        {"new", nil, availabilityTiers[TierStandard]},
+		"Best effort":          {"batch", nil, availabilityTiers[TierBestEffort]},
+		"Critical":             {"payments", nil, availabilityTiers[TierCritical]},
+		"Opt out": {"payments", &AvailabilityRequest{SpreadAcrossZones: boolPtr(false)},
+			AvailabilityRequest{DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(true), SpreadAcrossZones: boolPtr(false)}},
+		"Opt in": {"batch", &AvailabilityRequest{DisruptionBudget: boolPtr(true)},
+			AvailabilityRequest{DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(false), SpreadAcrossZones: boolPtr(false)}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			in := testDeploymentRequest()
+			in.Namespace, in.Availability = tc.namespace, tc.availability
+			got, err := withAvailabilityTier(t.Context(), clientset, in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got.Availability, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, *got.Availability)
+			}
+		})
+	}
+
+	in := testDeploymentRequest()
+	in.Namespace = "typo"
+	if _, err := withAvailabilityTier(t.Context(), clientset, in); !errors.Is(err, ErrUnknownAvailabilityTier) {
+		t.Errorf("expected ErrUnknownAvailabilityTier, got %v", err)
+	}
+}
+
+func TestAppAvailability(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Replicas = 3
+	in.Availability = &AvailabilityRequest{DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(true), SpreadAcrossZones: boolPtr(true)}
+
+	pdb := appDisruptionBudget(in)
+	if pdb == nil || pdb.Name != "web-deployment" || pdb.Spec.MaxUnavailable.IntValue() != 1 || pdb.Spec.Selector.MatchLabels["app"] != "web" {
+		t.Fatalf("unexpected disruption budget: %+v", pdb)
+	}
+	dep := appDeployment(in)
+	spread := dep.Spec.Template.Spec.TopologySpreadConstraints
+	if len(spread) != 2 || spread[0].TopologyKey != corev1.LabelHostname || spread[1].TopologyKey != corev1.LabelTopologyZone {
+		t.Fatalf("unexpected spread: %+v", spread)
+	}
+	if spread[0].WhenUnsatisfiable != corev1.ScheduleAnyway {
+		t.Errorf("expected pods to be scheduled where they cannot be spread, got %s", spread[0].WhenUnsatisfiable)
+	}
+	if got := availabilityRequestFrom(in, dep, pdb); !reflect.DeepEqual(got, in.Availability) {
+		t.Errorf("expected %+v to be described back, got %+v", in.Availability, got)
+	}
+
+	// The next version of a release is spread apart from the app's pods
+	next := releaseDeployment(in, ReleaseCanary, 10, 2)
+	if sel := next.Spec.Template.Spec.TopologySpreadConstraints[0].LabelSelector; sel.MatchLabels["app"] != "web-next" {
+		t.Errorf("expected the release pods to be spread on their own, got %+v", sel)
+	}
+
+	// A single replica has nothing to spread or keep up
+	in.Replicas = 1
+	if pdb := appDisruptionBudget(in); pdb != nil {
+		t.Errorf("expected no disruption budget for a single replica, got %+v", pdb)
+	}
+	if spread := appDeployment(in).Spec.Template.Spec.TopologySpreadConstraints; spread != nil {
+		t.Errorf("expected no spread for a single replica, got %+v", spread)
+	}
+	if got := availabilityRequestFrom(in, appDeployment(in), nil); got != nil {
+		t.Errorf("expected a single replica to describe no availability, got %+v", got)
+	}
+
+	// An autoscaled app counts with its maximum
+	in.Autoscaling = &AutoscalingRequest{MinReplicas: 1, MaxReplicas: 4, TargetCPUUtilization: int32Ptr(70)}
+	if pdb := appDisruptionBudget(in); pdb == nil {
+		t.Errorf("expected a disruption budget for an app autoscaled up to 4 replicas")
+	}
+}
+
+func TestUpdateAppAvailability(t *testing.T) {
+	in := testDeploymentRequest()
+	clientset := fake.NewClientset(appDeployment(in), appService(in))
+	cluster := &Cluster{Name: DefaultClusterName, Clientset: clientset, Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}
+
+	// PATCH {"replicas":3} in a namespace of the standard tier
+	in.Replicas = 3
+	in, err := withAvailabilityTier(t.Context(), clientset, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep, err := updateApp(t.Context(), cluster, in, DomainConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spread := dep.Spec.Template.Spec.TopologySpreadConstraints; len(spread) != 1 || spread[0].TopologyKey != corev1.LabelHostname {
+		t.Errorf("expected the pods to be spread across nodes, got %+v", spread)
+	}
+	if _, err := clientset.PolicyV1().PodDisruptionBudgets("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the disruption budget to be created: %v", err)
+	}
+
+	// The app describes its settings, which later updates keep
+	live, err := getLiveApp(t.Context(), clientset, "apps", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requestFromDeployment("web", live, DomainConfig{}).Availability; !reflect.DeepEqual(got, in.Availability) {
+		t.Errorf("expected %+v to be described, got %+v", in.Availability, got)
+	}
+
+	// PATCH {"availability":{"disruptionBudget":false}}
+	in.Availability = &AvailabilityRequest{DisruptionBudget: boolPtr(false)}
+	if in, err = withAvailabilityTier(t.Context(), clientset, in); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateApp(t.Context(), cluster, in, DomainConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.PolicyV1().PodDisruptionBudgets("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the disruption budget to be deleted, got %v", err)
+	}
+}
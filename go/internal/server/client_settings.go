@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// ClientSettings tune the Kubernetes clients built for a cluster. Zero fields
+// fall back to the handler defaults, see WithClientDefaults.
+type ClientSettings struct {
+	QPS       float32       `json:"qps,omitempty"`
+	Burst     int           `json:"burst,omitempty"`
+	Timeout   time.Duration `json:"timeout,omitempty"`
+	UserAgent string        `json:"userAgent,omitempty"`
+}
+
+// withDefaults returns s with its zero fields taken from defaults.
+func (s ClientSettings) withDefaults(defaults ClientSettings) ClientSettings {
+	if s.QPS == 0 {
+		s.QPS = defaults.QPS
+	}
+	if s.Burst == 0 {
+		s.Burst = defaults.Burst
+	}
+	if s.Timeout == 0 {
+		s.Timeout = defaults.Timeout
+	}
+	if s.UserAgent == "" {
+		s.UserAgent = defaults.UserAgent
+	}
+	return s
+}
+
+// validate reports settings that would leave the clients unusable.
+func (s ClientSettings) validate() error {
+	if s.QPS < 0 || s.Burst < 0 || s.Timeout < 0 {
+		return errors.New("qps, burst and timeout must not be negative")
+	}
+	if s.QPS > 0 && s.Burst < 1 {
+		return errors.New("burst must be at least 1 when qps is set")
+	}
+	return nil
+}
+
+// apply copies the non-zero settings onto cfg.
+func (s ClientSettings) apply(cfg *rest.Config) {
+	if s.QPS > 0 {
+		cfg.QPS = s.QPS
+	}
+	if s.Burst > 0 {
+		cfg.Burst = s.Burst
+	}
+	if s.Timeout > 0 {
+		cfg.Timeout = s.Timeout
+	}
+	if s.UserAgent != "" {
+		cfg.UserAgent = s.UserAgent
+	}
+}
+
+// clientSettingsRequest is the JSON form of ClientSettings accepted by the
+// cluster endpoints. The user agent is not configurable per cluster.
+type clientSettingsRequest struct {
+	QPS     float32 `json:"qps,omitempty"`
+	Burst   int     `json:"burst,omitempty"`
+	Timeout string  `json:"timeout,omitempty"` // Go duration, e.g. "30s"
+}
+
+// merge returns current with the fields set in in replaced.
+func (in *clientSettingsRequest) merge(current ClientSettings) (ClientSettings, error) {
+	if in == nil {
+		return current, nil
+	}
+	if in.QPS != 0 {
+		current.QPS = in.QPS
+	}
+	if in.Burst != 0 {
+		current.Burst = in.Burst
+	}
+	if in.Timeout != "" {
+		timeout, err := time.ParseDuration(in.Timeout)
+		if err != nil {
+			return current, fmt.Errorf("invalid client timeout: %w", err)
+		}
+		current.Timeout = timeout
+	}
+	return current, nil
+}
+
+// clientSettings merges in onto current and checks the outcome together with
+// the handler defaults.
+func (h *Handler) clientSettings(in *clientSettingsRequest, current ClientSettings) (ClientSettings, error) {
+	s, err := in.merge(current)
+	if err != nil {
+		return current, err
+	}
+	return s, s.withDefaults(h.clientDefaults).validate()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestClientSettingsApply(t *testing.T) {
+	defaults := ClientSettings{QPS: 20, Burst: 40, Timeout: 30 * time.Second, UserAgent: "aico/test"}
+
+	cfg := &rest.Config{UserAgent: "client-go"}
+	ClientSettings{QPS: 5}.withDefaults(defaults).apply(cfg)
+
+	if cfg.QPS != 5 || cfg.Burst != 40 || cfg.Timeout != 30*time.Second || cfg.UserAgent != "aico/test" {
+		t.Errorf("unexpected config: qps=%v burst=%v timeout=%v ua=%q", cfg.QPS, cfg.Burst, cfg.Timeout, cfg.UserAgent)
+	}
+}
+
+func TestHandlerClientSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults ClientSettings
+		in       *clientSettingsRequest
+		current  ClientSettings
+		want     ClientSettings
+		wantErr  bool
+	}{
+		{
+			name:    "Nil request keeps current",
+			current: ClientSettings{QPS: 3, Burst: 6},
+			want:    ClientSettings{QPS: 3, Burst: 6},
+		},
+		{
+			name:    "Merges set fields",
+			in:      &clientSettingsRequest{Burst: 12, Timeout: "10s"},
+			current: ClientSettings{QPS: 3, Burst: 6},
+			want:    ClientSettings{QPS: 3, Burst: 12, Timeout: 10 * time.Second},
+		},
+		{
+			name:    "Invalid timeout",
+			in:      &clientSettingsRequest{Timeout: "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "QPS without burst",
+			in:      &clientSettingsRequest{QPS: 5},
+			wantErr: true,
+		},
+		{
+			name:     "QPS with default burst",
+			defaults: ClientSettings{Burst: 10},
+			in:       &clientSettingsRequest{QPS: 5},
+			want:     ClientSettings{QPS: 5},
+		},
+		{
+			name:    "Negative values",
+			in:      &clientSettingsRequest{Burst: -1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{clientDefaults: tt.defaults}
+			got, err := h.clientSettings(tt.in, tt.current)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("clientSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+)
+
+const (
+	// DefaultHealthCheckInterval is how often every cluster is probed.
+	DefaultHealthCheckInterval = 30 * time.Second
+
+	// DefaultHealthCheckTimeout bounds a single probe.
+	DefaultHealthCheckTimeout = 5 * time.Second
+)
+
+// ClusterHealth is the outcome of the most recent probes of a cluster.
+type ClusterHealth struct {
+	Healthy             bool
+	Version             string
+	Latency             time.Duration
+	LastChecked         time.Time
+	LastSeen            time.Time // Time of the last successful probe.
+	ConsecutiveFailures int
+	Error               string
+}
+
+// healthEntry ties a health status to the cluster value it was measured on,
+// so a probe of replaced credentials does not overwrite the new status.
+type healthEntry struct {
+	cluster *Cluster
+	health  ClusterHealth
+}
+
+// HealthMonitor probes every registered cluster in the background and caches
+// the results, so that requests never wait on a slow cluster.
+type HealthMonitor struct {
+	clusters *ClusterRegistry
+	interval time.Duration
+	timeout  time.Duration
+	logger   log.Logger
+
+	mu     sync.RWMutex
+	status map[string]healthEntry
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewHealthMonitor returns a monitor for clusters. Call Start to begin probing.
+func NewHealthMonitor(clusters *ClusterRegistry, interval, timeout time.Duration, logger log.Logger) *HealthMonitor {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	return &HealthMonitor{
+		clusters: clusters,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logger,
+		status:   make(map[string]healthEntry),
+	}
+}
+
+// Start probes all clusters right away and then on every interval until Stop
+// is called.
+func (m *HealthMonitor) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			m.CheckAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends background probing and waits for a running sweep to finish or
+// for ctx to expire.
+func (m *HealthMonitor) Stop(ctx context.Context) error {
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckAll probes every registered cluster concurrently and forgets clusters
+// that are no longer registered.
+func (m *HealthMonitor) CheckAll(ctx context.Context) {
+	clusters := m.clusters.Snapshot()
+
+	var wg sync.WaitGroup
+	for _, c := range clusters {
+		wg.Go(func() { m.Check(ctx, c) })
+	}
+	wg.Wait()
+
+	registered := make(map[string]bool, len(clusters))
+	for _, c := range clusters {
+		registered[c.Name] = true
+	}
+	m.mu.Lock()
+	maps.DeleteFunc(m.status, func(name string, _ healthEntry) bool { return !registered[name] })
+	m.mu.Unlock()
+}
+
+// Check probes a single cluster and records the result.
+func (m *HealthMonitor) Check(ctx context.Context, c *Cluster) {
+	start := time.Now()
+	info, err := probeCluster(ctx, c, m.timeout)
+	version := ""
+	if info != nil {
+		version = info.GitVersion
+	}
+	m.Observe(c, version, time.Since(start), err)
+}
+
+// Observe records the outcome of a probe of c made elsewhere, for example
+// while onboarding. Results for a cluster value that has since been replaced
+// or removed are dropped.
+func (m *HealthMonitor) Observe(c *Cluster, version string, latency time.Duration, err error) {
+	if current, ok := m.clusters.Get(c.Name); !ok || current != c {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, known := m.status[c.Name]
+	if known && prev.cluster != c {
+		prev = healthEntry{} // New credentials start with a clean history.
+	}
+
+	health := prev.health
+	health.Latency = latency
+	health.LastChecked = time.Now()
+	if err != nil {
+		health.Healthy = false
+		health.ConsecutiveFailures++
+		health.Error = err.Error()
+	} else {
+		health.Healthy = true
+		health.Version = version
+		health.LastSeen = health.LastChecked
+		health.ConsecutiveFailures = 0
+		health.Error = ""
+	}
+	m.status[c.Name] = healthEntry{cluster: c, health: health}
+
+	switch {
+	case err != nil && health.ConsecutiveFailures == 1:
+		m.logger.Warn("cluster became unreachable", "cluster", c.Name, "err", err)
+	case err == nil && known && !prev.health.Healthy && prev.cluster == c:
+		m.logger.Info("cluster is reachable again", "cluster", c.Name, "failures", prev.health.ConsecutiveFailures)
+	}
+}
+
+// Status returns the cached health of the named cluster. It reports false
+// when the cluster has not been probed yet.
+func (m *HealthMonitor) Status(name string) (ClusterHealth, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.status[name]
+	return e.health, ok
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// testVersionServer serves /version, failing while fail is set and stalling
+// while slow is set.
+func testVersionServer(t *testing.T, fail, slow *atomic.Bool) *Cluster {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		if fail.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"gitVersion":"v1.34.1"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &rest.Config{Host: srv.URL}
+	return &Cluster{Name: "edge", Config: cfg, Clientset: kubernetes.NewForConfigOrDie(cfg)}
+}
+
+func TestHealthMonitor(t *testing.T) {
+	var fail, slow atomic.Bool
+	cluster := testVersionServer(t, &fail, &slow)
+
+	clusters := NewClusterRegistry()
+	if err := clusters.Add(cluster); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := NewHealthMonitor(clusters, time.Hour, 50*time.Millisecond, log.NewNoOpLogger())
+	ctx := context.Background()
+
+	if _, ok := m.Status("edge"); ok {
+		t.Fatal("expected no status before the first probe")
+	}
+
+	m.CheckAll(ctx)
+	health, ok := m.Status("edge")
+	if !ok || !health.Healthy || health.Version != "v1.34.1" || health.LastSeen.IsZero() {
+		t.Fatalf("expected healthy status with version, got %+v", health)
+	}
+	lastSeen := health.LastSeen
+
+	fail.Store(true)
+	m.CheckAll(ctx)
+	slow.Store(true)
+	start := time.Now()
+	m.CheckAll(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("probe did not honour its timeout, took %s", elapsed)
+	}
+	health, _ = m.Status("edge")
+	if health.Healthy || health.ConsecutiveFailures != 2 || health.Error == "" {
+		t.Fatalf("expected two consecutive failures, got %+v", health)
+	}
+	if !health.LastSeen.Equal(lastSeen) || health.Version != "v1.34.1" {
+		t.Errorf("failed probes should keep the last seen time and version, got %+v", health)
+	}
+
+	fail.Store(false)
+	slow.Store(false)
+	m.CheckAll(ctx)
+	if health, _ = m.Status("edge"); !health.Healthy || health.ConsecutiveFailures != 0 {
+		t.Fatalf("expected recovery, got %+v", health)
+	}
+
+	t.Run("Replaced cluster starts fresh", func(t *testing.T) {
+		old, _ := clusters.Get("edge")
+		replacement := *old
+		if _, err := clusters.Replace(&replacement); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m.Observe(old, "v0", time.Millisecond, nil)
+		if health, _ := m.Status("edge"); health.Version == "v0" {
+			t.Error("probe of the replaced cluster overwrote the status")
+		}
+	})
+
+	t.Run("Removed cluster is forgotten", func(t *testing.T) {
+		clusters.Remove("edge")
+		m.CheckAll(ctx)
+		if _, ok := m.Status("edge"); ok {
+			t.Error("expected status of removed cluster to be dropped")
+		}
+	})
+}
+
+func TestHealthMonitorStartStop(t *testing.T) {
+	var fail, slow atomic.Bool
+	clusters := NewClusterRegistry()
+	_ = clusters.Add(testVersionServer(t, &fail, &slow))
+
+	m := NewHealthMonitor(clusters, 10*time.Millisecond, time.Second, log.NewNoOpLogger())
+	m.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := m.Status("edge"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("monitor did not probe the cluster")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrClusterExists is returned when registering a cluster under a name that is already taken.
+	ErrClusterExists = errors.New("cluster with this name already exists")
+
+	// ErrClusterNotFound is returned when a cluster name is not registered.
+	ErrClusterNotFound = errors.New("unknown cluster")
+)
+
+// Cluster holds the clients and domain settings of a registered cluster.
+// A Cluster is immutable once registered; changing a cluster means
+// registering a new value under the same name.
+type Cluster struct {
+	Name      string
+	Config    *rest.Config
+	Clientset kubernetes.Interface
+	Dynamic   dynamic.Interface
+	Ctrl      ctrlclient.Client
+	Domain    DomainConfig
+	Record    ClusterRecord // Zero for clusters that are not persisted.
+}
+
+// newCluster builds all clients for cfg.
+func newCluster(name string, cfg *rest.Config, domain DomainConfig) (*Cluster, error) {
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("build clientset for cluster %q: %w", name, err)
+	}
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("build dynamic client for cluster %q: %w", name, err)
+	}
+	cc, err := ctrlclient.New(cfg, ctrlclient.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("build controller-runtime client for cluster %q: %w", name, err)
+	}
+	return &Cluster{
+		Name:      name,
+		Config:    cfg,
+		Clientset: cs,
+		Dynamic:   dc,
+		Ctrl:      cc,
+		Domain:    domain,
+	}, nil
+}
+
+// newClusterFromRecord builds all clients for a stored cluster, tuned by the
+// record's client settings with defaults filling the gaps.
+func newClusterFromRecord(rec ClusterRecord, defaults ClientSettings) (*Cluster, error) {
+	cfg, err := rec.restConfig()
+	if err != nil {
+		return nil, fmt.Errorf("build config for cluster %q: %w", rec.Name, err)
+	}
+	rec.Client.withDefaults(defaults).apply(cfg)
+	c, err := newCluster(rec.Name, cfg, rec.Domain)
+	if err != nil {
+		return nil, err
+	}
+	c.Record = rec
+	return c, nil
+}
+
+// ClusterRegistry is a concurrency-safe set of clusters indexed by name.
+type ClusterRegistry struct {
+	mu       sync.RWMutex
+	clusters map[string]*Cluster
+}
+
+// NewClusterRegistry returns an empty registry.
+func NewClusterRegistry() *ClusterRegistry {
+	return &ClusterRegistry{clusters: make(map[string]*Cluster)}
+}
+
+// Get returns the named cluster.
+func (r *ClusterRegistry) Get(name string) (*Cluster, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clusters[name]
+	return c, ok
+}
+
+// Add registers c. It returns ErrClusterExists when the name is taken.
+func (r *ClusterRegistry) Add(c *Cluster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.clusters[c.Name]; exists {
+		return fmt.Errorf("%w: %s", ErrClusterExists, c.Name)
+	}
+	r.clusters[c.Name] = c
+	return nil
+}
+
+// Replace swaps the cluster registered under c's name for c and returns the
+// previous value. It returns ErrClusterNotFound when the name is not registered.
+func (r *ClusterRegistry) Replace(c *Cluster) (*Cluster, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, exists := r.clusters[c.Name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, c.Name)
+	}
+	r.clusters[c.Name] = c
+	return old, nil
+}
+
+// Remove unregisters the named cluster and returns it.
+func (r *ClusterRegistry) Remove(name string) (*Cluster, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.clusters[name]
+	delete(r.clusters, name)
+	return c, ok
+}
+
+// List returns the sorted names of all registered clusters.
+func (r *ClusterRegistry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Sorted(maps.Keys(r.clusters))
+}
+
+// Snapshot returns all registered clusters sorted by name. The slice is owned
+// by the caller and is not affected by later changes to the registry.
+func (r *ClusterRegistry) Snapshot() []*Cluster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Cluster, 0, len(r.clusters))
+	for _, name := range slices.Sorted(maps.Keys(r.clusters)) {
+		out = append(out, r.clusters[name])
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestClusterRegistry(t *testing.T) {
+	r := NewClusterRegistry()
+
+	if err := r.Add(&Cluster{Name: "beta"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Add(&Cluster{Name: "alpha", Domain: DomainConfig{Domain: "alpha.example"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Add rejects duplicates", func(t *testing.T) {
+		if err := r.Add(&Cluster{Name: "alpha"}); !errors.Is(err, ErrClusterExists) {
+			t.Fatalf("expected ErrClusterExists, got %v", err)
+		}
+		c, _ := r.Get("alpha")
+		if c.Domain.Domain != "alpha.example" {
+			t.Errorf("duplicate Add replaced the registered cluster: %+v", c)
+		}
+	})
+
+	t.Run("List is sorted", func(t *testing.T) {
+		if got := r.List(); !slices.Equal(got, []string{"alpha", "beta"}) {
+			t.Errorf("expected [alpha beta], got %v", got)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		old, err := r.Replace(&Cluster{Name: "alpha", Domain: DomainConfig{Domain: "new.example"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if old.Domain.Domain != "alpha.example" {
+			t.Errorf("expected previous cluster to be returned, got %+v", old)
+		}
+		if c, _ := r.Get("alpha"); c.Domain.Domain != "new.example" {
+			t.Errorf("expected replaced cluster, got %+v", c)
+		}
+		if _, err := r.Replace(&Cluster{Name: "gamma"}); !errors.Is(err, ErrClusterNotFound) {
+			t.Fatalf("expected ErrClusterNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Snapshot is detached", func(t *testing.T) {
+		snap := r.Snapshot()
+		if len(snap) != 2 || snap[0].Name != "alpha" || snap[1].Name != "beta" {
+			t.Fatalf("expected [alpha beta], got %v", snap)
+		}
+		r.Remove("beta")
+		if len(snap) != 2 {
+			t.Errorf("snapshot changed after Remove, got %d entries", len(snap))
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		if _, ok := r.Remove("beta"); ok {
+			t.Error("expected second Remove to report missing cluster")
+		}
+		if _, ok := r.Get("beta"); ok {
+			t.Error("expected beta to be gone")
+		}
+		c, ok := r.Remove("alpha")
+		if !ok || c.Name != "alpha" {
+			t.Errorf("expected to remove alpha, got %v, %v", c, ok)
+		}
+	})
+}
+
+// TestClusterRegistryConcurrency is meant to run with -race: handlers read
+// the registry while clusters are onboarded and removed.
+func TestClusterRegistryConcurrency(t *testing.T) {
+	r := NewClusterRegistry()
+	if err := r.Add(&Cluster{Name: DefaultClusterName}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const workers = 8
+	const iterations = 200
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range iterations {
+				name := fmt.Sprintf("cluster-%d-%d", w, i%10)
+				_ = r.Add(&Cluster{Name: name})
+				if c, ok := r.Get(name); ok && c.Name != name {
+					t.Errorf("Get(%q) returned %q", name, c.Name)
+				}
+				r.Remove(name)
+			}
+		})
+		wg.Go(func() {
+			for range iterations {
+				if _, ok := r.Get(DefaultClusterName); !ok {
+					t.Error("default cluster disappeared")
+				}
+				for _, c := range r.Snapshot() {
+					_ = c.Name
+				}
+				_ = r.List()
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := r.List(); !slices.Equal(got, []string{DefaultClusterName}) {
+		t.Errorf("expected only the default cluster to remain, got %v", got)
+	}
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/kube/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+// ClusterRecord is everything needed to reconnect to an onboarded cluster.
+type ClusterRecord struct {
+	Name             string       `json:"name"`
+	Server           string       `json:"server"`
+	CAData           []byte       `json:"caData"`
+	BearerToken      string       `json:"bearerToken"`
+	DefaultNamespace string       `json:"defaultNamespace,omitempty"`
+	Domain           DomainConfig `json:"domain"`
+
+	// Kubeconfig is set for clusters onboarded from a kubeconfig. It holds a
+	// single context and takes the place of CAData and BearerToken.
+	Kubeconfig []byte `json:"kubeconfig,omitempty"`
+
+	Client ClientSettings `json:"client,omitzero"`
+}
+
+// restConfig builds the client configuration for the recorded cluster.
+func (rec ClusterRecord) restConfig() (*rest.Config, error) {
+	if len(rec.Kubeconfig) > 0 {
+		return client.RESTConfigFromKubeconfig(rec.Kubeconfig)
+	}
+	return &rest.Config{
+		Host:        rec.Server,
+		BearerToken: rec.BearerToken,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: slices.Clone(rec.CAData),
+		},
+	}, nil
+}
+
+// ClusterStore persists onboarded clusters so they survive restarts.
+type ClusterStore interface {
+	// List returns all stored clusters.
+	List(ctx context.Context) ([]ClusterRecord, error)
+	// Put creates or replaces the cluster with the record's name.
+	Put(ctx context.Context, rec ClusterRecord) error
+	// Delete removes the named cluster. Deleting an unknown cluster is not an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// ClusterStoreKeySize is the size in bytes of the key used to encrypt
+// credentials in a ClusterStore.
+const ClusterStoreKeySize = 32
+
+// sealedClusterRecord is the at-rest form of a ClusterRecord. The bearer
+// token, the kubeconfig and the domain private key are encrypted with AES-256-GCM using the
+// cluster name as additional data, so a sealed value cannot be moved to
+// another record.
+type sealedClusterRecord struct {
+	Name             string         `json:"name"`
+	Server           string         `json:"server"`
+	CAData           []byte         `json:"caData"`
+	BearerToken      []byte         `json:"bearerToken"`
+	DefaultNamespace string         `json:"defaultNamespace,omitempty"`
+	Domain           string         `json:"domain,omitempty"`
+	Certificate      []byte         `json:"certificate,omitempty"`
+	PrivateKey       []byte         `json:"privateKey,omitempty"`
+	Kubeconfig       []byte         `json:"kubeconfig,omitempty"`
+	Client           ClientSettings `json:"client,omitzero"`
+}
+
+// recordSealer encrypts and decrypts the credentials of cluster records.
+type recordSealer struct {
+	aead cipher.AEAD
+}
+
+func newRecordSealer(key []byte) (*recordSealer, error) {
+	if len(key) != ClusterStoreKeySize {
+		return nil, fmt.Errorf("cluster store key must be %d bytes, got %d", ClusterStoreKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &recordSealer{aead: aead}, nil
+}
+
+func (s *recordSealer) seal(plaintext []byte, name string) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return nil, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, []byte(name)), nil
+}
+
+func (s *recordSealer) open(ciphertext []byte, name string) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, nil
+	}
+	if len(ciphertext) < s.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:s.aead.NonceSize()], ciphertext[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, sealed, []byte(name))
+}
+
+func (s *recordSealer) sealRecord(rec ClusterRecord) (sealedClusterRecord, error) {
+	token, err := s.seal([]byte(rec.BearerToken), rec.Name)
+	if err != nil {
+		return sealedClusterRecord{}, fmt.Errorf("encrypt bearer token of cluster %q: %w", rec.Name, err)
+	}
+	key, err := s.seal(rec.Domain.PrivateKey, rec.Name)
+	if err != nil {
+		return sealedClusterRecord{}, fmt.Errorf("encrypt private key of cluster %q: %w", rec.Name, err)
+	}
+	kubeconfig, err := s.seal(rec.Kubeconfig, rec.Name)
+	if err != nil {
+		return sealedClusterRecord{}, fmt.Errorf("encrypt kubeconfig of cluster %q: %w", rec.Name, err)
+	}
+	return sealedClusterRecord{
+		Name:             rec.Name,
+		Server:           rec.Server,
+		CAData:           rec.CAData,
+		BearerToken:      token,
+		DefaultNamespace: rec.DefaultNamespace,
+		Domain:           rec.Domain.Domain,
+		Certificate:      rec.Domain.Certificate,
+		PrivateKey:       key,
+		Kubeconfig:       kubeconfig,
+		Client:           rec.Client,
+	}, nil
+}
+
+func (s *recordSealer) openRecord(sealed sealedClusterRecord) (ClusterRecord, error) {
+	token, err := s.open(sealed.BearerToken, sealed.Name)
+	if err != nil {
+		return ClusterRecord{}, fmt.Errorf("decrypt bearer token of cluster %q: %w", sealed.Name, err)
+	}
+	key, err := s.open(sealed.PrivateKey, sealed.Name)
+	if err != nil {
+		return ClusterRecord{}, fmt.Errorf("decrypt private key of cluster %q: %w", sealed.Name, err)
+	}
+	kubeconfig, err := s.open(sealed.Kubeconfig, sealed.Name)
+	if err != nil {
+		return ClusterRecord{}, fmt.Errorf("decrypt kubeconfig of cluster %q: %w", sealed.Name, err)
+	}
+	return ClusterRecord{
+		Name:             sealed.Name,
+		Server:           sealed.Server,
+		CAData:           sealed.CAData,
+		BearerToken:      string(token),
+		DefaultNamespace: sealed.DefaultNamespace,
+		Domain: DomainConfig{
+			Domain:      sealed.Domain,
+			Certificate: sealed.Certificate,
+			PrivateKey:  key,
+		},
+		Kubeconfig: kubeconfig,
+		Client:     sealed.Client,
+	}, nil
+}
+
+// FileClusterStore keeps clusters in a single JSON file on local disk.
+type FileClusterStore struct {
+	path   string
+	sealer *recordSealer
+	mu     sync.Mutex
+}
+
+// NewFileClusterStore creates a store backed by the file at path, encrypting
+// credentials with key. The file is created on the first Put.
+func NewFileClusterStore(path string, key []byte) (*FileClusterStore, error) {
+	sealer, err := newRecordSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FileClusterStore{path: path, sealer: sealer}, nil
+}
+
+func (s *FileClusterStore) load() (map[string]sealedClusterRecord, error) {
+	records := make(map[string]sealedClusterRecord)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse cluster store %q: %w", s.path, err)
+	}
+	return records, nil
+}
+
+// save writes records to a temporary file and renames it over the store so
+// a crash never leaves a truncated file behind.
+func (s *FileClusterStore) save(records map[string]sealedClusterRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileClusterStore) List(ctx context.Context) ([]ClusterRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ClusterRecord, 0, len(records))
+	for _, sealed := range records {
+		rec, err := s.sealer.openRecord(sealed)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	slices.SortFunc(out, func(a, b ClusterRecord) int { return strings.Compare(a.Name, b.Name) })
+	return out, nil
+}
+
+func (s *FileClusterStore) Put(ctx context.Context, rec ClusterRecord) error {
+	sealed, err := s.sealer.sealRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	records[rec.Name] = sealed
+	return s.save(records)
+}
+
+func (s *FileClusterStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[name]; !ok {
+		return nil
+	}
+	delete(records, name)
+	return s.save(records)
+}
+
+// ClusterStoreSecretName is the name of the Secret used by SecretClusterStore.
+const ClusterStoreSecretName string = "aico-clusters"
+
+// SecretClusterStore keeps clusters in a single Kubernetes Secret, with one
+// data key per cluster name.
+type SecretClusterStore struct {
+	client    kubernetes.Interface
+	namespace string
+	sealer    *recordSealer
+}
+
+// NewSecretClusterStore creates a store backed by the ClusterStoreSecretName
+// Secret in namespace, encrypting credentials with key.
+func NewSecretClusterStore(client kubernetes.Interface, namespace string, key []byte) (*SecretClusterStore, error) {
+	if namespace == "" {
+		return nil, errors.New("cluster store namespace is required")
+	}
+	sealer, err := newRecordSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretClusterStore{client: client, namespace: namespace, sealer: sealer}, nil
+}
+
+func (s *SecretClusterStore) List(ctx context.Context) ([]ClusterRecord, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, ClusterStoreSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cluster store secret: %w", err)
+	}
+
+	out := make([]ClusterRecord, 0, len(secret.Data))
+	for key, data := range secret.Data {
+		var sealed sealedClusterRecord
+		if err := json.Unmarshal(data, &sealed); err != nil {
+			return nil, fmt.Errorf("parse stored cluster %q: %w", key, err)
+		}
+		rec, err := s.sealer.openRecord(sealed)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	slices.SortFunc(out, func(a, b ClusterRecord) int { return strings.Compare(a.Name, b.Name) })
+	return out, nil
+}
+
+// update applies mutate to the store Secret, creating it when missing and
+// retrying on write conflicts.
+func (s *SecretClusterStore) update(ctx context.Context, mutate func(data map[string][]byte)) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, ClusterStoreSecretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      ClusterStoreSecretName,
+					Namespace: s.namespace,
+					Labels:    map[string]string{"app.kubernetes.io/managed-by": "aico"},
+				},
+				Type: corev1.SecretTypeOpaque,
+				Data: make(map[string][]byte),
+			}
+			mutate(secret.Data)
+			_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost a race with another replica, retry as an update.
+				return apierrors.NewConflict(corev1.Resource("secrets"), ClusterStoreSecretName, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		mutate(secret.Data)
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *SecretClusterStore) Put(ctx context.Context, rec ClusterRecord) error {
+	sealed, err := s.sealer.sealRecord(rec)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+	if err := s.update(ctx, func(m map[string][]byte) { m[rec.Name] = data }); err != nil {
+		return fmt.Errorf("store cluster %q: %w", rec.Name, err)
+	}
+	return nil
+}
+
+func (s *SecretClusterStore) Delete(ctx context.Context, name string) error {
+	if err := s.update(ctx, func(m map[string][]byte) { delete(m, name) }); err != nil {
+		return fmt.Errorf("delete cluster %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testStoreKey(b byte) []byte { return bytes.Repeat([]byte{b}, ClusterStoreKeySize) }
+
+func testClusterRecord(name string) ClusterRecord {
+	return ClusterRecord{
+		Name:             name,
+		Server:           "https://" + name + ".example:6443",
+		CAData:           []byte("-----BEGIN CERTIFICATE-----"),
+		BearerToken:      "token-of-" + name,
+		DefaultNamespace: "apps",
+		Domain: DomainConfig{
+			Domain:      name + ".example",
+			Certificate: []byte("certificate"),
+			PrivateKey:  []byte("private-key-of-" + name),
+		},
+		Kubeconfig: []byte("kubeconfig-of-" + name),
+	}
+}
+
+// testClusterStore runs the behaviour shared by every ClusterStore.
+func testClusterStore(t *testing.T, store ClusterStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	got, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List on empty store: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected empty store, got %d records", len(got))
+	}
+
+	for _, name := range []string{"beta", "alpha"} {
+		if err := store.Put(ctx, testClusterRecord(name)); err != nil {
+			t.Fatalf("Put(%s): %v", name, err)
+		}
+	}
+
+	updated := testClusterRecord("beta")
+	updated.BearerToken = "rotated"
+	if err := store.Put(ctx, updated); err != nil {
+		t.Fatalf("Put(beta) update: %v", err)
+	}
+
+	got, err = store.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 2 || got[0].Name != "alpha" || got[1].Name != "beta" {
+		t.Fatalf("expected [alpha beta], got %+v", got)
+	}
+	if got[0].BearerToken != "token-of-alpha" || string(got[0].Domain.PrivateKey) != "private-key-of-alpha" ||
+		string(got[0].Kubeconfig) != "kubeconfig-of-alpha" {
+		t.Errorf("credentials of alpha did not round-trip: %+v", got[0])
+	}
+	if got[1].BearerToken != "rotated" {
+		t.Errorf("expected rotated token for beta, got %q", got[1].BearerToken)
+	}
+
+	if err := store.Delete(ctx, "alpha"); err != nil {
+		t.Fatalf("Delete(alpha): %v", err)
+	}
+	if err := store.Delete(ctx, "missing"); err != nil {
+		t.Fatalf("Delete(missing) should not fail: %v", err)
+	}
+	got, err = store.List(ctx)
+	if err != nil {
+		t.Fatalf("List after delete: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "beta" {
+		t.Fatalf("expected [beta] after delete, got %+v", got)
+	}
+}
+
+func TestFileClusterStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clusters.json")
+	store, err := NewFileClusterStore(path, testStoreKey(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testClusterStore(t, store)
+
+	t.Run("Credentials are encrypted at rest", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read store: %v", err)
+		}
+		for _, secret := range []string{"rotated", "private-key-of-beta", "kubeconfig-of-beta"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("store file contains plaintext %q", secret)
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat store: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("expected file mode 0600, got %o", perm)
+		}
+	})
+
+	t.Run("Wrong key cannot decrypt", func(t *testing.T) {
+		other, err := NewFileClusterStore(path, testStoreKey(2))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := other.List(context.Background()); err == nil {
+			t.Fatal("expected decryption error with wrong key, got nil")
+		}
+	})
+
+	t.Run("Rejects short key", func(t *testing.T) {
+		if _, err := NewFileClusterStore(path, []byte("short")); err == nil {
+			t.Fatal("expected error for short key, got nil")
+		}
+	})
+}
+
+func TestSecretClusterStore(t *testing.T) {
+	clientset := fake.NewClientset()
+	store, err := NewSecretClusterStore(clientset, "aico", testStoreKey(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	testClusterStore(t, store)
+
+	secret, err := clientset.CoreV1().Secrets("aico").Get(context.Background(), ClusterStoreSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected store secret to exist: %v", err)
+	}
+	for key, data := range secret.Data {
+		if bytes.Contains(data, []byte("rotated")) || bytes.Contains(data, []byte("private-key-of-beta")) {
+			t.Errorf("secret key %q contains plaintext credentials", key)
+		}
+	}
+}
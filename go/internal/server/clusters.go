@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/kube/client"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// probeCluster checks that cluster answers /version within timeout and
+// returns its version.
+func probeCluster(ctx context.Context, cluster *Cluster, timeout time.Duration) (*version.Info, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := cluster.Clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("unable to parse the server version: %w", err)
+	}
+	return &info, nil
+}
+
+// validateDomainConfig checks that dc is either empty or complete with a
+// certificate and private key that belong together.
+func validateDomainConfig(dc DomainConfig) error {
+	if dc.Domain == "" && dc.Certificate == nil && dc.PrivateKey == nil {
+		return nil
+	}
+	if dc.Domain == "" || dc.Certificate == nil || dc.PrivateKey == nil {
+		return errors.New("domain, certificate and privateKey must be all provided or all omitted")
+	}
+	if _, err := tls.X509KeyPair(dc.Certificate, dc.PrivateKey); err != nil {
+		return fmt.Errorf("certificate and privateKey are not a valid key pair: %w", err)
+	}
+	return nil
+}
+
+// managedApps returns "<namespace>/<name>" for every Deployment aico manages
+// on cluster.
+func managedApps(ctx context.Context, cluster *Cluster) ([]string, error) {
+	deployments, err := cluster.Clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: ManagedByLabel + "=" + ManagedByValue,
+	})
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]string, 0, len(deployments.Items))
+	for _, d := range deployments.Items {
+		apps = append(apps, d.Namespace+"/"+d.Name)
+	}
+	slices.Sort(apps)
+	return apps, nil
+}
+
+// rotateTLSSecrets replaces the certificate and key in the TLS secret of
+// every namespace that holds a managed app. It returns the updated
+// namespaces and the errors of those that could not be updated.
+func rotateTLSSecrets(ctx context.Context, cluster *Cluster, domain DomainConfig) ([]string, []string) {
+	apps, err := managedApps(ctx, cluster)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("list managed apps: %v", err)}
+	}
+
+	var namespaces []string
+	for _, app := range apps {
+		ns, _, _ := strings.Cut(app, "/")
+		if !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	var updated, failed []string
+	for _, ns := range namespaces {
+		secrets := cluster.Clientset.CoreV1().Secrets(ns)
+		secret, err := secrets.Get(ctx, TLSSecretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", ns, err))
+			continue
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data["tls.crt"] = slices.Clone(domain.Certificate)
+		secret.Data["tls.key"] = slices.Clone(domain.PrivateKey)
+		if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", ns, err))
+			continue
+		}
+		updated = append(updated, ns)
+	}
+	return updated, failed
+}
+
+// handleRemoveCluster unregisters a cluster and deletes it from the cluster
+// store. A cluster that still runs managed apps is only removed with ?force=true.
+func (h *Handler) handleRemoveCluster() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clusterName := r.PathValue("clusterName")
+		if clusterName == DefaultClusterName {
+			http.Error(w, "the default cluster cannot be removed", http.StatusBadRequest)
+			return
+		}
+
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+
+		cluster, ok := h.clusters.Get(clusterName)
+		if !ok {
+			http.Error(w, "cluster not found", http.StatusNotFound)
+			return
+		}
+
+		if !force {
+			apps, err := managedApps(r.Context(), cluster)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to list managed apps, use ?force=true to remove anyway: %v", err), http.StatusBadGateway)
+				return
+			}
+			if len(apps) > 0 {
+				http.Error(w, fmt.Sprintf("cluster %q still has %d managed apps (%s), use ?force=true to remove anyway",
+					clusterName, len(apps), strings.Join(apps, ", ")), http.StatusConflict)
+				return
+			}
+		}
+
+		if h.clusterStore != nil {
+			if err := h.clusterStore.Delete(r.Context(), clusterName); err != nil {
+				h.requestLogger(r).ErrorCtx(r.Context(), "failed to delete stored cluster", "cluster", clusterName, "err", err)
+				http.Error(w, "failed to delete stored cluster", http.StatusInternalServerError)
+				return
+			}
+		}
+		h.clusters.Remove(clusterName)
+
+		h.requestLogger(r).InfoCtx(r.Context(), "cluster removed", "cluster", clusterName, "force", force)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleUpdateCluster replaces the connection settings of an onboarded
+// cluster. With partial set (PATCH) omitted fields keep their current value,
+// otherwise (PUT) the body replaces the cluster like onboarding does. The
+// new settings are probed before they take effect.
+func (h *Handler) handleUpdateCluster(partial bool) http.HandlerFunc {
+	type req struct {
+		Server           string `json:"server"`
+		CAPEM            string `json:"caPEM"`       // PEM string OR base64-encoded PEM
+		BearerToken      string `json:"bearerToken"` // SA token
+		DefaultNamespace string `json:"defaultNamespace,omitempty"`
+		Domain           string `json:"domain,omitempty"`
+		Certificate      []byte `json:"certificate,omitempty"`
+		PrivateKey       []byte `json:"privateKey,omitempty"`
+
+		Client *clientSettingsRequest `json:"client,omitempty"`
+	}
+	type resp struct {
+		Name              string   `json:"name"`
+		Server            string   `json:"server"`
+		Version           string   `json:"k8sVersion"`
+		Namespace         string   `json:"defaultNamespace,omitempty"`
+		TLSSecretsUpdated []string `json:"tlsSecretsUpdated,omitempty"`
+		TLSSecretErrors   []string `json:"tlsSecretErrors,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		clusterName := r.PathValue("clusterName")
+
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MB
+		var in req
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		current, ok := h.clusters.Get(clusterName)
+		if !ok {
+			http.Error(w, "cluster not found", http.StatusNotFound)
+			return
+		}
+		if current.Record.Name == "" {
+			http.Error(w, fmt.Sprintf("cluster %q is not managed through the API", clusterName), http.StatusBadRequest)
+			return
+		}
+
+		rec := ClusterRecord{Name: clusterName}
+		if partial {
+			rec = current.Record
+			if len(rec.Kubeconfig) > 0 && (in.Server != "" || in.CAPEM != "" || in.BearerToken != "") {
+				http.Error(w, "cluster was onboarded from a kubeconfig, replace it with PUT to change its credentials", http.StatusBadRequest)
+				return
+			}
+		}
+		if in.Server != "" {
+			rec.Server = in.Server
+		}
+		if in.CAPEM != "" {
+			caBytes, err := normalizePEM(in.CAPEM)
+			if err != nil {
+				http.Error(w, "invalid caPEM", http.StatusBadRequest)
+				return
+			}
+			rec.CAData = caBytes
+		}
+		if in.BearerToken != "" {
+			rec.BearerToken = in.BearerToken
+		}
+		if in.DefaultNamespace != "" || !partial {
+			rec.DefaultNamespace = in.DefaultNamespace
+		}
+		if in.Domain != "" || !partial {
+			rec.Domain.Domain = in.Domain
+		}
+		if in.Certificate != nil || !partial {
+			rec.Domain.Certificate = in.Certificate
+		}
+		if in.PrivateKey != nil || !partial {
+			rec.Domain.PrivateKey = in.PrivateKey
+		}
+
+		if !strings.HasPrefix(rec.Server, "https://") {
+			http.Error(w, "server must start with https://", http.StatusBadRequest)
+			return
+		}
+		if len(rec.Kubeconfig) == 0 && (len(rec.CAData) == 0 || rec.BearerToken == "") {
+			http.Error(w, "caPEM and bearerToken are required", http.StatusBadRequest)
+			return
+		}
+		if err := validateDomainConfig(rec.Domain); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings, err := h.clientSettings(in.Client, rec.Client)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.Client = settings
+
+		cluster, err := newClusterFromRecord(rec, h.clientDefaults)
+		if err != nil {
+			http.Error(w, "failed to build client", http.StatusInternalServerError)
+			return
+		}
+		probeStart := time.Now()
+		info, err := probeCluster(r.Context(), cluster, h.healthTimeout)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to contact cluster: %v", err), http.StatusBadGateway)
+			return
+		}
+
+		if h.clusterStore != nil {
+			if err := h.clusterStore.Put(r.Context(), rec); err != nil {
+				h.requestLogger(r).ErrorCtx(r.Context(), "failed to store cluster", "cluster", clusterName, "err", err)
+				http.Error(w, "failed to store cluster", http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := h.clusters.Replace(cluster); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		h.health.Observe(cluster, info.GitVersion, time.Since(probeStart), nil)
+
+		out := resp{
+			Name:      clusterName,
+			Server:    rec.Server,
+			Version:   info.GitVersion,
+			Namespace: rec.DefaultNamespace,
+		}
+
+		// Existing apps keep serving the old certificate until their TLS
+		// secret is updated, so roll the new one out with the domain change.
+		if rec.Domain.Domain != "" && !slices.Equal(rec.Domain.Certificate, current.Record.Domain.Certificate) {
+			out.TLSSecretsUpdated, out.TLSSecretErrors = rotateTLSSecrets(r.Context(), cluster, rec.Domain)
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "cluster updated",
+			"cluster", clusterName, "server", rec.Server, "version", info.GitVersion,
+			"tls_secrets_updated", len(out.TLSSecretsUpdated), "tls_secret_errors", len(out.TLSSecretErrors))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// handleAddClusterKubeconfig onboards one cluster per context of an uploaded
+// kubeconfig. Every selected context is probed first and clusters are only
+// registered when all of them respond, so a request either onboards all
+// selected contexts or none.
+func (h *Handler) handleAddClusterKubeconfig() http.HandlerFunc {
+	type req struct {
+		Kubeconfig string   `json:"kubeconfig"`         // YAML OR base64-encoded YAML
+		Contexts   []string `json:"contexts,omitempty"` // defaults to all contexts
+
+		Client *clientSettingsRequest `json:"client,omitempty"`
+	}
+	type result struct {
+		Context   string `json:"context"`
+		Name      string `json:"name"`
+		Server    string `json:"server"`
+		Version   string `json:"k8sVersion,omitempty"`
+		Namespace string `json:"defaultNamespace,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MB
+		var in req
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if in.Kubeconfig == "" {
+			http.Error(w, "kubeconfig is required", http.StatusBadRequest)
+			return
+		}
+
+		data := []byte(in.Kubeconfig)
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(in.Kubeconfig)); err == nil {
+			data = decoded
+		}
+
+		contexts, err := client.ParseKubeconfig(data, in.Contexts, h.allowExecPlugins)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings, err := h.clientSettings(in.Client, ClientSettings{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Every context becomes a cluster named after it
+		for _, kc := range contexts {
+			if !clusterNameRe.MatchString(kc.Name) || kc.Name == DefaultClusterName {
+				http.Error(w, fmt.Sprintf("context %q is not a valid cluster name", kc.Name), http.StatusBadRequest)
+				return
+			}
+			auditObjects(r.Context(), kc.Name)
+			if !h.authorizeScope(w, r, PermClustersWrite, Scope{Cluster: kc.Name}) {
+				return
+			}
+			if _, exists := h.clusters.Get(kc.Name); exists {
+				http.Error(w, fmt.Sprintf("cluster %q already exists", kc.Name), http.StatusConflict)
+				return
+			}
+		}
+
+		records := make([]ClusterRecord, len(contexts))
+		clusters := make([]*Cluster, len(contexts))
+		results := make([]result, len(contexts))
+		latencies := make([]time.Duration, len(contexts))
+		for i, kc := range contexts {
+			records[i] = ClusterRecord{
+				Name:             kc.Name,
+				Server:           kc.Server,
+				DefaultNamespace: kc.Namespace,
+				Kubeconfig:       kc.Kubeconfig,
+				Client:           settings,
+			}
+			results[i] = result{Context: kc.Name, Name: kc.Name, Server: kc.Server, Namespace: kc.Namespace}
+			clusters[i], err = newClusterFromRecord(records[i], h.clientDefaults)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to build client for context %q: %v", kc.Name, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Probe all contexts concurrently with a short timeout
+		var wg sync.WaitGroup
+		for i := range clusters {
+			wg.Go(func() {
+				start := time.Now()
+				info, err := probeCluster(r.Context(), clusters[i], h.healthTimeout)
+				latencies[i] = time.Since(start)
+				if err != nil {
+					results[i].Error = fmt.Sprintf("unable to contact cluster: %v", err)
+					return
+				}
+				results[i].Version = info.GitVersion
+			})
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if slices.ContainsFunc(results, func(res result) bool { return res.Error != "" }) {
+			w.WriteHeader(http.StatusBadGateway)
+			_ = json.NewEncoder(w).Encode(results)
+			return
+		}
+
+		// Register and persist, undoing everything on the first failure
+		var registered []string
+		rollback := func() {
+			for _, name := range registered {
+				h.clusters.Remove(name)
+				if h.clusterStore != nil {
+					_ = h.clusterStore.Delete(context.WithoutCancel(r.Context()), name)
+				}
+			}
+		}
+		for i, cluster := range clusters {
+			if err := h.clusters.Add(cluster); err != nil {
+				rollback()
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			registered = append(registered, cluster.Name)
+			if h.clusterStore != nil {
+				if err := h.clusterStore.Put(r.Context(), records[i]); err != nil {
+					rollback()
+					h.requestLogger(r).ErrorCtx(r.Context(), "failed to store cluster", "cluster", cluster.Name, "err", err)
+					http.Error(w, "failed to store cluster", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		for i, cluster := range clusters {
+			h.health.Observe(cluster, results[i].Version, latencies[i], nil)
+			h.requestLogger(r).InfoCtx(r.Context(), "cluster onboarded",
+				"cluster", cluster.Name, "server", results[i].Server, "version", results[i].Version, "source", "kubeconfig")
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(results)
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHandleRemoveCluster(t *testing.T) {
+	managed := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      "web-deployment",
+		Namespace: "apps",
+		Labels:    map[string]string{ManagedByLabel: ManagedByValue},
+	}}
+	unmanaged := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "apps"}}
+
+	newHandler := func(t *testing.T) (*Handler, ClusterStore) {
+		t.Helper()
+		store, err := NewSecretClusterStore(fake.NewClientset(), "aico", testStoreKey(1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := store.Put(context.Background(), testClusterRecord("edge")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		h := &Handler{logger: log.NewNoOpLogger(), clusters: NewClusterRegistry(), clusterStore: store}
+		_ = h.clusters.Add(&Cluster{Name: DefaultClusterName, Clientset: fake.NewClientset()})
+		_ = h.clusters.Add(&Cluster{Name: "edge", Clientset: fake.NewClientset(managed, unmanaged)})
+		return h, store
+	}
+
+	remove := func(h *Handler, name, query string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.HandleFunc("DELETE /clusters/{clusterName}", h.handleRemoveCluster())
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/clusters/"+name+query, nil))
+		return rec
+	}
+
+	t.Run("Refuses the default cluster", func(t *testing.T) {
+		h, _ := newHandler(t)
+		if rec := remove(h, DefaultClusterName, "?force=true"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Unknown cluster", func(t *testing.T) {
+		h, _ := newHandler(t)
+		if rec := remove(h, "missing", ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Refuses while managed apps exist", func(t *testing.T) {
+		h, _ := newHandler(t)
+		rec := remove(h, "edge", "")
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d", rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, "apps/web-deployment") || strings.Contains(body, "apps/other") {
+			t.Errorf("expected only the managed app to be listed, got %q", body)
+		}
+		if _, ok := h.clusters.Get("edge"); !ok {
+			t.Error("cluster was removed despite the conflict")
+		}
+	})
+
+	t.Run("Force removes from registry and store", func(t *testing.T) {
+		h, store := newHandler(t)
+		if rec := remove(h, "edge", "?force=true"); rec.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, ok := h.clusters.Get("edge"); ok {
+			t.Error("expected cluster to be unregistered")
+		}
+		recs, err := store.List(context.Background())
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(recs) != 0 {
+			t.Errorf("expected store to be empty, got %+v", recs)
+		}
+	})
+}
+
+func TestValidateDomainConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		dc      DomainConfig
+		wantErr bool
+	}{
+		{name: "Empty", dc: DomainConfig{}},
+		{name: "Domain only", dc: DomainConfig{Domain: "edge.example"}, wantErr: true},
+		{name: "Missing key", dc: DomainConfig{Domain: "edge.example", Certificate: []byte("cert")}, wantErr: true},
+		{name: "Invalid pair", dc: DomainConfig{Domain: "edge.example", Certificate: []byte("cert"), PrivateKey: []byte("key")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDomainConfig(tt.dc); (err != nil) != tt.wantErr {
+				t.Errorf("validateDomainConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleAddClusterKubeconfig(t *testing.T) {
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"gitVersion":"v1.34.1"}`))
+	}))
+	t.Cleanup(up.Close)
+	down := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	caData := func(srv *httptest.Server) string {
+		return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	}
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: up
+  cluster: {server: %q, certificate-authority-data: %s}
+- name: down
+  cluster: {server: %q, certificate-authority-data: %s}
+users:
+- name: admin
+  user: {token: secret}
+contexts:
+- name: edge-up
+  context: {cluster: up, user: admin, namespace: apps}
+- name: edge-down
+  context: {cluster: down, user: admin}
+`, up.URL, caData(up), down.URL, caData(down))
+
+	newHandler := func(t *testing.T) *Handler {
+		t.Helper()
+		store, err := NewSecretClusterStore(fake.NewClientset(), "aico", testStoreKey(1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clusters := NewClusterRegistry()
+		return &Handler{
+			logger:        log.NewNoOpLogger(),
+			clusters:      clusters,
+			clusterStore:  store,
+			authorizer:    &Policy{Bindings: []RoleBinding{{Role: RoleClusterAdmin, Subjects: []string{"alice"}}}},
+			health:        NewHealthMonitor(clusters, time.Hour, time.Second, log.NewNoOpLogger()),
+			healthTimeout: time.Second,
+		}
+	}
+
+	onboard := func(h *Handler, contexts ...string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"kubeconfig": kubeconfig, "contexts": contexts})
+		r := httptest.NewRequest(http.MethodPost, "/clusters/kubeconfig", bytes.NewReader(body))
+		r = r.WithContext(WithIdentity(r.Context(), &Identity{Subject: "alice"}))
+		rec := httptest.NewRecorder()
+		h.handleAddClusterKubeconfig().ServeHTTP(rec, r)
+		return rec
+	}
+
+	t.Run("All or nothing", func(t *testing.T) {
+		h := newHandler(t)
+		rec := onboard(h)
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("expected 502, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := h.clusters.List(); len(got) != 0 {
+			t.Errorf("expected nothing registered, got %v", got)
+		}
+	})
+
+	t.Run("Selected context", func(t *testing.T) {
+		h := newHandler(t)
+		rec := onboard(h, "edge-up")
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "v1.34.1") {
+			t.Errorf("expected version in response, got %s", rec.Body.String())
+		}
+		cluster, ok := h.clusters.Get("edge-up")
+		if !ok || cluster.Record.DefaultNamespace != "apps" || len(cluster.Record.Kubeconfig) == 0 {
+			t.Fatalf("expected edge-up to be registered from its kubeconfig, got %+v", cluster)
+		}
+		if health, ok := h.health.Status("edge-up"); !ok || !health.Healthy {
+			t.Errorf("expected onboarding to seed a healthy status, got %+v", health)
+		}
+		recs, err := h.clusterStore.List(context.Background())
+		if err != nil || len(recs) != 1 {
+			t.Fatalf("expected one stored cluster, got %v, %v", recs, err)
+		}
+
+		if rec := onboard(h, "edge-up"); rec.Code != http.StatusConflict {
+			t.Errorf("expected 409 for duplicate, got %d", rec.Code)
+		}
+	})
+}
+
+func TestHandleUpdateClusterDomain(t *testing.T) {
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"gitVersion":"v1.34.1"}`))
+	}))
+	t.Cleanup(up.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{"*.edge.example"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := DomainConfig{
+		Domain:      "edge.example",
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+
+	clusters := NewClusterRegistry()
+	h := &Handler{
+		logger:        log.NewNoOpLogger(),
+		clusters:      clusters,
+		health:        NewHealthMonitor(clusters, time.Hour, time.Second, log.NewNoOpLogger()),
+		healthTimeout: time.Second,
+	}
+	cluster, err := newClusterFromRecord(ClusterRecord{
+		Name:        "edge",
+		Server:      up.URL,
+		CAData:      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: up.Certificate().Raw}),
+		BearerToken: "secret",
+		Domain:      domain,
+	}, h.clientDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = h.clusters.Add(cluster)
+
+	// PATCH {"domain":"apps.edge.example"} keeps the certificate and key
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/clusters/edge", strings.NewReader(`{"domain":"apps.edge.example"}`))
+	r.SetPathValue("clusterName", "edge")
+	h.handleUpdateCluster(true).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	got, _ := h.clusters.Get("edge")
+	want := domain
+	want.Domain = "apps.edge.example"
+	if !reflect.DeepEqual(got.Record.Domain, want) {
+		t.Errorf("expected %+v, got %+v", want, got.Record.Domain)
+	}
+}
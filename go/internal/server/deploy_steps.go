@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// deployStep is one step of a deploy.
+type deployStep struct {
+	Name string
+
+	// Run performs the step. It returns the action undoing it, or nil when
+	// the step changed nothing, e.g. because the object already existed.
+	Run func(ctx context.Context) (undo func(context.Context) error, err error)
+}
+
+// stepLeftover is a step whose undo failed, leaving its object behind.
+type stepLeftover struct {
+	Step  string `json:"step"`
+	Error string `json:"error"`
+}
+
+// deployReport describes how a deploy went. On failure it names the failed
+// step, the steps that were undone and the ones whose objects were left behind.
+type deployReport struct {
+	Completed  []string       `json:"completed"`
+	FailedStep string         `json:"failedStep,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	RolledBack []string       `json:"rolledBack,omitempty"`
+	Leftovers  []stepLeftover `json:"leftovers,omitempty"`
+}
+
+// runDeploySteps runs steps in order. When a step fails, the steps that
+// completed before it are undone in reverse order and the failure is returned.
+// Undoing does not stop at the first error, and it is not cancelled with ctx,
+// so a client disconnecting halfway does not leave more behind.
+func runDeploySteps(ctx context.Context, steps []deployStep) (deployReport, error) {
+	report := deployReport{Completed: []string{}}
+	type done struct {
+		name string
+		undo func(context.Context) error
+	}
+	var completed []done
+
+	for _, step := range steps {
+		undo, err := step.Run(ctx)
+		if err == nil {
+			report.Completed = append(report.Completed, step.Name)
+			completed = append(completed, done{step.Name, undo})
+			continue
+		}
+
+		report.FailedStep, report.Error = step.Name, err.Error()
+		cleanupCtx := context.WithoutCancel(ctx)
+		for i := len(completed) - 1; i >= 0; i-- {
+			if completed[i].undo == nil {
+				continue
+			}
+			if uerr := completed[i].undo(cleanupCtx); uerr != nil && !apierrors.IsNotFound(uerr) {
+				report.Leftovers = append(report.Leftovers, stepLeftover{Step: completed[i].name, Error: uerr.Error()})
+				continue
+			}
+			report.RolledBack = append(report.RolledBack, completed[i].name)
+		}
+		return report, err
+	}
+	return report, nil
+}
+
+// kubeErrorStatus returns the HTTP status to report err from the Kubernetes
+// API with: client errors such as conflicts pass through, anything else is a 500.
+func kubeErrorStatus(err error) int {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		if code := int(status.Status().Code); code >= 400 && code < 500 {
+			return code
+		}
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunDeploySteps(t *testing.T) {
+	var undone []string
+	step := func(name string, runErr, undoErr error, changed bool) deployStep {
+		return deployStep{Name: name, Run: func(context.Context) (func(context.Context) error, error) {
+			if runErr != nil || !changed {
+				return nil, runErr
+			}
+			return func(context.Context) error {
+				undone = append(undone, name)
+				return undoErr
+			}, nil
+		}}
+	}
+
+	t.Run("All steps succeed", func(t *testing.T) {
+		undone = nil
+		report, err := runDeploySteps(context.Background(), []deployStep{
+			step("a", nil, nil, true),
+			step("b", nil, nil, true),
+		})
+		if err != nil || !slices.Equal(report.Completed, []string{"a", "b"}) || len(undone) != 0 {
+			t.Fatalf("unexpected result: %+v, %v, undone %v", report, err, undone)
+		}
+	})
+
+	t.Run("Failure undoes completed steps in reverse", func(t *testing.T) {
+		undone = nil
+		boom := errors.New("boom")
+		report, err := runDeploySteps(context.Background(), []deployStep{
+			step("a", nil, nil, true),
+			step("unchanged", nil, nil, false),
+			step("b", nil, errors.New("still there"), true),
+			step("c", nil, apierrors.NewNotFound(schema.GroupResource{}, "c"), true),
+			step("d", boom, nil, true),
+			step("never", nil, nil, true),
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("expected boom, got %v", err)
+		}
+		if !slices.Equal(undone, []string{"c", "b", "a"}) {
+			t.Errorf("expected c, b, a to be undone in order, got %v", undone)
+		}
+		if report.FailedStep != "d" || report.Error != "boom" {
+			t.Errorf("unexpected failure in report: %+v", report)
+		}
+		// An object that is already gone counts as rolled back
+		if !slices.Equal(report.RolledBack, []string{"c", "a"}) {
+			t.Errorf("unexpected rolled back steps: %v", report.RolledBack)
+		}
+		if len(report.Leftovers) != 1 || report.Leftovers[0].Step != "b" || report.Leftovers[0].Error != "still there" {
+			t.Errorf("unexpected leftovers: %+v", report.Leftovers)
+		}
+	})
+}
+
+func TestHandleDeploymentCreationRollback(t *testing.T) {
+	newServer := func(t *testing.T, objects ...runtime.Object) *testServer {
+		t.Helper()
+		s := newTestServer(t, objects...)
+		s.handle("POST /deployments", s.h.handleDeploymentCreation())
+		return s
+	}
+	create := func(s *testServer) *httptest.ResponseRecorder {
+		body := `{"namespace":"apps","deploymentName":"web","image":"nginx","replicas":1,"ports":[{"containerPort":80}],
+			"resources":{"cpuLimits":"1","cpuRequests":"1","memoryLimits":"1Gi","memoryRequests":"1Gi"}}`
+		return s.serve(http.MethodPost, "/deployments", body)
+	}
+	failIngress := func(clientset *fake.Clientset) {
+		clientset.PrependReactor("create", "ingresses", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewInternalError(errors.New("ingress webhook unavailable"))
+		})
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) deployReport {
+		t.Helper()
+		var report deployReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("decode report: %v", err)
+		}
+		return report
+	}
+
+	t.Run("Undoes everything it created", func(t *testing.T) {
+		s := newServer(t)
+		failIngress(s.clientset)
+
+		rec := create(s)
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500, got %d: %s", rec.Code, rec.Body.String())
+		}
+		report := decode(t, rec)
+		if report.FailedStep != "ingress" || !strings.Contains(report.Error, "ingress webhook unavailable") {
+			t.Errorf("unexpected failure in report: %+v", report)
+		}
+		want := []string{"middleware", "service", "deployment", "tls-secret", "namespace"}
+		if !slices.Equal(report.RolledBack, want) || len(report.Leftovers) != 0 {
+			t.Errorf("expected %v rolled back, got %+v", want, report)
+		}
+
+		ctx := context.Background()
+		if _, err := s.clientset.CoreV1().Namespaces().Get(ctx, "apps", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected namespace to be deleted, got %v", err)
+		}
+		if _, err := s.clientset.AppsV1().Deployments("apps").Get(ctx, "web-deployment", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected deployment to be deleted, got %v", err)
+		}
+		if _, err := s.dynamic.Resource(middlewareGVR).Namespace("apps").Get(ctx, "strip-web-deployment-prefix", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected middleware to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("Keeps what existed before", func(t *testing.T) {
+		existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: TLSSecretName, Namespace: "apps"}}
+		s := newServer(t, existing, secret)
+		failIngress(s.clientset)
+
+		report := decode(t, create(s))
+		if want := []string{"middleware", "service", "deployment"}; !slices.Equal(report.RolledBack, want) {
+			t.Errorf("expected %v rolled back, got %v", want, report.RolledBack)
+		}
+		if _, err := s.clientset.CoreV1().Secrets("apps").Get(context.Background(), TLSSecretName, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the existing secret to be kept: %v", err)
+		}
+	})
+
+	t.Run("Reports leftovers", func(t *testing.T) {
+		s := newServer(t)
+		failIngress(s.clientset)
+		s.clientset.PrependReactor("delete", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("connection refused")
+		})
+
+		report := decode(t, create(s))
+		if len(report.Leftovers) != 1 || report.Leftovers[0].Step != "service" {
+			t.Errorf("expected the service to be reported as left over, got %+v", report)
+		}
+	})
+
+	t.Run("Client errors pass through", func(t *testing.T) {
+		existing := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+		s := newServer(t, existing)
+		s.clientset.PrependReactor("create", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewAlreadyExists(schema.GroupResource{Resource: "services"}, "web-deployment-service")
+		})
+
+		rec := create(s)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if report := decode(t, rec); report.FailedStep != "service" || !slices.Equal(report.RolledBack, []string{"deployment", "tls-secret"}) {
+			t.Errorf("unexpected report: %+v", report)
+		}
+	})
+}
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type DeploymentRequest struct {
+	Namespace      string          `json:"namespace"`
+	DeploymentName string          `json:"deploymentName"`
+	Image          string          `json:"image"`
+	Replicas       int32           `json:"replicas"` // Not required with Autoscaling.
+	Resources      ResourceRequest `json:"resources"`
+	Ports          []PortRequest   `json:"ports"`
+	Env            []EnvVar        `json:"env,omitempty"`
+	EnvFrom        []EnvFromSource `json:"envFrom,omitempty"`
+
+	// Volumes are shared by every container in the pod. Each is mounted in
+	// the app container at its MountPath; other containers mount it through
+	// their VolumeMounts.
+	Volumes []VolumeRequest `json:"volumes,omitempty"`
+
+	// ReadinessProbe gates traffic to the app container; it defaults to a
+	// TCP check of the first TCP port. Slow-starting apps should set a
+	// StartupProbe, which holds off the other probes until it succeeds.
+	ReadinessProbe *ProbeRequest `json:"readinessProbe,omitempty"`
+	LivenessProbe  *ProbeRequest `json:"livenessProbe,omitempty"`
+	StartupProbe   *ProbeRequest `json:"startupProbe,omitempty"`
+
+	// Autoscaling hands the replica count over to a HorizontalPodAutoscaler.
+	Autoscaling *AutoscalingRequest `json:"autoscaling,omitempty"`
+	// Availability overrides the disruption budget and spreading the
+	// availability tier of the namespace gives the app.
+	Availability *AvailabilityRequest `json:"availability,omitempty"`
+
+	// InitContainers run to completion, in order, before the app starts.
+	InitContainers []ContainerRequest `json:"initContainers,omitempty"`
+	// Sidecars run next to the app container for the lifetime of the pod.
+	Sidecars []ContainerRequest `json:"sidecars,omitempty"`
+}
+
+// Port exposures. A public port is routed through the Ingress; an internal
+// one is only reachable through the Service, from inside the cluster.
+const (
+	ExposePublic   = "public"
+	ExposeInternal = "internal"
+)
+
+// PortRequest is a port of the app container. Every port becomes a named
+// port of the app's Service.
+type PortRequest struct {
+	Name          string          `json:"name,omitempty"` // Defaults to <protocol>-<port>, e.g. tcp-8080.
+	ContainerPort int32           `json:"containerPort"`
+	Protocol      corev1.Protocol `json:"protocol,omitempty"` // TCP (default), UDP or SCTP.
+
+	// Expose is ExposePublic or ExposeInternal. The first port defaults to
+	// public and the others to internal.
+	Expose string `json:"expose,omitempty"`
+	// Host and Path route a public port. Without a host the port is served
+	// on the cluster domain, under Path or by default the app's path prefix
+	// for the first port and <prefix>/<name> for the others, with the prefix
+	// stripped before it reaches the app. With a host, Path defaults to /.
+	Host string `json:"host,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// protocol returns the protocol of p, TCP unless set.
+func (p PortRequest) protocol() corev1.Protocol {
+	if p.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return p.Protocol
+}
+
+// name returns the name of p, derived from its protocol and number unless set.
+func (p PortRequest) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s-%d", strings.ToLower(string(p.protocol())), p.ContainerPort)
+}
+
+// containerPorts returns ports as named container ports.
+func containerPorts(ports []PortRequest) []corev1.ContainerPort {
+	var out []corev1.ContainerPort
+	for _, p := range ports {
+		out = append(out, corev1.ContainerPort{Name: p.name(), ContainerPort: p.ContainerPort, Protocol: p.protocol()})
+	}
+	return out
+}
+
+// ResourceRequest holds the CPU and memory quantities of a container.
+type ResourceRequest struct {
+	CPULimits      string `json:"cpuLimits"`
+	CPURequests    string `json:"cpuRequests"`
+	MemoryLimits   string `json:"memoryLimits"`
+	MemoryRequests string `json:"memoryRequests"`
+}
+
+// requirements returns r as Kubernetes resource requirements. The quantities
+// must have been validated.
+func (r ResourceRequest) requirements() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(r.CPULimits),
+			corev1.ResourceMemory: resource.MustParse(r.MemoryLimits),
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(r.CPURequests),
+			corev1.ResourceMemory: resource.MustParse(r.MemoryRequests),
+		},
+	}
+}
+
+// EnvVar is an environment variable, set either to Value or to a key of a
+// Secret or ConfigMap in the app's namespace.
+type EnvVar struct {
+	Name            string  `json:"name"`
+	Value           string  `json:"value,omitempty"`
+	SecretKeyRef    *KeyRef `json:"secretKeyRef,omitempty"`
+	ConfigMapKeyRef *KeyRef `json:"configMapKeyRef,omitempty"`
+}
+
+// KeyRef selects a key of a Secret or ConfigMap.
+type KeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// EnvFromSource sets an environment variable for every key of a ConfigMap or
+// Secret, optionally prefixed.
+type EnvFromSource struct {
+	ConfigMap string `json:"configMap,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+}
+
+// VolumeRequest is a pod volume backed by a ConfigMap, a Secret or an emptyDir.
+type VolumeRequest struct {
+	Name      string           `json:"name"`
+	MountPath string           `json:"mountPath,omitempty"` // In the app container; empty leaves it unmounted there.
+	ReadOnly  bool             `json:"readOnly,omitempty"`
+	ConfigMap string           `json:"configMap,omitempty"`
+	Secret    string           `json:"secret,omitempty"`
+	EmptyDir  *EmptyDirRequest `json:"emptyDir,omitempty"`
+}
+
+// EmptyDirRequest configures a scratch volume that lives as long as the pod.
+type EmptyDirRequest struct {
+	Medium    string `json:"medium,omitempty"` // "" for disk or "Memory".
+	SizeLimit string `json:"sizeLimit,omitempty"`
+}
+
+// VolumeMount mounts one of the app's volumes in an init container or sidecar.
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+func envVars(env []EnvVar) []corev1.EnvVar {
+	var out []corev1.EnvVar
+	for _, e := range env {
+		v := corev1.EnvVar{Name: e.Name, Value: e.Value}
+		switch {
+		case e.SecretKeyRef != nil:
+			v.ValueFrom = &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: e.SecretKeyRef.Name},
+				Key:                  e.SecretKeyRef.Key,
+			}}
+		case e.ConfigMapKeyRef != nil:
+			v.ValueFrom = &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: e.ConfigMapKeyRef.Name},
+				Key:                  e.ConfigMapKeyRef.Key,
+			}}
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func envFromSources(sources []EnvFromSource) []corev1.EnvFromSource {
+	var out []corev1.EnvFromSource
+	for _, s := range sources {
+		from := corev1.EnvFromSource{Prefix: s.Prefix}
+		if s.ConfigMap != "" {
+			from.ConfigMapRef = &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: s.ConfigMap}}
+		} else {
+			from.SecretRef = &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: s.Secret}}
+		}
+		out = append(out, from)
+	}
+	return out
+}
+
+// volume returns v as a pod volume. v must have been validated.
+func (v VolumeRequest) volume() corev1.Volume {
+	vol := corev1.Volume{Name: v.Name}
+	switch {
+	case v.ConfigMap != "":
+		vol.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: v.ConfigMap}}
+	case v.Secret != "":
+		vol.Secret = &corev1.SecretVolumeSource{SecretName: v.Secret}
+	default:
+		vol.EmptyDir = &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMedium(v.EmptyDir.Medium)}
+		if v.EmptyDir.SizeLimit != "" {
+			limit := resource.MustParse(v.EmptyDir.SizeLimit)
+			vol.EmptyDir.SizeLimit = &limit
+		}
+	}
+	return vol
+}
+
+func volumeMounts(mounts []VolumeMount) []corev1.VolumeMount {
+	var out []corev1.VolumeMount
+	for _, m := range mounts {
+		out = append(out, corev1.VolumeMount{Name: m.Name, MountPath: m.MountPath, ReadOnly: m.ReadOnly})
+	}
+	return out
+}
+
+// ContainerRequest describes an init container or sidecar of an app.
+type ContainerRequest struct {
+	Name         string                 `json:"name"`
+	Image        string                 `json:"image"`
+	Command      []string               `json:"command,omitempty"`
+	Args         []string               `json:"args,omitempty"`
+	Resources    ResourceRequest        `json:"resources"`
+	Ports        []corev1.ContainerPort `json:"ports,omitempty"`
+	Env          []EnvVar               `json:"env,omitempty"`
+	EnvFrom      []EnvFromSource        `json:"envFrom,omitempty"`
+	VolumeMounts []VolumeMount          `json:"volumeMounts,omitempty"`
+}
+
+// container returns c as a Kubernetes container. c must have been validated.
+func (c ContainerRequest) container() corev1.Container {
+	return corev1.Container{
+		Name:         c.Name,
+		Image:        c.Image,
+		Command:      c.Command,
+		Args:         c.Args,
+		Resources:    c.Resources.requirements(),
+		Ports:        c.Ports,
+		Env:          envVars(c.Env),
+		EnvFrom:      envFromSources(c.EnvFrom),
+		VolumeMounts: volumeMounts(c.VolumeMounts),
+	}
+}
+
+// mainContainerName is the name of the app container of app.
+func mainContainerName(app string) string {
+	return app + "-container"
+}
+
+// validateResources checks that every quantity of r is set and valid.
+func validateResources(field string, r ResourceRequest) []error {
+	var errs []error
+	for _, q := range []struct{ field, value string }{
+		{"cpuLimits", r.CPULimits},
+		{"cpuRequests", r.CPURequests},
+		{"memoryLimits", r.MemoryLimits},
+		{"memoryRequests", r.MemoryRequests},
+	} {
+		if q.value == "" {
+			errs = append(errs, fmt.Errorf("%s.%s is required", field, q.field))
+		} else if _, err := resource.ParseQuantity(q.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %v", field, q.field, err))
+		}
+	}
+	return errs
+}
+
+// validatePorts checks the container ports of a container at field.
+func validatePorts(field string, ports []corev1.ContainerPort) []error {
+	var errs []error
+	for i, port := range ports {
+		if port.ContainerPort == 0 {
+			errs = append(errs, fmt.Errorf("%s[%d].containerPort is required and must be > 0", field, i))
+		}
+	}
+	return errs
+}
+
+// validateAppPorts checks the ports of the app container and how they are
+// exposed. Only TCP ports can be routed through the HTTP ingress, and no two
+// public ports may share a route.
+func validateAppPorts(in DeploymentRequest) []error {
+	var errs []error
+	names := map[string]string{}
+	numbers := map[string]string{}
+	routes := map[string]string{}
+	for i, port := range in.Ports {
+		f := fmt.Sprintf("ports[%d]", i)
+		if port.ContainerPort <= 0 || port.ContainerPort > 65535 {
+			errs = append(errs, fmt.Errorf("%s.containerPort is required and must be between 1 and 65535", f))
+		}
+		switch port.protocol() {
+		case corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP:
+		default:
+			errs = append(errs, fmt.Errorf("%s.protocol must be TCP, UDP or SCTP", f))
+		}
+		if msgs := validation.IsValidPortName(port.name()); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("%s.name: %s", f, msgs[0]))
+		} else if other, ok := names[port.name()]; ok {
+			errs = append(errs, fmt.Errorf("%s.name %q is already used by %s", f, port.name(), other))
+		}
+		names[port.name()] = f
+		number := fmt.Sprintf("%d/%s", port.ContainerPort, port.protocol())
+		if other, ok := numbers[number]; ok {
+			errs = append(errs, fmt.Errorf("%s: port %s is already declared by %s", f, number, other))
+		}
+		numbers[number] = f
+
+		if port.Expose != "" && port.Expose != ExposePublic && port.Expose != ExposeInternal {
+			errs = append(errs, fmt.Errorf("%s.expose must be %s or %s", f, ExposePublic, ExposeInternal))
+		}
+	}
+
+	for _, port := range appPorts(in) {
+		f := fmt.Sprintf("ports[%d]", port.Index)
+		if !port.Public {
+			if in.Ports[port.Index].Host != "" || in.Ports[port.Index].Path != "" {
+				errs = append(errs, fmt.Errorf("%s: host and path can only be set on public ports", f))
+			}
+			continue
+		}
+		if port.Protocol != corev1.ProtocolTCP {
+			errs = append(errs, fmt.Errorf("%s: %s ports cannot be exposed through the HTTP ingress", f, port.Protocol))
+		}
+		if port.Host != "" {
+			if msgs := validation.IsDNS1123Subdomain(port.Host); len(msgs) > 0 {
+				errs = append(errs, fmt.Errorf("%s.host: %s", f, msgs[0]))
+			}
+		}
+		if !strings.HasPrefix(port.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s.path must start with /", f))
+		} else if port.Host == "" && port.Path == "/" {
+			errs = append(errs, fmt.Errorf("%s.path: the root of the cluster domain is shared by every app; set a host to serve /", f))
+		}
+		route := port.Host + port.Path
+		if other, ok := routes[route]; ok {
+			errs = append(errs, fmt.Errorf("%s: route %s is already used by %s", f, route, other))
+		}
+		routes[route] = f
+	}
+	return errs
+}
+
+// validateEnv checks the env and envFrom of a container. prefix is prepended
+// to the field names in errors.
+func validateEnv(prefix string, env []EnvVar, envFrom []EnvFromSource) []error {
+	var errs []error
+	for i, e := range env {
+		f := fmt.Sprintf("%senv[%d]", prefix, i)
+		if e.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name is required", f))
+		}
+		if e.SecretKeyRef != nil && e.ConfigMapKeyRef != nil {
+			errs = append(errs, fmt.Errorf("%s: only one of secretKeyRef and configMapKeyRef may be set", f))
+		}
+		if (e.SecretKeyRef != nil || e.ConfigMapKeyRef != nil) && e.Value != "" {
+			errs = append(errs, fmt.Errorf("%s: value cannot be combined with a key reference", f))
+		}
+		for _, ref := range []*KeyRef{e.SecretKeyRef, e.ConfigMapKeyRef} {
+			if ref != nil && (ref.Name == "" || ref.Key == "") {
+				errs = append(errs, fmt.Errorf("%s: key references need a name and a key", f))
+			}
+		}
+	}
+	for i, from := range envFrom {
+		if (from.ConfigMap == "") == (from.Secret == "") {
+			errs = append(errs, fmt.Errorf("%senvFrom[%d]: exactly one of configMap and secret must be set", prefix, i))
+		}
+	}
+	return errs
+}
+
+// validateVolumes checks the pod volumes and returns their names.
+func validateVolumes(volumes []VolumeRequest) (map[string]bool, []error) {
+	var errs []error
+	names := map[string]bool{}
+	for i, v := range volumes {
+		f := fmt.Sprintf("volumes[%d]", i)
+		if msgs := validation.IsDNS1123Label(v.Name); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("%s.name: %s", f, msgs[0]))
+		} else if names[v.Name] {
+			errs = append(errs, fmt.Errorf("%s.name %q is already used", f, v.Name))
+		}
+		names[v.Name] = true
+
+		sources := 0
+		for _, set := range []bool{v.ConfigMap != "", v.Secret != "", v.EmptyDir != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			errs = append(errs, fmt.Errorf("%s: exactly one of configMap, secret and emptyDir must be set", f))
+		}
+		if v.EmptyDir != nil {
+			if v.EmptyDir.Medium != "" && v.EmptyDir.Medium != string(corev1.StorageMediumMemory) {
+				errs = append(errs, fmt.Errorf("%s.emptyDir.medium must be empty or Memory", f))
+			}
+			if v.EmptyDir.SizeLimit != "" {
+				if _, err := resource.ParseQuantity(v.EmptyDir.SizeLimit); err != nil {
+					errs = append(errs, fmt.Errorf("%s.emptyDir.sizeLimit: %v", f, err))
+				}
+			}
+		}
+		if v.MountPath != "" && !path.IsAbs(v.MountPath) {
+			errs = append(errs, fmt.Errorf("%s.mountPath must be absolute", f))
+		}
+	}
+	return names, errs
+}
+
+// validateVolumeMounts checks that the mounts of a container at field refer
+// to declared volumes.
+func validateVolumeMounts(field string, mounts []VolumeMount, volumes map[string]bool) []error {
+	var errs []error
+	for i, m := range mounts {
+		f := fmt.Sprintf("%s.volumeMounts[%d]", field, i)
+		if !volumes[m.Name] {
+			errs = append(errs, fmt.Errorf("%s: unknown volume %q", f, m.Name))
+		}
+		if !path.IsAbs(m.MountPath) {
+			errs = append(errs, fmt.Errorf("%s.mountPath must be absolute", f))
+		}
+	}
+	return errs
+}
+
+// validateContainer checks an init container or sidecar at field.
+func validateContainer(field string, c ContainerRequest) []error {
+	var errs []error
+	if c.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name is required", field))
+	} else if msgs := validation.IsDNS1123Label(c.Name); len(msgs) > 0 {
+		errs = append(errs, fmt.Errorf("%s.name: %s", field, msgs[0]))
+	}
+	if c.Image == "" {
+		errs = append(errs, fmt.Errorf("%s.image is required", field))
+	}
+	errs = append(errs, validateResources(field+".resources", c.Resources)...)
+	errs = append(errs, validatePorts(field+".ports", c.Ports)...)
+	errs = append(errs, validateEnv(field+".", c.Env, c.EnvFrom)...)
+	return errs
+}
+
+// validateDeploymentRequestBody checks all required fields in the deployment request body
+func validateDeploymentRequestBody(req DeploymentRequest) error {
+	var err []error
+	if req.Namespace == "" {
+		err = append(err, fmt.Errorf("namespace is required"))
+	}
+	if req.DeploymentName == "" {
+		err = append(err, fmt.Errorf("deploymentName is required"))
+	}
+	if req.Image == "" {
+		err = append(err, fmt.Errorf("image is required"))
+	}
+	if req.Autoscaling == nil && req.Replicas <= 0 {
+		err = append(err, fmt.Errorf("replicas must be greater than 0"))
+	}
+	if req.Autoscaling != nil && req.Replicas < 0 {
+		err = append(err, fmt.Errorf("replicas cannot be negative"))
+	}
+	err = append(err, validateAutoscaling(req.Autoscaling)...)
+	err = append(err, validateResources("resources", req.Resources)...)
+	if len(req.Ports) == 0 {
+		err = append(err, fmt.Errorf("at least one port is required in ports"))
+	}
+	err = append(err, validateAppPorts(req)...)
+	err = append(err, validateEnv("", req.Env, req.EnvFrom)...)
+	probePort := probePort(req.Ports)
+	err = append(err, validateProbe("readinessProbe", req.ReadinessProbe, false, probePort)...)
+	err = append(err, validateProbe("livenessProbe", req.LivenessProbe, true, probePort)...)
+	err = append(err, validateProbe("startupProbe", req.StartupProbe, true, probePort)...)
+	volumes, volumeErrs := validateVolumes(req.Volumes)
+	err = append(err, volumeErrs...)
+
+	// Container names and ports are shared by every container in the pod
+	names := map[string]string{mainContainerName(req.DeploymentName): "the app container"}
+	ports := map[int32]string{}
+	for _, port := range req.Ports {
+		ports[port.ContainerPort] = "ports"
+	}
+	for _, group := range []struct {
+		field      string
+		containers []ContainerRequest
+	}{
+		{"initContainers", req.InitContainers},
+		{"sidecars", req.Sidecars},
+	} {
+		for i, c := range group.containers {
+			field := fmt.Sprintf("%s[%d]", group.field, i)
+			err = append(err, validateContainer(field, c)...)
+			err = append(err, validateVolumeMounts(field, c.VolumeMounts, volumes)...)
+			if other, ok := names[c.Name]; ok && c.Name != "" {
+				err = append(err, fmt.Errorf("%s.name %q is already used by %s", field, c.Name, other))
+			}
+			names[c.Name] = field
+			for _, port := range c.Ports {
+				if other, ok := ports[port.ContainerPort]; ok && port.ContainerPort != 0 {
+					err = append(err, fmt.Errorf("%s: port %d is already used by %s", field, port.ContainerPort, other))
+				}
+				ports[port.ContainerPort] = field
+			}
+		}
+	}
+
+	if len(err) > 0 {
+		return fmt.Errorf("validation failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// testDeploymentRequest returns a valid request for app "web" in namespace "apps".
+func testDeploymentRequest() DeploymentRequest {
+	return DeploymentRequest{
+		Namespace:      "apps",
+		DeploymentName: "web",
+		Image:          "nginx",
+		Replicas:       1,
+		Resources:      ResourceRequest{CPULimits: "1", CPURequests: "100m", MemoryLimits: "1Gi", MemoryRequests: "256Mi"},
+		Ports:          []PortRequest{{ContainerPort: 8080}},
+	}
+}
+
+func testContainerRequest(name string) ContainerRequest {
+	return ContainerRequest{
+		Name:      name,
+		Image:     name + ":latest",
+		Resources: ResourceRequest{CPULimits: "100m", CPURequests: "50m", MemoryLimits: "64Mi", MemoryRequests: "32Mi"},
+	}
+}
+
+func TestValidateDeploymentRequestBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*DeploymentRequest)
+		errs   []string
+	}{
+		{name: "Valid", modify: func(*DeploymentRequest) {}},
+		{
+			name: "Valid with init containers and sidecars",
+			modify: func(r *DeploymentRequest) {
+				weights := testContainerRequest("download-weights")
+				weights.Env = []EnvVar{{Name: "MODEL", Value: "llama"}}
+				proxy := testContainerRequest("auth-proxy")
+				proxy.Ports = []corev1.ContainerPort{{ContainerPort: 4180}}
+				r.InitContainers = []ContainerRequest{weights}
+				r.Sidecars = []ContainerRequest{proxy, testContainerRequest("log-shipper")}
+			},
+		},
+		{
+			name:   "Missing main container fields",
+			modify: func(r *DeploymentRequest) { r.Image, r.Resources.CPULimits, r.Ports = "", "", nil },
+			errs:   []string{"image is required", "resources.cpuLimits is required", "at least one port is required"},
+		},
+		{
+			name: "Sidecar validated like the main container",
+			modify: func(r *DeploymentRequest) {
+				r.Sidecars = []ContainerRequest{{Name: "Auth_Proxy", Resources: ResourceRequest{CPULimits: "lots"},
+					Ports: []corev1.ContainerPort{{}}, Env: []EnvVar{{Value: "x"}}}}
+			},
+			errs: []string{
+				"sidecars[0].name: a lowercase RFC 1123 label",
+				"sidecars[0].image is required",
+				"sidecars[0].resources.cpuLimits: quantities must match",
+				"sidecars[0].resources.memoryRequests is required",
+				"sidecars[0].ports[0].containerPort is required",
+				"sidecars[0].env[0].name is required",
+			},
+		},
+		{
+			name: "Init container without a name",
+			modify: func(r *DeploymentRequest) {
+				c := testContainerRequest("")
+				r.InitContainers = []ContainerRequest{c}
+			},
+			errs: []string{"initContainers[0].name is required"},
+		},
+		{
+			name: "Duplicate names",
+			modify: func(r *DeploymentRequest) {
+				r.InitContainers = []ContainerRequest{testContainerRequest("setup")}
+				r.Sidecars = []ContainerRequest{testContainerRequest("setup"), testContainerRequest("web-container")}
+			},
+			errs: []string{
+				`sidecars[0].name "setup" is already used by initContainers[0]`,
+				`sidecars[1].name "web-container" is already used by the app container`,
+			},
+		},
+		{
+			name: "Valid env and volumes",
+			modify: func(r *DeploymentRequest) {
+				r.Env = []EnvVar{{Name: "MODE", Value: "fast"}, {Name: "PASSWORD", SecretKeyRef: &KeyRef{Name: "db", Key: "password"}}}
+				r.EnvFrom = []EnvFromSource{{ConfigMap: "settings"}, {Secret: "api-keys", Prefix: "API_"}}
+				r.Volumes = []VolumeRequest{
+					{Name: "config", MountPath: "/etc/app", ConfigMap: "settings", ReadOnly: true},
+					{Name: "weights", MountPath: "/weights", EmptyDir: &EmptyDirRequest{SizeLimit: "20Gi"}},
+				}
+				weights := testContainerRequest("download-weights")
+				weights.VolumeMounts = []VolumeMount{{Name: "weights", MountPath: "/out"}}
+				r.InitContainers = []ContainerRequest{weights}
+			},
+		},
+		{
+			name: "Invalid env",
+			modify: func(r *DeploymentRequest) {
+				r.Env = []EnvVar{
+					{Name: "A", Value: "x", SecretKeyRef: &KeyRef{Name: "db", Key: "password"}},
+					{Name: "B", SecretKeyRef: &KeyRef{Name: "db"}, ConfigMapKeyRef: &KeyRef{Name: "cm", Key: "k"}},
+				}
+				r.EnvFrom = []EnvFromSource{{}}
+			},
+			errs: []string{
+				"env[0]: value cannot be combined with a key reference",
+				"env[1]: only one of secretKeyRef and configMapKeyRef may be set",
+				"env[1]: key references need a name and a key",
+				"envFrom[0]: exactly one of configMap and secret must be set",
+			},
+		},
+		{
+			name: "Invalid volumes",
+			modify: func(r *DeploymentRequest) {
+				r.Volumes = []VolumeRequest{
+					{Name: "data", MountPath: "data", ConfigMap: "a", Secret: "b"},
+					{Name: "data", EmptyDir: &EmptyDirRequest{Medium: "Tape", SizeLimit: "big"}},
+				}
+				proxy := testContainerRequest("proxy")
+				proxy.VolumeMounts = []VolumeMount{{Name: "cache", MountPath: "/cache"}}
+				r.Sidecars = []ContainerRequest{proxy}
+			},
+			errs: []string{
+				"volumes[0]: exactly one of configMap, secret and emptyDir must be set",
+				"volumes[0].mountPath must be absolute",
+				`volumes[1].name "data" is already used`,
+				"volumes[1].emptyDir.medium must be empty or Memory",
+				"volumes[1].emptyDir.sizeLimit",
+				`sidecars[0].volumeMounts[0]: unknown volume "cache"`,
+			},
+		},
+		{
+			name: "Valid public and internal ports",
+			modify: func(r *DeploymentRequest) {
+				r.Ports = append(r.Ports,
+					PortRequest{Name: "metrics", ContainerPort: 9090, Expose: ExposePublic, Path: "/web-metrics"},
+					PortRequest{ContainerPort: 9000, Expose: ExposePublic, Host: "grpc.example.com"},
+					PortRequest{ContainerPort: 5353, Protocol: corev1.ProtocolUDP},
+					PortRequest{ContainerPort: 5353, Protocol: corev1.ProtocolTCP, Expose: ExposeInternal},
+				)
+			},
+		},
+		{
+			name: "Invalid ports",
+			modify: func(r *DeploymentRequest) {
+				r.Ports = []PortRequest{
+					{ContainerPort: 8080},
+					{ContainerPort: 8080, Name: "other"},
+					{ContainerPort: 53, Protocol: corev1.ProtocolUDP, Expose: ExposePublic},
+					{ContainerPort: 70000, Protocol: "ICMP", Name: "Not_A_Name"},
+					{ContainerPort: 9000, Expose: "private"},
+					{ContainerPort: 9001, Expose: ExposeInternal, Path: "/internal"},
+					{ContainerPort: 9002, Name: "tcp-8080", Expose: ExposePublic, Path: "/web-deployment"},
+					{ContainerPort: 9003, Expose: ExposePublic, Path: "/"},
+					{ContainerPort: 9004, Expose: ExposePublic, Host: "Bad_Host", Path: "api"},
+				}
+			},
+			errs: []string{
+				"ports[1]: port 8080/TCP is already declared by ports[0]",
+				"ports[2]: UDP ports cannot be exposed through the HTTP ingress",
+				"ports[3].containerPort is required and must be between 1 and 65535",
+				"ports[3].protocol must be TCP, UDP or SCTP",
+				"ports[3].name:",
+				"ports[4].expose must be public or internal",
+				"ports[5]: host and path can only be set on public ports",
+				`ports[6].name "tcp-8080" is already used by ports[0]`,
+				"ports[6]: route /web-deployment is already used by ports[0]",
+				"ports[7].path: the root of the cluster domain is shared by every app",
+				"ports[8].host:",
+				"ports[8].path must start with /",
+			},
+		},
+		{
+			name: "Duplicate ports",
+			modify: func(r *DeploymentRequest) {
+				proxy := testContainerRequest("proxy")
+				proxy.Ports = []corev1.ContainerPort{{ContainerPort: 8080}}
+				r.Sidecars = []ContainerRequest{proxy}
+			},
+			errs: []string{"sidecars[0]: port 8080 is already used by ports"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testDeploymentRequest()
+			tt.modify(&req)
+			err := validateDeploymentRequestBody(req)
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors %q, got none", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAppDeploymentContainers(t *testing.T) {
+	req := testDeploymentRequest()
+	weights := testContainerRequest("download-weights")
+	weights.Command = []string{"fetch", "--to=/weights"}
+	weights.Env = []EnvVar{{Name: "MODEL", Value: "llama"}}
+	req.InitContainers = []ContainerRequest{weights}
+	req.Sidecars = []ContainerRequest{testContainerRequest("auth-proxy")}
+
+	spec := appDeployment(req).Spec.Template.Spec
+	if len(spec.InitContainers) != 1 || spec.InitContainers[0].Name != "download-weights" ||
+		spec.InitContainers[0].Command[0] != "fetch" || spec.InitContainers[0].Env[0].Value != "llama" {
+		t.Errorf("unexpected init containers: %+v", spec.InitContainers)
+	}
+	if len(spec.Containers) != 2 || spec.Containers[0].Name != "web-container" || spec.Containers[1].Name != "auth-proxy" {
+		t.Fatalf("expected the app container followed by the sidecar, got %+v", spec.Containers)
+	}
+	if got := spec.Containers[1].Resources.Limits.Memory().String(); got != "64Mi" {
+		t.Errorf("expected the sidecar's own memory limit, got %s", got)
+	}
+}
+
+func TestAppDeploymentEnvAndVolumes(t *testing.T) {
+	req := testDeploymentRequest()
+	req.Env = []EnvVar{{Name: "PASSWORD", SecretKeyRef: &KeyRef{Name: "db", Key: "password"}}}
+	req.EnvFrom = []EnvFromSource{{ConfigMap: "settings", Prefix: "APP_"}}
+	req.Volumes = []VolumeRequest{
+		{Name: "config", MountPath: "/etc/app", Secret: "tls", ReadOnly: true},
+		{Name: "weights", EmptyDir: &EmptyDirRequest{Medium: "Memory", SizeLimit: "1Gi"}},
+	}
+	weights := testContainerRequest("download-weights")
+	weights.VolumeMounts = []VolumeMount{{Name: "weights", MountPath: "/out"}}
+	req.InitContainers = []ContainerRequest{weights}
+
+	spec := appDeployment(req).Spec.Template.Spec
+	app := spec.Containers[0]
+	if ref := app.Env[0].ValueFrom.SecretKeyRef; ref == nil || ref.Name != "db" || ref.Key != "password" {
+		t.Errorf("unexpected env: %+v", app.Env)
+	}
+	if from := app.EnvFrom[0]; from.ConfigMapRef == nil || from.ConfigMapRef.Name != "settings" || from.Prefix != "APP_" {
+		t.Errorf("unexpected envFrom: %+v", app.EnvFrom)
+	}
+	// Only volumes with a mount path are mounted in the app container
+	if len(app.VolumeMounts) != 1 || app.VolumeMounts[0].MountPath != "/etc/app" || !app.VolumeMounts[0].ReadOnly {
+		t.Errorf("unexpected app mounts: %+v", app.VolumeMounts)
+	}
+	if len(spec.Volumes) != 2 || spec.Volumes[0].Secret.SecretName != "tls" ||
+		spec.Volumes[1].EmptyDir.Medium != corev1.StorageMediumMemory || spec.Volumes[1].EmptyDir.SizeLimit.String() != "1Gi" {
+		t.Errorf("unexpected volumes: %+v", spec.Volumes)
+	}
+	if mounts := spec.InitContainers[0].VolumeMounts; len(mounts) != 1 || mounts[0].Name != "weights" {
+		t.Errorf("unexpected init container mounts: %+v", mounts)
+	}
+}
@@ -0,0 +1,598 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// RevisionAnnotation holds the rollout revision of a Deployment, as set by
+// the deployment controller.
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// liveApp holds the live objects an app is described from. Only the
+// Deployment is required; the others are nil when the app has none.
+type liveApp struct {
+	Deployment       *appsv1.Deployment
+	Ingress          *networkingv1.Ingress
+	Autoscaler       *autoscalingv2.HorizontalPodAutoscaler
+	DisruptionBudget *policyv1.PodDisruptionBudget
+}
+
+// getLiveApp gets the live objects of app in namespace. The autoscaler is
+// whichever scales the app's Deployment.
+func getLiveApp(ctx context.Context, cs kubernetes.Interface, namespace, app string) (liveApp, error) {
+	names := appNamesFor(app)
+	dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, names.Deployment, metav1.GetOptions{})
+	if err != nil {
+		return liveApp{}, fmt.Errorf("get deployment %s: %w", names.Deployment, err)
+	}
+	live := liveApp{Deployment: dep}
+	ing, err := cs.NetworkingV1().Ingresses(namespace).Get(ctx, names.Ingress, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		live.Ingress = ing
+	case !apierrors.IsNotFound(err):
+		return liveApp{}, fmt.Errorf("get ingress %s: %w", names.Ingress, err)
+	}
+	if live.Autoscaler, err = autoscalerFor(ctx, cs, namespace, names.Deployment); err != nil {
+		return liveApp{}, err
+	}
+	pdb, err := cs.PolicyV1().PodDisruptionBudgets(namespace).Get(ctx, names.DisruptionBudget, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		live.DisruptionBudget = pdb
+	case !apierrors.IsNotFound(err):
+		return liveApp{}, fmt.Errorf("get poddisruptionbudget %s: %w", names.DisruptionBudget, err)
+	}
+	return live, nil
+}
+
+// requestFromDeployment describes the live app as the DeploymentRequest that
+// would render it. Ports routed by its Ingress are public, with their host
+// and path. Fields aico does not render are not described.
+func requestFromDeployment(app string, live liveApp, domain DomainConfig) DeploymentRequest {
+	dep, ing := live.Deployment, live.Ingress
+	spec := dep.Spec.Template.Spec
+	in := DeploymentRequest{Namespace: dep.Namespace, DeploymentName: app, Replicas: 1}
+	if dep.Spec.Replicas != nil {
+		in.Replicas = *dep.Spec.Replicas
+	}
+	in.Autoscaling = autoscalingRequestFrom(live.Autoscaler)
+	in.Availability = availabilityRequestFrom(in, dep, live.DisruptionBudget)
+
+	main := slices.IndexFunc(spec.Containers, func(c corev1.Container) bool { return c.Name == mainContainerName(app) })
+	if main < 0 {
+		main = 0
+	}
+	var c corev1.Container
+	if len(spec.Containers) > 0 {
+		c = spec.Containers[main]
+	}
+	in.Image = c.Image
+	in.Resources = resourceRequestFrom(c.Resources)
+	in.Env = envVarsFrom(c.Env)
+	in.EnvFrom = envFromSourcesFrom(c.EnvFrom)
+
+	routes := map[int32][]networkingv1.HTTPIngressPath{}
+	hosts := map[int32]string{}
+	if ing != nil {
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service == nil {
+					continue
+				}
+				number := path.Backend.Service.Port.Number
+				routes[number] = append(routes[number], path)
+				if rule.Host != domain.Domain {
+					hosts[number] = rule.Host
+				}
+			}
+		}
+	}
+	for _, p := range c.Ports {
+		port := PortRequest{Name: p.Name, ContainerPort: p.ContainerPort, Protocol: p.Protocol, Expose: ExposeInternal}
+		if paths := routes[p.ContainerPort]; len(paths) > 0 {
+			port.Expose, port.Host, port.Path = ExposePublic, hosts[p.ContainerPort], paths[0].Path
+		}
+		in.Ports = append(in.Ports, port)
+	}
+	probePort := probePort(in.Ports)
+	in.ReadinessProbe = probeRequestFrom(c.ReadinessProbe, probePort)
+	in.LivenessProbe = probeRequestFrom(c.LivenessProbe, probePort)
+	in.StartupProbe = probeRequestFrom(c.StartupProbe, probePort)
+
+	mounts := map[string]corev1.VolumeMount{}
+	for _, m := range c.VolumeMounts {
+		if _, ok := mounts[m.Name]; !ok {
+			mounts[m.Name] = m
+		}
+	}
+	for _, v := range spec.Volumes {
+		vol := VolumeRequest{Name: v.Name}
+		switch {
+		case v.ConfigMap != nil:
+			vol.ConfigMap = v.ConfigMap.Name
+		case v.Secret != nil:
+			vol.Secret = v.Secret.SecretName
+		case v.EmptyDir != nil:
+			vol.EmptyDir = &EmptyDirRequest{Medium: string(v.EmptyDir.Medium)}
+			if v.EmptyDir.SizeLimit != nil {
+				vol.EmptyDir.SizeLimit = v.EmptyDir.SizeLimit.String()
+			}
+		default:
+			continue
+		}
+		if m, ok := mounts[v.Name]; ok {
+			vol.MountPath, vol.ReadOnly = m.MountPath, m.ReadOnly
+		}
+		in.Volumes = append(in.Volumes, vol)
+	}
+
+	for _, c := range spec.InitContainers {
+		in.InitContainers = append(in.InitContainers, containerRequestFrom(c))
+	}
+	for i, c := range spec.Containers {
+		if i != main {
+			in.Sidecars = append(in.Sidecars, containerRequestFrom(c))
+		}
+	}
+	return in
+}
+
+func resourceRequestFrom(r corev1.ResourceRequirements) ResourceRequest {
+	quantity := func(list corev1.ResourceList, name corev1.ResourceName) string {
+		if q, ok := list[name]; ok {
+			return q.String()
+		}
+		return ""
+	}
+	return ResourceRequest{
+		CPULimits:      quantity(r.Limits, corev1.ResourceCPU),
+		CPURequests:    quantity(r.Requests, corev1.ResourceCPU),
+		MemoryLimits:   quantity(r.Limits, corev1.ResourceMemory),
+		MemoryRequests: quantity(r.Requests, corev1.ResourceMemory),
+	}
+}
+
+func envVarsFrom(env []corev1.EnvVar) []EnvVar {
+	var out []EnvVar
+	for _, e := range env {
+		v := EnvVar{Name: e.Name, Value: e.Value}
+		if e.ValueFrom != nil {
+			if ref := e.ValueFrom.SecretKeyRef; ref != nil {
+				v.SecretKeyRef = &KeyRef{Name: ref.Name, Key: ref.Key}
+			}
+			if ref := e.ValueFrom.ConfigMapKeyRef; ref != nil {
+				v.ConfigMapKeyRef = &KeyRef{Name: ref.Name, Key: ref.Key}
+			}
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func envFromSourcesFrom(sources []corev1.EnvFromSource) []EnvFromSource {
+	var out []EnvFromSource
+	for _, s := range sources {
+		from := EnvFromSource{Prefix: s.Prefix}
+		if s.ConfigMapRef != nil {
+			from.ConfigMap = s.ConfigMapRef.Name
+		}
+		if s.SecretRef != nil {
+			from.Secret = s.SecretRef.Name
+		}
+		out = append(out, from)
+	}
+	return out
+}
+
+// probeRequestFrom describes p, leaving out its port when it is probePort so
+// that the probe follows the first TCP port of the app.
+func probeRequestFrom(p *corev1.Probe, probePort int32) *ProbeRequest {
+	if p == nil {
+		return nil
+	}
+	port := func(port int32) int32 {
+		if port == probePort {
+			return 0
+		}
+		return port
+	}
+	out := &ProbeRequest{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	switch {
+	case p.HTTPGet != nil:
+		out.HTTPGet = &HTTPGetProbe{Path: p.HTTPGet.Path, Port: port(p.HTTPGet.Port.IntVal), Scheme: string(p.HTTPGet.Scheme)}
+	case p.TCPSocket != nil:
+		out.TCPSocket = &TCPSocketProbe{Port: port(p.TCPSocket.Port.IntVal)}
+	case p.Exec != nil:
+		out.Exec = &ExecProbe{Command: p.Exec.Command}
+	}
+	return out
+}
+
+func containerRequestFrom(c corev1.Container) ContainerRequest {
+	out := ContainerRequest{
+		Name:      c.Name,
+		Image:     c.Image,
+		Command:   c.Command,
+		Args:      c.Args,
+		Resources: resourceRequestFrom(c.Resources),
+		Ports:     c.Ports,
+		Env:       envVarsFrom(c.Env),
+		EnvFrom:   envFromSourcesFrom(c.EnvFrom),
+	}
+	for _, m := range c.VolumeMounts {
+		out.VolumeMounts = append(out.VolumeMounts, VolumeMount{Name: m.Name, MountPath: m.MountPath, ReadOnly: m.ReadOnly})
+	}
+	return out
+}
+
+// patchDeploymentRequest applies patch, a JSON merge patch (RFC 7386) of
+// DeploymentRequest fields, to current. Objects such as resources, autoscaling
+// and availability are merged field by field, null removes a field and
+// arrays such as ports, env, volumes and sidecars are replaced whole.
+func patchDeploymentRequest(current DeploymentRequest, patch []byte) (DeploymentRequest, error) {
+	var fields map[string]any
+	if err := decodeJSONNumbers(patch, &fields); err != nil {
+		return DeploymentRequest{}, err
+	}
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return DeploymentRequest{}, err
+	}
+	var merged any
+	if err := decodeJSONNumbers(raw, &merged); err != nil {
+		return DeploymentRequest{}, err
+	}
+	if raw, err = json.Marshal(mergePatch(merged, fields)); err != nil {
+		return DeploymentRequest{}, err
+	}
+	var out DeploymentRequest
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return DeploymentRequest{}, err
+	}
+	return out, nil
+}
+
+// decodeJSONNumbers decodes data into v, keeping numbers as json.Number so
+// they are written back unchanged.
+func decodeJSONNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// mergePatch returns target with patch merged into it as RFC 7386 describes.
+func mergePatch(target, patch any) any {
+	fields, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	merged, ok := target.(map[string]any)
+	if !ok {
+		merged = map[string]any{}
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = mergePatch(merged[k], v)
+	}
+	return merged
+}
+
+// updateApp brings the live objects of the app described by in in line with
+// it, creating or deleting its Middleware and Ingress as its ports require
+// its HorizontalPodAutoscaler as its autoscaling does and its
+// PodDisruptionBudget as its availability does. annotations are
+// set on the Deployment. It returns the updated Deployment.
+func updateApp(ctx context.Context, cluster *Cluster, in DeploymentRequest, domain DomainConfig, annotations map[string]string) (*appsv1.Deployment, error) {
+	cs, ns := cluster.Clientset, in.Namespace
+	names := appNamesFor(in.DeploymentName)
+	opts := metav1.UpdateOptions{FieldManager: FieldManager}
+
+	// The template is replaced whole; annotations set on it by others, such
+	// as the restart time of a rollout restart, are kept
+	want := appDeployment(in)
+	var dep *appsv1.Deployment
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := cs.AppsV1().Deployments(ns).Get(ctx, names.Deployment, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		live.Labels = mergeLabels(live.Labels, want.Labels)
+		live.Annotations = mergeLabels(live.Annotations, annotations)
+		if in.Autoscaling != nil && live.Spec.Replicas != nil {
+			// The autoscaler may have scaled the app since in was described
+			scaled := in
+			scaled.Replicas = *live.Spec.Replicas
+			want.Spec.Replicas = int32Ptr(appReplicas(scaled))
+		}
+		live.Spec.Replicas = want.Spec.Replicas
+		annotations := live.Spec.Template.Annotations
+		live.Spec.Template = want.Spec.Template
+		live.Spec.Template.Annotations = annotations
+		dep, err = cs.AppsV1().Deployments(ns).Update(ctx, live, opts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update deployment %s: %w", names.Deployment, err)
+	}
+
+	hpas := cs.AutoscalingV2().HorizontalPodAutoscalers(ns)
+	if wantHPA := appAutoscaler(in); wantHPA != nil {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := hpas.Get(ctx, names.Autoscaler, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				_, err = hpas.Create(ctx, wantHPA, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.Labels = mergeLabels(live.Labels, wantHPA.Labels)
+			live.Spec = wantHPA.Spec
+			_, err = hpas.Update(ctx, live, opts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update horizontalpodautoscaler %s: %w", names.Autoscaler, err)
+		}
+	} else if err := hpas.Delete(ctx, names.Autoscaler, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("delete horizontalpodautoscaler %s: %w", names.Autoscaler, err)
+	}
+
+	pdbs := cs.PolicyV1().PodDisruptionBudgets(ns)
+	if wantPDB := appDisruptionBudget(in); wantPDB != nil {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := pdbs.Get(ctx, names.DisruptionBudget, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				_, err = pdbs.Create(ctx, wantPDB, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.Labels = mergeLabels(live.Labels, wantPDB.Labels)
+			live.Spec = wantPDB.Spec
+			_, err = pdbs.Update(ctx, live, opts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update poddisruptionbudget %s: %w", names.DisruptionBudget, err)
+		}
+	} else if err := pdbs.Delete(ctx, names.DisruptionBudget, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("delete poddisruptionbudget %s: %w", names.DisruptionBudget, err)
+	}
+
+	wantSvc := appService(in)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := cs.CoreV1().Services(ns).Get(ctx, names.Service, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = cs.CoreV1().Services(ns).Create(ctx, wantSvc, createOptions(false))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		live.Labels = mergeLabels(live.Labels, wantSvc.Labels)
+		live.Spec.Selector = wantSvc.Spec.Selector
+		live.Spec.Ports = wantSvc.Spec.Ports
+		_, err = cs.CoreV1().Services(ns).Update(ctx, live, opts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update service %s: %w", names.Service, err)
+	}
+
+	// The Middleware goes first, so that the Ingress never refers to a
+	// Middleware that does not exist yet, and is removed last
+	mws := cluster.Dynamic.Resource(middlewareGVR).Namespace(ns)
+	wantMw := appMiddleware(in)
+	if wantMw != nil {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := mws.Get(ctx, names.Middleware, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				_, err = mws.Create(ctx, wantMw, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.SetLabels(mergeLabels(live.GetLabels(), wantMw.GetLabels()))
+			live.Object["spec"] = wantMw.Object["spec"]
+			_, err = mws.Update(ctx, live, opts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update middleware %s: %w", names.Middleware, err)
+		}
+	}
+
+	wantIng := appIngress(in, domain)
+	ingresses := cs.NetworkingV1().Ingresses(ns)
+	if wantIng != nil {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := ingresses.Get(ctx, names.Ingress, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				_, err = ingresses.Create(ctx, wantIng, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.Labels = mergeLabels(live.Labels, wantIng.Labels)
+			live.Annotations = mergeLabels(live.Annotations, wantIng.Annotations)
+			if _, ok := wantIng.Annotations[middlewaresAnnotation]; !ok {
+				delete(live.Annotations, middlewaresAnnotation)
+			}
+			live.Spec = wantIng.Spec
+			_, err = ingresses.Update(ctx, live, opts)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update ingress %s: %w", names.Ingress, err)
+		}
+	} else if err := ingresses.Delete(ctx, names.Ingress, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("delete ingress %s: %w", names.Ingress, err)
+	}
+	if wantMw == nil {
+		if err := mws.Delete(ctx, names.Middleware, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("delete middleware %s: %w", names.Middleware, err)
+		}
+	}
+	return dep, nil
+}
+
+// mergeLabels returns live with want set on it. It works for annotations too.
+func mergeLabels(live, want map[string]string) map[string]string {
+	if live == nil {
+		live = map[string]string{}
+	}
+	maps.Copy(live, want)
+	return live
+}
+
+// nextRevision returns the revision the rollout of updated will have. The
+// deployment controller numbers a new rollout one past the highest revision,
+// which is the one on the Deployment, and leaves it when the pod template
+// did not change.
+func nextRevision(before, updated *appsv1.Deployment) int64 {
+	revision := revisionOf(before)
+	if equality.Semantic.DeepEqual(before.Spec.Template, updated.Spec.Template) {
+		return revision
+	}
+	return revision + 1
+}
+
+// handleDeploymentPatch updates an app from a partial DeploymentRequest,
+// applied as a JSON merge patch to the request the live objects describe:
+// nested settings are merged field by field and arrays are replaced whole. The Deployment, Service,
+// Middleware and Ingress are updated together on the selected cluster.
+func (h *Handler) handleDeploymentPatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain := h.domainFor(cluster)
+
+		// Describe the app as it runs now
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, deploymentName)
+		inProgress, err := releaseInProgress(r.Context(), cluster, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get release: %v", err), kubeErrorStatus(err))
+			return
+		}
+		if inProgress {
+			http.Error(w, fmt.Sprintf("a release of app %s is in progress; promote or abort it first", app), http.StatusConflict)
+			return
+		}
+		live, err := getLiveApp(r.Context(), cluster.Clientset, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to describe app: %v", err), kubeErrorStatus(err))
+			return
+		}
+		before := live.Deployment
+		if paused(before) {
+			http.Error(w, fmt.Sprintf("deployment %s is paused; resume it first", before.Name), http.StatusConflict)
+			return
+		}
+		current := requestFromDeployment(app, live, domain)
+
+		// Apply the patch on top of it
+		var patch json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+		in, err := patchDeploymentRequest(current, patch)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if in.Namespace != namespace || in.DeploymentName != app {
+			http.Error(w, "namespace and deploymentName cannot be changed", http.StatusBadRequest)
+			return
+		}
+		if err := validateDeploymentRequestBody(in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in, err = withAvailabilityTier(r.Context(), cluster.Clientset, in); err != nil {
+			http.Error(w, fmt.Sprintf("failed to resolve availability tier: %v", err), kubeErrorStatus(err))
+			return
+		}
+		auditObjects(r.Context(), app)
+
+		// Referenced ConfigMaps and Secrets must exist before anything is changed
+		if err := checkReferences(r.Context(), cluster.Clientset, namespace, appDeployment(in).Spec.Template.Spec); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrMissingReference) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(patch, &fields)
+		cause := "updated " + strings.Join(slices.Sorted(maps.Keys(fields)), ", ")
+		dep, err := updateApp(r.Context(), cluster, in, domain, changeAnnotations(r, cause))
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "deployment update failed",
+				"namespace", namespace, "app", app, "err", err)
+			http.Error(w, err.Error(), kubeErrorStatus(err))
+			return
+		}
+		revision := nextRevision(before, dep)
+
+		h.requestLogger(r).InfoCtx(r.Context(), "deployment updated",
+			"cluster", cluster.Name, "namespace", namespace, "app", app, "revision", revision)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, dep.Name, wait, struct {
+			Namespace  string             `json:"namespace"`
+			App        string             `json:"app"`
+			Revision   int64              `json:"revision"`
+			Request    DeploymentRequest  `json:"request"`
+			Deployment *appsv1.Deployment `json:"deployment"`
+		}{namespace, app, revision, in, dep})
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequestFromDeployment(t *testing.T) {
+	domain := DomainConfig{Domain: "apps.example.com"}
+	req := testDeploymentRequest()
+	req.Ports = []PortRequest{
+		{ContainerPort: 8080},
+		{Name: "metrics", ContainerPort: 9090, Expose: ExposePublic},
+		{ContainerPort: 9000, Expose: ExposePublic, Host: "grpc.example.com"},
+		{ContainerPort: 5353, Protocol: corev1.ProtocolUDP},
+	}
+	req.Env = []EnvVar{{Name: "MODE", Value: "fast"}, {Name: "PASSWORD", SecretKeyRef: &KeyRef{Name: "db", Key: "password"}}}
+	req.EnvFrom = []EnvFromSource{{ConfigMap: "settings", Prefix: "APP_"}}
+	req.Volumes = []VolumeRequest{
+		{Name: "config", MountPath: "/etc/app", ConfigMap: "settings", ReadOnly: true},
+		{Name: "weights", EmptyDir: &EmptyDirRequest{SizeLimit: "1Gi"}},
+	}
+	req.LivenessProbe = &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "/healthz", Port: 9090}, PeriodSeconds: 5}
+	weights := testContainerRequest("download-weights")
+	weights.VolumeMounts = []VolumeMount{{Name: "weights", MountPath: "/out"}}
+	req.InitContainers = []ContainerRequest{weights}
+	req.Sidecars = []ContainerRequest{testContainerRequest("auth-proxy")}
+
+	got := requestFromDeployment("web", liveApp{Deployment: appDeployment(req), Ingress: appIngress(req, domain), Autoscaler: appAutoscaler(req)}, domain)
+	if err := validateDeploymentRequestBody(got); err != nil {
+		t.Fatalf("described request is invalid: %v", err)
+	}
+	// Describing the live app and rendering it again changes nothing
+	if a, b := appDeployment(req), appDeployment(got); !equality.Semantic.DeepEqual(a.Spec, b.Spec) {
+		t.Errorf("deployment differs:\n%+v\n%+v", a.Spec.Template.Spec, b.Spec.Template.Spec)
+	}
+	if a, b := appService(req), appService(got); !equality.Semantic.DeepEqual(a.Spec, b.Spec) {
+		t.Errorf("service differs:\n%+v\n%+v", a.Spec, b.Spec)
+	}
+	if a, b := appIngress(req, domain), appIngress(got, domain); !equality.Semantic.DeepEqual(a, b) {
+		t.Errorf("ingress differs:\n%+v\n%+v", a.Spec, b.Spec)
+	}
+	if a, b := appMiddleware(req), appMiddleware(got); !equality.Semantic.DeepEqual(a, b) {
+		t.Errorf("middleware differs:\n%v\n%v", a, b)
+	}
+}
+
+func TestPatchDeploymentRequest(t *testing.T) {
+	current := testDeploymentRequest()
+	current.Env = []EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}
+
+	got, err := patchDeploymentRequest(current, []byte(`{"image":"nginx:1.28","env":[{"name":"C","value":"3"}],"readinessProbe":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Image != "nginx:1.28" || len(got.Env) != 1 || got.Env[0].Name != "C" {
+		t.Errorf("expected image and env to be replaced, got %+v", got)
+	}
+	if got.Replicas != current.Replicas || got.Resources != current.Resources || len(got.Ports) != 1 {
+		t.Errorf("expected the other fields to be kept, got %+v", got)
+	}
+
+	// Nested settings are merged field by field
+	current.Autoscaling = &AutoscalingRequest{MinReplicas: 2, MaxReplicas: 5, TargetCPUUtilization: int32Ptr(70), ScaleDownStabilizationSeconds: int32Ptr(300)}
+	current.Availability = &AvailabilityRequest{DisruptionBudget: boolPtr(true), SpreadAcrossNodes: boolPtr(true), SpreadAcrossZones: boolPtr(false)}
+	for name, tc := range map[string]struct {
+		patch string
+		check func(DeploymentRequest) bool
+	}{
+		"Resources": {`{"resources":{"cpuLimits":"2"}}`, func(got DeploymentRequest) bool {
+			want := current.Resources
+			want.CPULimits = "2"
+			return got.Resources == want
+		}},
+		"Autoscaling": {`{"autoscaling":{"maxReplicas":10}}`, func(got DeploymentRequest) bool {
+			want := *current.Autoscaling
+			want.MaxReplicas = 10
+			return reflect.DeepEqual(got.Autoscaling, &want)
+		}},
+		"Availability": {`{"availability":{"spreadAcrossZones":true}}`, func(got DeploymentRequest) bool {
+			want := *current.Availability
+			want.SpreadAcrossZones = boolPtr(true)
+			return reflect.DeepEqual(got.Availability, &want)
+		}},
+		"Null removes a field": {`{"autoscaling":{"targetCPUUtilization":null,"targetMemoryUtilization":80}}`, func(got DeploymentRequest) bool {
+			want := *current.Autoscaling
+			want.TargetCPUUtilization, want.TargetMemoryUtilization = nil, int32Ptr(80)
+			return reflect.DeepEqual(got.Autoscaling, &want)
+		}},
+	} {
+		got, err := patchDeploymentRequest(current, []byte(tc.patch))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !tc.check(got) {
+			t.Errorf("%s: expected %s to be merged, got %+v %+v %+v", name, tc.patch, got.Resources, got.Autoscaling, got.Availability)
+		}
+	}
+
+	if _, err := patchDeploymentRequest(current, []byte(`[]`)); err == nil {
+		t.Error("expected an error for a body that is not an object")
+	}
+}
+
+func TestHandleDeploymentPatch(t *testing.T) {
+	newServer := func(t *testing.T) *testServer {
+		t.Helper()
+		s := newTestServer(t)
+		s.handle("POST /deployments", s.h.handleDeploymentCreation())
+		s.handle("PATCH /deployments/{namespace}/{deploymentName}", s.h.handleDeploymentPatch())
+
+		// Create the app as POST /deployments does, at revision 1
+		body := `{"namespace":"apps","deploymentName":"web","image":"nginx:1.27","replicas":2,"ports":[{"containerPort":8080}],
+			"resources":{"cpuLimits":"1","cpuRequests":"100m","memoryLimits":"1Gi","memoryRequests":"256Mi"}}`
+		if rec := s.serve(http.MethodPost, "/deployments", body); rec.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(context.Background(), "web-deployment", metav1.GetOptions{})
+		dep.Annotations = map[string]string{RevisionAnnotation: "1"}
+		dep.Spec.Template.Annotations = map[string]string{"kubectl.kubernetes.io/restartedAt": "yesterday"}
+		_, _ = s.clientset.AppsV1().Deployments("apps").Update(context.Background(), dep, metav1.UpdateOptions{})
+		// The app name is resolved through the dynamic client, which the fakes do not share
+		u, _ := toUnstructured(dep)
+		u.SetAPIVersion("apps/v1")
+		u.SetKind("Deployment")
+		_, _ = s.dynamic.Resource(deploymentGVR).Namespace("apps").Create(context.Background(), u, metav1.CreateOptions{})
+		return s
+	}
+	type result struct {
+		App        string             `json:"app"`
+		Revision   int64              `json:"revision"`
+		Deployment *appsv1.Deployment `json:"deployment"`
+	}
+	patch := func(t *testing.T, s *testServer, target, body string) (*httptest.ResponseRecorder, result) {
+		t.Helper()
+		rec := s.serve(http.MethodPatch, target, body)
+		var got result
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return rec, got
+	}
+	ctx := context.Background()
+
+	t.Run("Image and ports", func(t *testing.T) {
+		s := newServer(t)
+		rec, got := patch(t, s, "/deployments/apps/web-deployment",
+			`{"image":"nginx:1.28","ports":[{"containerPort":8080,"expose":"internal"},{"name":"metrics","containerPort":9090}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if got.App != "web" || got.Revision != 2 {
+			t.Errorf("expected app web at revision 2, got %+v", got)
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(ctx, "web-deployment", metav1.GetOptions{})
+		c := dep.Spec.Template.Spec.Containers[0]
+		if c.Image != "nginx:1.28" || len(c.Ports) != 2 || c.Resources.Limits.Memory().String() != "1Gi" {
+			t.Errorf("unexpected container: %+v", c)
+		}
+		if dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] != "yesterday" {
+			t.Errorf("expected template annotations to be kept, got %v", dep.Spec.Template.Annotations)
+		}
+		svc, _ := s.clientset.CoreV1().Services("apps").Get(ctx, "web-deployment-service", metav1.GetOptions{})
+		if len(svc.Spec.Ports) != 2 || svc.Spec.Ports[1].Name != "metrics" {
+			t.Errorf("unexpected service ports: %+v", svc.Spec.Ports)
+		}
+		// No port is public any more, so the app loses its routing
+		if _, err := s.clientset.NetworkingV1().Ingresses("apps").Get(ctx, "web-ingress", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected the ingress to be deleted, got %v", err)
+		}
+		if _, err := s.dynamic.Resource(middlewareGVR).Namespace("apps").Get(ctx, "strip-web-deployment-prefix", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected the middleware to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("Replicas only keep the revision", func(t *testing.T) {
+		s := newServer(t)
+		rec, got := patch(t, s, "/deployments/apps/web", `{"replicas":3}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if got.Revision != 1 || *got.Deployment.Spec.Replicas != 3 {
+			t.Errorf("expected 3 replicas at revision 1, got %+v", got)
+		}
+		if _, err := s.clientset.NetworkingV1().Ingresses("apps").Get(ctx, "web-ingress", metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the ingress to be kept: %v", err)
+		}
+	})
+
+	t.Run("Public port on a new path", func(t *testing.T) {
+		s := newServer(t)
+		rec, _ := patch(t, s, "/deployments/apps/web", `{"ports":[{"containerPort":8080,"path":"/web"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		ing, _ := s.clientset.NetworkingV1().Ingresses("apps").Get(ctx, "web-ingress", metav1.GetOptions{})
+		if path := ing.Spec.Rules[0].HTTP.Paths[0].Path; path != "/web" {
+			t.Errorf("expected the ingress to route /web, got %s", path)
+		}
+	})
+
+	t.Run("Invalid patches", func(t *testing.T) {
+		s := newServer(t)
+		for name, tc := range map[string]struct {
+			target, body string
+			status       int
+		}{
+			"Unknown app":       {"/deployments/apps/api", `{"replicas":2}`, http.StatusNotFound},
+			"Rename":            {"/deployments/apps/web", `{"deploymentName":"api"}`, http.StatusBadRequest},
+			"Invalid quantity":  {"/deployments/apps/web", `{"resources":{"cpuLimits":"lots"}}`, http.StatusBadRequest},
+			"Missing reference": {"/deployments/apps/web", `{"envFrom":[{"secret":"missing"}]}`, http.StatusBadRequest},
+			"Malformed body":    {"/deployments/apps/web", `{"replicas":`, http.StatusBadRequest},
+		} {
+			if rec, _ := patch(t, s, tc.target, tc.body); rec.Code != tc.status {
+				t.Errorf("%s: expected %d, got %d: %s", name, tc.status, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
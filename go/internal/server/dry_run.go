@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RedactedValue replaces secret values in responses.
+const RedactedValue string = "<redacted>"
+
+// dryRunRequested reports whether r asks for a server-side dry run through
+// the dryRun query parameter.
+func dryRunRequested(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dryRun")
+	if v == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("dryRun must be true or false")
+	}
+	return dryRun, nil
+}
+
+// createOptions returns the options aico creates objects with, as a
+// server-side dry run when dryRun is set.
+func createOptions(dryRun bool) metav1.CreateOptions {
+	opts := metav1.CreateOptions{FieldManager: FieldManager}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return opts
+}
+
+// dryRunResult is the response to a dry-run request.
+type dryRunResult struct {
+	DryRun           bool   `json:"dryRun"`
+	Namespace        string `json:"namespace"`
+	CreatesNamespace bool   `json:"createsNamespace"`
+
+	// ServerValidated is false when the namespace does not exist yet. The API
+	// server refuses dry runs in it, so the objects are only validated by aico.
+	ServerValidated bool             `json:"serverValidated"`
+	Objects         []runtime.Object `json:"objects"`
+}
+
+// redactSecret replaces the values of s by RedactedValue, keeping its keys.
+func redactSecret(s *corev1.Secret) {
+	if len(s.Data) == 0 && len(s.StringData) == 0 {
+		return
+	}
+	redacted := make(map[string]string, len(s.Data)+len(s.StringData))
+	for key := range s.Data {
+		redacted[key] = RedactedValue
+	}
+	for key := range s.StringData {
+		redacted[key] = RedactedValue
+	}
+	s.Data, s.StringData = nil, redacted
+}
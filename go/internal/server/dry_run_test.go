@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDryRun(t *testing.T) {
+	// The fake tracker ignores dry runs, so answer them without storing anything
+	// and fail on any create that would persist.
+	newServer := func(t *testing.T, objects ...runtime.Object) *testServer {
+		t.Helper()
+		s := newTestServer(t, objects...)
+		s.clientset.PrependReactor("create", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			create := action.(k8stesting.CreateActionImpl)
+			if len(create.CreateOptions.DryRun) == 0 {
+				t.Errorf("unexpected create without dry run: %s", create.GetResource().Resource)
+			}
+			return true, create.GetObject(), nil
+		})
+		s.dynamic.PrependReactor("create", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, action.(k8stesting.CreateAction).GetObject(), nil
+		})
+		s.handle("POST /deployments", s.h.handleDeploymentCreation())
+		s.handle("POST /secrets", s.h.handleCreateSecret())
+		s.handle("POST /configmap", s.h.handleCreateConfigMap())
+		return s
+	}
+	type result struct {
+		DryRun           bool             `json:"dryRun"`
+		CreatesNamespace bool             `json:"createsNamespace"`
+		ServerValidated  bool             `json:"serverValidated"`
+		Objects          []map[string]any `json:"objects"`
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) result {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var got result
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+	kinds := func(got result) string {
+		var out []string
+		for _, obj := range got.Objects {
+			out = append(out, obj["kind"].(string))
+		}
+		return strings.Join(out, ",")
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+
+	const deployment = `{"namespace":"apps","deploymentName":"web","image":"nginx","replicas":1,"ports":[{"containerPort":80}],
+		"resources":{"cpuLimits":"1","cpuRequests":"1","memoryLimits":"1Gi","memoryRequests":"1Gi"}}`
+
+	t.Run("Deployment", func(t *testing.T) {
+		s := newServer(t, namespace)
+		got := decode(t, s.serve(http.MethodPost, "/deployments?dryRun=true", deployment))
+		if !got.DryRun || !got.ServerValidated || got.CreatesNamespace {
+			t.Errorf("unexpected result: %+v", got)
+		}
+		if k := kinds(got); k != "Deployment,Service,Middleware,Ingress" {
+			t.Errorf("unexpected objects: %s", k)
+		}
+		if deps, _ := s.clientset.AppsV1().Deployments("apps").List(t.Context(), metav1.ListOptions{}); len(deps.Items) != 0 {
+			t.Errorf("dry run created %d deployments", len(deps.Items))
+		}
+	})
+
+	t.Run("Deployment in a new namespace", func(t *testing.T) {
+		s := newServer(t)
+		got := decode(t, s.serve(http.MethodPost, "/deployments?dryRun=true", deployment))
+		if !got.CreatesNamespace || got.ServerValidated {
+			t.Errorf("expected a namespace to be created without server validation, got %+v", got)
+		}
+		if k := kinds(got); k != "Deployment,Service,Middleware,Ingress" {
+			t.Errorf("unexpected objects: %s", k)
+		}
+	})
+
+	t.Run("Secret values are redacted", func(t *testing.T) {
+		s := newServer(t, namespace)
+		got := decode(t, s.serve(http.MethodPost, "/secrets?dryRun=true",
+			`{"namespace":"apps","secretName":"db","data":{"password":"aHVudGVyMg=="}}`))
+		if len(got.Objects) != 1 {
+			t.Fatalf("expected a single secret, got %+v", got.Objects)
+		}
+		secret := got.Objects[0]
+		if data, _ := secret["stringData"].(map[string]any); data["password"] != RedactedValue || secret["data"] != nil {
+			t.Errorf("expected the secret value to be redacted, got %v", secret)
+		}
+	})
+
+	t.Run("ConfigMap", func(t *testing.T) {
+		s := newServer(t, namespace)
+		got := decode(t, s.serve(http.MethodPost, "/configmap?dryRun=true",
+			`{"namespace":"apps","configMapName":"settings","data":{"mode":"fast"}}`))
+		if k := kinds(got); k != "ConfigMap" || !got.ServerValidated {
+			t.Errorf("unexpected result: %+v", got)
+		}
+	})
+
+	t.Run("Invalid flag", func(t *testing.T) {
+		s := newServer(t, namespace)
+		if rec := s.serve(http.MethodPost, "/deployments?dryRun=maybe", deployment); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// ChangeCauseAnnotation explains the latest change to a Deployment. The
+	// deployment controller copies it, like ChangedByAnnotation, onto the
+	// ReplicaSet of the revision the change rolls out.
+	ChangeCauseAnnotation = "kubernetes.io/change-cause"
+	// ChangedByAnnotation holds the subject that made the latest change.
+	ChangedByAnnotation = "aico.clappform.com/changed-by"
+)
+
+// changeAnnotations returns the annotations recording that the caller of r
+// changed a Deployment because of cause.
+func changeAnnotations(r *http.Request, cause string) map[string]string {
+	annotations := map[string]string{ChangeCauseAnnotation: cause}
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		annotations[ChangedByAnnotation] = id.Subject
+	}
+	return annotations
+}
+
+// revisionEntry is one revision in the rollout history of a Deployment.
+type revisionEntry struct {
+	Revision    int64     `json:"revision"`
+	ReplicaSet  string    `json:"replicaSet"`
+	Images      []string  `json:"images"`
+	Created     time.Time `json:"created"`
+	ChangedBy   string    `json:"changedBy,omitempty"`
+	ChangeCause string    `json:"changeCause,omitempty"`
+	Replicas    int32     `json:"replicas"`
+	Current     bool      `json:"current"`
+}
+
+// revisionOf returns the revision annotation of obj, or 0 when it has none.
+func revisionOf(obj metav1.Object) int64 {
+	revision, _ := strconv.ParseInt(obj.GetAnnotations()[RevisionAnnotation], 10, 64)
+	return revision
+}
+
+// deploymentReplicaSets returns the ReplicaSets dep controls, oldest
+// revision first.
+func deploymentReplicaSets(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	list, err := cs.AppsV1().ReplicaSets(dep.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("list replicasets: %w", err)
+	}
+	owned := slices.DeleteFunc(list.Items, func(rs appsv1.ReplicaSet) bool { return !metav1.IsControlledBy(&rs, dep) })
+	slices.SortFunc(owned, func(a, b appsv1.ReplicaSet) int { return cmp.Compare(revisionOf(&a), revisionOf(&b)) })
+	return owned, nil
+}
+
+// revisionHistory returns the rollout history of dep, oldest revision first.
+func revisionHistory(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment) ([]revisionEntry, error) {
+	replicaSets, err := deploymentReplicaSets(ctx, cs, dep)
+	if err != nil {
+		return nil, err
+	}
+	current := revisionOf(dep)
+	history := []revisionEntry{}
+	for _, rs := range replicaSets {
+		entry := revisionEntry{
+			Revision:    revisionOf(&rs),
+			ReplicaSet:  rs.Name,
+			Images:      []string{},
+			Created:     rs.CreationTimestamp.Time,
+			ChangedBy:   rs.Annotations[ChangedByAnnotation],
+			ChangeCause: rs.Annotations[ChangeCauseAnnotation],
+			Replicas:    rs.Status.Replicas,
+		}
+		entry.Current = entry.Revision == current
+		for _, c := range slices.Concat(rs.Spec.Template.Spec.InitContainers, rs.Spec.Template.Spec.Containers) {
+			entry.Images = append(entry.Images, c.Image)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// handleDeploymentHistory lists the revisions of a Deployment that can
+// still be rolled back to, built from its ReplicaSets.
+func (h *Handler) handleDeploymentHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dep, err := cluster.Clientset.AppsV1().Deployments(namespace).Get(r.Context(), deploymentName, metav1.GetOptions{})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), kubeErrorStatus(err))
+			return
+		}
+		history, err := revisionHistory(r.Context(), cluster.Clientset, dep)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Namespace string          `json:"namespace"`
+			Name      string          `json:"name"`
+			Revisions []revisionEntry `json:"revisions"`
+		}{namespace, deploymentName, history})
+	}
+}
+
+// errRevisionNotFound is returned by rollbackDeployment for a revision the
+// Deployment has no ReplicaSet of.
+var errRevisionNotFound = errors.New("revision not found")
+
+// errCurrentRevision is returned by rollbackDeployment for the revision the
+// Deployment already runs.
+var errCurrentRevision = errors.New("revision is already the current revision")
+
+// rollbackDeployment restores the pod template of revision on the
+// Deployment name, which the deployment controller rolls out as a new
+// revision. annotations are set on the Deployment. Only the pod template is
+// restored: the replica count, the Service and the Ingress are left alone.
+func rollbackDeployment(ctx context.Context, cs kubernetes.Interface, namespace, name string, revision int64, annotations map[string]string) (before, after *appsv1.Deployment, err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		before = dep.DeepCopy()
+		if revisionOf(dep) == revision {
+			return errCurrentRevision
+		}
+		replicaSets, err := deploymentReplicaSets(ctx, cs, dep)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(replicaSets, func(rs appsv1.ReplicaSet) bool { return revisionOf(&rs) == revision })
+		if i < 0 {
+			return errRevisionNotFound
+		}
+
+		// The hash label is added by the controller for the ReplicaSet alone
+		template := *replicaSets[i].Spec.Template.DeepCopy()
+		delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+		dep.Spec.Template = template
+		dep.Annotations = mergeLabels(dep.Annotations, annotations)
+		after, err = cs.AppsV1().Deployments(namespace).Update(ctx, dep, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+	return before, after, err
+}
+
+// handleDeploymentRollback rolls a Deployment back to the pod template of
+// an earlier revision, given by the revision query parameter. The rollback
+// is recorded as a new revision.
+func (h *Handler) handleDeploymentRollback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+		revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+		if err != nil || revision <= 0 {
+			http.Error(w, "revision must be a positive revision number", http.StatusBadRequest)
+			return
+		}
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		annotations := changeAnnotations(r, fmt.Sprintf("rolled back to revision %d", revision))
+		before, dep, err := rollbackDeployment(r.Context(), cluster.Clientset, namespace, deploymentName, revision, annotations)
+		switch {
+		case errors.Is(err, errRevisionNotFound):
+			http.Error(w, fmt.Sprintf("revision %d of deployment %s not found", revision, deploymentName), http.StatusNotFound)
+			return
+		case errors.Is(err, errCurrentRevision):
+			http.Error(w, fmt.Sprintf("revision %d is the current revision of deployment %s", revision, deploymentName), http.StatusConflict)
+			return
+		case apierrors.IsNotFound(err):
+			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("failed to roll back deployment: %v", err), kubeErrorStatus(err))
+			return
+		}
+		newRevision := nextRevision(before, dep)
+
+		h.requestLogger(r).InfoCtx(r.Context(), "deployment rolled back", "cluster", cluster.Name,
+			"namespace", namespace, "deployment", deploymentName, "to", revision, "revision", newRevision)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, deploymentName, wait, struct {
+			Namespace    string             `json:"namespace"`
+			Name         string             `json:"name"`
+			RolledBackTo int64              `json:"rolledBackTo"`
+			Revision     int64              `json:"revision"`
+			Deployment   *appsv1.Deployment `json:"deployment"`
+		}{namespace, deploymentName, revision, newRevision, dep})
+	}
+}
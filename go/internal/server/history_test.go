@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testHistory returns Deployment web-deployment at revision 3 and the
+// ReplicaSets of its three revisions, plus one it does not control.
+func testHistory() []runtime.Object {
+	labels := map[string]string{"app": "web"}
+	template := func(image string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web-container", Image: image}}},
+		}
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-deployment", Namespace: "apps", UID: "dep-uid",
+			Annotations: map[string]string{RevisionAnnotation: "3"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(2),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: template("nginx:1.29"),
+		},
+	}
+	controller := true
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: dep.Name, UID: dep.UID, Controller: &controller}}
+	rs := func(name, revision, image, by, cause string, owners []metav1.OwnerReference) *appsv1.ReplicaSet {
+		t := template(image)
+		t.Labels = map[string]string{"app": "web", appsv1.DefaultDeploymentUniqueLabelKey: name}
+		return &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "web-deployment-" + name, Namespace: "apps", Labels: t.Labels, OwnerReferences: owners,
+				CreationTimestamp: metav1.NewTime(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)),
+				Annotations:       map[string]string{RevisionAnnotation: revision, ChangedByAnnotation: by, ChangeCauseAnnotation: cause},
+			},
+			Spec: appsv1.ReplicaSetSpec{Selector: dep.Spec.Selector, Template: t},
+		}
+	}
+	return []runtime.Object{
+		dep,
+		rs("ccc", "3", "nginx:1.29", "bob", "updated image", owner),
+		rs("aaa", "1", "nginx:1.27", "alice", "created with image nginx:1.27", owner),
+		rs("bbb", "2", "nginx:1.28", "alice", "updated image", owner),
+		rs("zzz", "7", "other:1", "eve", "", []metav1.OwnerReference{{Kind: "Deployment", Name: "other", UID: types.UID("other-uid"), Controller: &controller}}),
+	}
+}
+
+func TestDeploymentHistory(t *testing.T) {
+	s := newTestServer(t, testHistory()...)
+	s.handle("GET /deployments/{namespace}/{deploymentName}/history", s.h.handleDeploymentHistory())
+	s.handle("POST /deployments/{namespace}/{deploymentName}/rollback", s.h.handleDeploymentRollback())
+	clientset := s.clientset
+
+	t.Run("History", func(t *testing.T) {
+		rec := s.serve(http.MethodGet, "/deployments/apps/web-deployment/history", "")
+		var got struct{ Revisions []revisionEntry }
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if len(got.Revisions) != 3 {
+			t.Fatalf("expected the 3 revisions of the deployment, got %+v", got.Revisions)
+		}
+		first, last := got.Revisions[0], got.Revisions[2]
+		if first.Revision != 1 || first.Images[0] != "nginx:1.27" || first.ChangedBy != "alice" || first.Current {
+			t.Errorf("unexpected first revision: %+v", first)
+		}
+		if last.Revision != 3 || !last.Current || last.ChangeCause != "updated image" {
+			t.Errorf("unexpected last revision: %+v", last)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		rec := s.serve(http.MethodPost, "/deployments/apps/web-deployment/rollback?revision=1", "")
+		var got struct {
+			RolledBackTo int64 `json:"rolledBackTo"`
+			Revision     int64 `json:"revision"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if got.RolledBackTo != 1 || got.Revision != 4 {
+			t.Errorf("expected revision 1 rolled out as revision 4, got %+v", got)
+		}
+		dep, _ := clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.27" {
+			t.Errorf("expected the template of revision 1, got image %s", image)
+		}
+		if _, ok := dep.Spec.Template.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+			t.Errorf("expected the pod-template-hash label to be dropped, got %v", dep.Spec.Template.Labels)
+		}
+		if dep.Annotations[ChangedByAnnotation] != "alice" || dep.Annotations[ChangeCauseAnnotation] != "rolled back to revision 1" {
+			t.Errorf("expected the rollback to be recorded, got %v", dep.Annotations)
+		}
+	})
+
+	t.Run("Invalid rollbacks", func(t *testing.T) {
+		for target, status := range map[string]int{
+			"/deployments/apps/web-deployment/rollback?revision=3":   http.StatusConflict,
+			"/deployments/apps/web-deployment/rollback?revision=7":   http.StatusNotFound,
+			"/deployments/apps/web-deployment/rollback?revision=abc": http.StatusBadRequest,
+			"/deployments/apps/web-deployment/rollback":              http.StatusBadRequest,
+			"/deployments/apps/api-deployment/rollback?revision=1":   http.StatusNotFound,
+		} {
+			// The deployment is at revision 3 again for every case
+			dep, _ := clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+			dep.Annotations[RevisionAnnotation] = "3"
+			_, _ = clientset.AppsV1().Deployments("apps").Update(t.Context(), dep, metav1.UpdateOptions{})
+
+			if rec := s.serve(http.MethodPost, target, ""); rec.Code != status {
+				t.Errorf("%s: expected %d, got %d: %s", target, status, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// serverManagedAnnotations are set by Kubernetes, kubectl or aico and mean
+// nothing to whoever takes over an exported manifest.
+var serverManagedAnnotations = []string{
+	RevisionAnnotation,
+	"kubectl.kubernetes.io/last-applied-configuration",
+	ChangeCauseAnnotation,
+	ChangedByAnnotation,
+}
+
+// exportable strips obj of its status and of every field the API server
+// manages, leaving what is needed to recreate it.
+func exportable(obj *unstructured.Unstructured) {
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		for _, key := range serverManagedAnnotations {
+			delete(annotations, key)
+		}
+		obj.SetAnnotations(annotations)
+		if len(annotations) == 0 {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+		}
+	}
+	// Cluster IPs are allocated by the cluster the Service lives in
+	if obj.GetKind() == "Service" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+	}
+}
+
+// podSpecReferences returns the names of the ConfigMaps and Secrets spec
+// refers to. Names may repeat.
+func podSpecReferences(spec corev1.PodSpec) (configMaps, secrets []string) {
+	add := func(names *[]string, name string) {
+		if name != "" {
+			*names = append(*names, name)
+		}
+	}
+	for _, c := range slices.Concat(spec.InitContainers, spec.Containers) {
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				add(&configMaps, from.ConfigMapRef.Name)
+			}
+			if from.SecretRef != nil {
+				add(&secrets, from.SecretRef.Name)
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				add(&configMaps, ref.Name)
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				add(&secrets, ref.Name)
+			}
+		}
+	}
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			add(&configMaps, v.ConfigMap.Name)
+		}
+		if v.Secret != nil {
+			add(&secrets, v.Secret.SecretName)
+		}
+		if v.Projected != nil {
+			for _, src := range v.Projected.Sources {
+				if src.ConfigMap != nil {
+					add(&configMaps, src.ConfigMap.Name)
+				}
+				if src.Secret != nil {
+					add(&secrets, src.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, ref := range spec.ImagePullSecrets {
+		add(&secrets, ref.Name)
+	}
+	return configMaps, secrets
+}
+
+// referencedObjects returns the ConfigMaps and Secrets the app's pods refer
+// to. Secret values are redacted; references to objects that do not exist are
+// skipped. The TLS secret is shared by every app in the namespace, so it is
+// left to the reference in the Ingress rather than exported.
+func referencedObjects(ctx context.Context, cs kubernetes.Interface, namespace string, objects []appObject) ([]*unstructured.Unstructured, error) {
+	var configMaps, secrets []string
+	for _, o := range objects {
+		if o.GVR != deploymentGVR {
+			continue
+		}
+		var dep appsv1.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(o.Object.Object, &dep); err != nil {
+			return nil, fmt.Errorf("decode deployment %s: %w", o.Object.GetName(), err)
+		}
+		cms, ss := podSpecReferences(dep.Spec.Template.Spec)
+		configMaps = append(configMaps, cms...)
+		secrets = append(secrets, ss...)
+	}
+	slices.Sort(configMaps)
+	slices.Sort(secrets)
+
+	var out []*unstructured.Unstructured
+	for _, name := range slices.Compact(configMaps) {
+		cm, err := cs.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get configmap %s: %w", name, err)
+		}
+		cm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		u, err := toUnstructured(cm)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	for _, name := range slices.Compact(secrets) {
+		secret, err := cs.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get secret %s: %w", name, err)
+		}
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		redactSecret(secret)
+		u, err := toUnstructured(secret)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+// handleAppManifests exports the live objects of an app, and the ConfigMaps
+// and Secrets they refer to, stripped of everything the cluster manages. The
+// default format is a multi-document YAML stream; format=json returns a List.
+func (h *Handler) handleAppManifests() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace, name := r.PathValue("namespace"), r.PathValue("name")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "yaml"
+		}
+		if format != "yaml" && format != "json" {
+			http.Error(w, "format must be yaml or json", http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, name)
+		objects, err := findAppObjects(r.Context(), cluster.Dynamic, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to find app objects: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(objects) == 0 {
+			http.Error(w, fmt.Sprintf("app %s not found in namespace %s", app, namespace), http.StatusNotFound)
+			return
+		}
+		referenced, err := referencedObjects(r.Context(), cluster.Clientset, namespace, objects)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to collect referenced objects: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Referenced objects first and the Ingress last, so the bundle applies
+		// in order; findAppObjects returns the app in deletion order
+		manifests := referenced
+		for _, o := range slices.Backward(objects) {
+			manifests = append(manifests, o.Object)
+		}
+		for _, obj := range manifests {
+			exportable(obj)
+		}
+
+		if format == "json" {
+			list := map[string]any{"apiVersion": "v1", "kind": "List", "items": manifests}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(list)
+			return
+		}
+
+		var buf bytes.Buffer
+		for i, obj := range manifests {
+			doc, err := yaml.Marshal(obj.Object)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to encode %s %s: %v", obj.GetKind(), obj.GetName(), err), http.StatusInternalServerError)
+				return
+			}
+			if i > 0 {
+				buf.WriteString("---\n")
+			}
+			buf.Write(doc)
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(buf.Bytes())
+	}
+}
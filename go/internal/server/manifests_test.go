@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+func TestHandleAppManifests(t *testing.T) {
+	in := testDeploymentRequest()
+
+	dep := appDeployment(in)
+	dep.Annotations = map[string]string{"deployment.kubernetes.io/revision": "3"}
+	dep.Spec.Template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+	}
+	dep.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"},
+	}}}
+	dep.Spec.Template.Spec.Volumes = []corev1.Volume{{Name: "missing", VolumeSource: corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "gone"}},
+	}}}
+	dep.Status.Replicas = 1
+	svc := appService(in)
+	svc.Spec.ClusterIP = "10.0.0.7"
+
+	var objects []runtime.Object
+	for _, typed := range []runtime.Object{dep, svc, appIngress(in, DomainConfig{Domain: "example.com"})} {
+		u, err := toUnstructured(typed)
+		if err != nil {
+			t.Fatalf("toUnstructured: %v", err)
+		}
+		u.SetNamespace("apps")
+		u.SetResourceVersion("42")
+		u.SetUID("1234")
+		u.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: FieldManager}})
+		objects = append(objects, u)
+	}
+	objects = append(objects, appMiddleware(in),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "apps"}, Data: map[string]string{"mode": "fast"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}, Data: map[string][]byte{"password": []byte("hunter2")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: TLSSecretName, Namespace: "apps"}, Data: map[string][]byte{"tls.key": []byte("private")}},
+	)
+	s := newTestServer(t, objects...)
+	s.handle("GET /apps/{namespace}/{name}/manifests", s.h.handleAppManifests())
+	get := func(target string) *httptest.ResponseRecorder {
+		return s.serve(http.MethodGet, target, "")
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		rec := get("/apps/apps/web/manifests")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		body := rec.Body.String()
+		for _, leaked := range []string{"hunter2", "private", "resourceVersion", "managedFields", "uid:", "status:", "10.0.0.7", "deployment.kubernetes.io/revision"} {
+			if strings.Contains(body, leaked) {
+				t.Errorf("bundle contains %q:\n%s", leaked, body)
+			}
+		}
+
+		var kinds []string
+		for _, doc := range bytes.Split(rec.Body.Bytes(), []byte("---\n")) {
+			var obj unstructured.Unstructured
+			if err := yaml.Unmarshal(doc, &obj.Object); err != nil {
+				t.Fatalf("invalid YAML document: %v\n%s", err, doc)
+			}
+			kinds = append(kinds, obj.GetKind()+"/"+obj.GetName())
+		}
+		want := "ConfigMap/settings,Secret/db,Deployment/web-deployment,Service/web-deployment-service,Middleware/strip-web-deployment-prefix,Ingress/web-ingress"
+		if got := strings.Join(kinds, ","); got != want {
+			t.Errorf("unexpected documents:\n got %s\nwant %s", got, want)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		rec := get("/apps/apps/web-deployment/manifests?format=json")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var list struct {
+			Kind  string           `json:"kind"`
+			Items []map[string]any `json:"items"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if list.Kind != "List" || len(list.Items) != 6 {
+			t.Errorf("expected a List of 6 objects, got %s with %d", list.Kind, len(list.Items))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if rec := get("/apps/apps/web/manifests?format=xml"); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for an unknown format, got %d", rec.Code)
+		}
+		if rec := get("/apps/apps/missing/manifests"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown app, got %d", rec.Code)
+		}
+	})
+}
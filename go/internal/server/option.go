@@ -1,6 +1,11 @@
 package server
 
-import "github.com/ClappFormOrg/AI-CO/go/pkg/log"
+import (
+	"time"
+
+	"github.com/ClappFormOrg/AI-CO/go/internal/audit"
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+)
 
 type Option func(*Handler)
 
@@ -21,3 +26,51 @@ func WithSecretTLSCert(crt []byte) Option {
 		h.tlsCrt = crt
 	}
 }
+
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(h *Handler) {
+		h.authenticator = authenticator
+	}
+}
+
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(h *Handler) {
+		h.authorizer = authorizer
+	}
+}
+
+func WithClusterStore(store ClusterStore) Option {
+	return func(h *Handler) {
+		h.clusterStore = store
+	}
+}
+
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(h *Handler) {
+		h.healthInterval = interval
+	}
+}
+
+func WithHealthCheckTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.healthTimeout = timeout
+	}
+}
+
+func WithKubeconfigExecPlugins(allow bool) Option {
+	return func(h *Handler) {
+		h.allowExecPlugins = allow
+	}
+}
+
+func WithClientDefaults(settings ClientSettings) Option {
+	return func(h *Handler) {
+		h.clientDefaults = settings
+	}
+}
+
+func WithAuditSink(sink audit.Sink) Option {
+	return func(h *Handler) {
+		h.auditSink = sink
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// PausedReplicasAnnotation holds the replica count of a paused
+	// Deployment, which resuming it restores. Its presence marks the
+	// Deployment paused.
+	PausedReplicasAnnotation = "aico.clappform.com/paused-replicas"
+	// PausedAutoscalerAnnotation holds the HorizontalPodAutoscaler of a
+	// paused Deployment, deleted while it is paused, as pausedAutoscaler JSON.
+	PausedAutoscalerAnnotation = "aico.clappform.com/paused-autoscaler"
+)
+
+// errPaused is returned by pauseDeployment for a Deployment already paused.
+var errPaused = errors.New("deployment is already paused")
+
+// errNotPaused is returned by resumeDeployment for a Deployment that is not
+// paused.
+var errNotPaused = errors.New("deployment is not paused")
+
+// pausedAutoscaler is what is kept of an autoscaler while its Deployment is
+// paused: enough to create it again as it was.
+type pausedAutoscaler struct {
+	Name        string                                    `json:"name"`
+	Labels      map[string]string                         `json:"labels,omitempty"`
+	Annotations map[string]string                         `json:"annotations,omitempty"`
+	Spec        autoscalingv2.HorizontalPodAutoscalerSpec `json:"spec"`
+}
+
+// paused reports whether dep is paused.
+func paused(dep *appsv1.Deployment) bool {
+	_, ok := dep.Annotations[PausedReplicasAnnotation]
+	return ok
+}
+
+// pauseDeployment scales the Deployment name to zero, keeping its replica
+// count and its autoscaler in annotations. The autoscaler is deleted, as it
+// cannot scale to zero; it is returned, or nil when there is none.
+func pauseDeployment(ctx context.Context, cs kubernetes.Interface, namespace, name string) (*appsv1.Deployment, *autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpa, err := autoscalerFor(ctx, cs, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var saved []byte
+	if hpa != nil {
+		saved, err = json.Marshal(pausedAutoscaler{Name: hpa.Name, Labels: hpa.Labels, Annotations: hpa.Annotations, Spec: hpa.Spec})
+		if err != nil {
+			return nil, nil, fmt.Errorf("encode horizontalpodautoscaler %s: %w", hpa.Name, err)
+		}
+	}
+
+	var dep *appsv1.Deployment
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if paused(live) {
+			return errPaused
+		}
+		replicas := int32(1)
+		if live.Spec.Replicas != nil {
+			replicas = *live.Spec.Replicas
+		}
+		live.Annotations = mergeLabels(live.Annotations, map[string]string{PausedReplicasAnnotation: strconv.Itoa(int(replicas))})
+		if saved != nil {
+			live.Annotations[PausedAutoscalerAnnotation] = string(saved)
+		}
+		live.Spec.Replicas = int32Ptr(0)
+		dep, err = cs.AppsV1().Deployments(namespace).Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The autoscaler leaves a Deployment scaled to zero alone, so deleting it
+	// once the Deployment is paused loses nothing if it fails
+	if hpa != nil {
+		err := cs.AutoscalingV2().HorizontalPodAutoscalers(namespace).Delete(ctx, hpa.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, nil, fmt.Errorf("delete horizontalpodautoscaler %s: %w", hpa.Name, err)
+		}
+	}
+	return dep, hpa, nil
+}
+
+// resumeDeployment restores the replica count and the autoscaler the
+// Deployment name had when it was paused. The autoscaler is returned, or nil
+// when there was none.
+func resumeDeployment(ctx context.Context, cs kubernetes.Interface, namespace, name string) (*appsv1.Deployment, *autoscalingv2.HorizontalPodAutoscaler, error) {
+	dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !paused(dep) {
+		return nil, nil, errNotPaused
+	}
+	replicas, err := strconv.ParseInt(dep.Annotations[PausedReplicasAnnotation], 10, 32)
+	if err != nil || replicas < 0 {
+		return nil, nil, fmt.Errorf("invalid %s annotation %q", PausedReplicasAnnotation, dep.Annotations[PausedReplicasAnnotation])
+	}
+
+	// The autoscaler goes first: it leaves the Deployment alone until it is
+	// scaled up, and the annotation it is kept in is only removed after
+	var hpa *autoscalingv2.HorizontalPodAutoscaler
+	if saved := dep.Annotations[PausedAutoscalerAnnotation]; saved != "" {
+		var p pausedAutoscaler
+		if err := json.Unmarshal([]byte(saved), &p); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s annotation: %w", PausedAutoscalerAnnotation, err)
+		}
+		hpas := cs.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+		want := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: p.Name, Namespace: namespace, Labels: p.Labels, Annotations: p.Annotations},
+			Spec:       p.Spec,
+		}
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := hpas.Get(ctx, p.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				hpa, err = hpas.Create(ctx, want, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.Labels, live.Annotations, live.Spec = p.Labels, p.Annotations, p.Spec
+			hpa, err = hpas.Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+			return err
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("restore horizontalpodautoscaler %s: %w", p.Name, err)
+		}
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !paused(live) {
+			return errNotPaused
+		}
+		delete(live.Annotations, PausedReplicasAnnotation)
+		delete(live.Annotations, PausedAutoscalerAnnotation)
+		live.Spec.Replicas = int32Ptr(int32(replicas))
+		dep, err = cs.AppsV1().Deployments(namespace).Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return dep, hpa, nil
+}
+
+// pauseResult is the response of the pause and resume endpoints.
+type pauseResult struct {
+	Namespace  string             `json:"namespace"`
+	Name       string             `json:"name"`
+	Replicas   int32              `json:"replicas"`             // Before pausing, or restored.
+	Autoscaler string             `json:"autoscaler,omitempty"` // Deleted, or restored.
+	Deployment *appsv1.Deployment `json:"deployment"`
+}
+
+// handleDeploymentPause scales a Deployment to zero until it is resumed,
+// deleting its autoscaler meanwhile. An app cannot be paused during a
+// release.
+func (h *Handler) handleDeploymentPause() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, deploymentName)
+		inProgress, err := releaseInProgress(r.Context(), cluster, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get release: %v", err), kubeErrorStatus(err))
+			return
+		}
+		if inProgress {
+			http.Error(w, fmt.Sprintf("a release of app %s is in progress; promote or abort it first", app), http.StatusConflict)
+			return
+		}
+		auditObjects(r.Context(), deploymentName)
+
+		dep, hpa, err := pauseDeployment(r.Context(), cluster.Clientset, namespace, deploymentName)
+		switch {
+		case errors.Is(err, errPaused):
+			http.Error(w, fmt.Sprintf("deployment %s is already paused", deploymentName), http.StatusConflict)
+			return
+		case err != nil:
+			h.requestLogger(r).ErrorCtx(r.Context(), "deployment pause failed",
+				"namespace", namespace, "deployment", deploymentName, "err", err)
+			http.Error(w, fmt.Sprintf("failed to pause deployment: %v", err), kubeErrorStatus(err))
+			return
+		}
+
+		result := pauseResult{Namespace: namespace, Name: deploymentName, Deployment: dep}
+		replicas, _ := strconv.ParseInt(dep.Annotations[PausedReplicasAnnotation], 10, 32)
+		result.Replicas = int32(replicas)
+		if hpa != nil {
+			result.Autoscaler = hpa.Name
+		}
+		h.requestLogger(r).InfoCtx(r.Context(), "deployment paused", "cluster", cluster.Name,
+			"namespace", namespace, "deployment", deploymentName, "replicas", result.Replicas)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, deploymentName, wait, result)
+	}
+}
+
+// handleDeploymentResume scales a paused Deployment back to the replica
+// count it had, and restores its autoscaler.
+func (h *Handler) handleDeploymentResume() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		auditObjects(r.Context(), deploymentName)
+
+		dep, hpa, err := resumeDeployment(r.Context(), cluster.Clientset, namespace, deploymentName)
+		switch {
+		case errors.Is(err, errNotPaused):
+			http.Error(w, fmt.Sprintf("deployment %s is not paused", deploymentName), http.StatusConflict)
+			return
+		case err != nil:
+			h.requestLogger(r).ErrorCtx(r.Context(), "deployment resume failed",
+				"namespace", namespace, "deployment", deploymentName, "err", err)
+			http.Error(w, fmt.Sprintf("failed to resume deployment: %v", err), kubeErrorStatus(err))
+			return
+		}
+
+		result := pauseResult{Namespace: namespace, Name: deploymentName, Replicas: *dep.Spec.Replicas, Deployment: dep}
+		if hpa != nil {
+			result.Autoscaler = hpa.Name
+		}
+		h.requestLogger(r).InfoCtx(r.Context(), "deployment resumed", "cluster", cluster.Name,
+			"namespace", namespace, "deployment", deploymentName, "replicas", result.Replicas)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, deploymentName, wait, result)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPauseResume(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Replicas = 5
+	in.Autoscaling = &AutoscalingRequest{MinReplicas: 2, MaxReplicas: 8, TargetCPUUtilization: int32Ptr(70)}
+	hpa := appAutoscaler(in)
+	s := newTestServer(t, appDeployment(in), hpa)
+	s.handle("POST /deployments/{namespace}/{deploymentName}/pause", s.h.handleDeploymentPause())
+	s.handle("POST /deployments/{namespace}/{deploymentName}/resume", s.h.handleDeploymentResume())
+	s.handle("PUT /deployments/{namespace}/{deploymentName}", s.h.handleDeploymentUpdate())
+	clientset := s.clientset
+
+	rec := s.serve(http.MethodPost, "/deployments/apps/web-deployment/pause", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pause: %d %s", rec.Code, rec.Body.String())
+	}
+	var got pauseResult
+	_ = json.NewDecoder(rec.Body).Decode(&got)
+	if got.Replicas != 5 || got.Autoscaler != "web-deployment" || *got.Deployment.Spec.Replicas != 0 {
+		t.Errorf("expected 5 replicas and the autoscaler to be paused, got %+v", got)
+	}
+	if _, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("apps").Get(t.Context(), hpa.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the autoscaler to be deleted, got %v", err)
+	}
+
+	// A paused deployment is only scaled by resuming it
+	if rec := s.serve(http.MethodPost, "/deployments/apps/web-deployment/pause", ""); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for pausing twice, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := s.serve(http.MethodPut, "/deployments/apps/web-deployment", `{"replicas":2}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for scaling a paused deployment, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = s.serve(http.MethodPost, "/deployments/apps/web-deployment/resume", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("resume: %d %s", rec.Code, rec.Body.String())
+	}
+	dep, _ := clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+	if *dep.Spec.Replicas != 5 || paused(dep) {
+		t.Errorf("expected 5 replicas and no pause annotations, got %d replicas, %v", *dep.Spec.Replicas, dep.Annotations)
+	}
+	restored, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("apps").Get(t.Context(), hpa.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the autoscaler to be restored: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Spec, hpa.Spec) || !reflect.DeepEqual(restored.Labels, hpa.Labels) {
+		t.Errorf("expected the autoscaler to be restored as it was, got %+v", restored)
+	}
+
+	if rec := s.serve(http.MethodPost, "/deployments/apps/web-deployment/resume", ""); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for resuming a running deployment, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := s.serve(http.MethodPost, "/deployments/apps/api-deployment/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing deployment, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPauseWithoutAutoscaler(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Replicas = 3
+	clientset := fake.NewClientset(appDeployment(in))
+
+	dep, hpa, err := pauseDeployment(t.Context(), clientset, "apps", "web-deployment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hpa != nil || *dep.Spec.Replicas != 0 || dep.Annotations[PausedReplicasAnnotation] != "3" {
+		t.Errorf("unexpected pause: %+v %v", hpa, dep.Annotations)
+	}
+	if _, ok := dep.Annotations[PausedAutoscalerAnnotation]; ok {
+		t.Errorf("expected no autoscaler to be kept, got %v", dep.Annotations)
+	}
+	if dep, hpa, err = resumeDeployment(t.Context(), clientset, "apps", "web-deployment"); err != nil || hpa != nil || *dep.Spec.Replicas != 3 {
+		t.Errorf("expected 3 replicas without autoscaler, got %v %+v (%v)", dep.Spec.Replicas, hpa, err)
+	}
+}
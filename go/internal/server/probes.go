@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ProbeRequest is a readiness, liveness or startup probe of the app
+// container. Exactly one of HTTPGet, TCPSocket and Exec must be set; zero
+// timings keep the Kubernetes defaults.
+type ProbeRequest struct {
+	HTTPGet   *HTTPGetProbe   `json:"httpGet,omitempty"`
+	TCPSocket *TCPSocketProbe `json:"tcpSocket,omitempty"`
+	Exec      *ExecProbe      `json:"exec,omitempty"`
+
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int32 `json:"periodSeconds,omitempty"`
+	TimeoutSeconds      int32 `json:"timeoutSeconds,omitempty"`
+	SuccessThreshold    int32 `json:"successThreshold,omitempty"`
+	FailureThreshold    int32 `json:"failureThreshold,omitempty"`
+}
+
+// HTTPGetProbe succeeds when a GET of Path answers with a 2xx or 3xx status.
+type HTTPGetProbe struct {
+	Path   string `json:"path,omitempty"`
+	Port   int32  `json:"port,omitempty"`   // 0 probes the first TCP port.
+	Scheme string `json:"scheme,omitempty"` // HTTP (default) or HTTPS.
+}
+
+// TCPSocketProbe succeeds when a TCP connection to Port can be opened.
+type TCPSocketProbe struct {
+	Port int32 `json:"port,omitempty"` // 0 probes the first TCP port.
+}
+
+// ExecProbe succeeds when Command exits with status 0 inside the container.
+type ExecProbe struct {
+	Command []string `json:"command"`
+}
+
+// probe returns p as a Kubernetes probe, probing defaultPort when p names no
+// port. p must have been validated.
+func (p *ProbeRequest) probe(defaultPort int32) *corev1.Probe {
+	if p == nil {
+		return nil
+	}
+	port := func(port int32) intstr.IntOrString {
+		if port == 0 {
+			port = defaultPort
+		}
+		return intstr.FromInt32(port)
+	}
+	out := &corev1.Probe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	switch {
+	case p.HTTPGet != nil:
+		path := p.HTTPGet.Path
+		if path == "" {
+			path = "/"
+		}
+		out.HTTPGet = &corev1.HTTPGetAction{
+			Path:   path,
+			Port:   port(p.HTTPGet.Port),
+			Scheme: corev1.URIScheme(strings.ToUpper(p.HTTPGet.Scheme)),
+		}
+	case p.TCPSocket != nil:
+		out.TCPSocket = &corev1.TCPSocketAction{Port: port(p.TCPSocket.Port)}
+	default:
+		out.Exec = &corev1.ExecAction{Command: p.Exec.Command}
+	}
+	return out
+}
+
+// probePort returns the port probes without a port of their own probe: the
+// first TCP port of ports, or 0 when there is none. A UDP or SCTP port would
+// never accept the connection.
+func probePort(ports []PortRequest) int32 {
+	for _, p := range ports {
+		if p.protocol() == corev1.ProtocolTCP {
+			return p.ContainerPort
+		}
+	}
+	return 0
+}
+
+// appProbes returns the readiness, liveness and startup probes of the app
+// container. Without a readiness probe the app is considered ready once its
+// first TCP port accepts connections, so pods that never start listening are
+// kept out of the Service. An app without TCP port has no default probe.
+func appProbes(in DeploymentRequest) (readiness, liveness, startup *corev1.Probe) {
+	port := probePort(in.Ports)
+	readiness = in.ReadinessProbe.probe(port)
+	if readiness == nil && port != 0 {
+		readiness = &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(port)},
+		}}
+	}
+	return readiness, in.LivenessProbe.probe(port), in.StartupProbe.probe(port)
+}
+
+// validateProbe checks the probe at field. Liveness and startup probes must
+// succeed once to count, so their success threshold can only be 1. An HTTP
+// or TCP probe needs a port of its own when defaultPort, the probePort of
+// the app, is 0.
+func validateProbe(field string, p *ProbeRequest, singleSuccess bool, defaultPort int32) []error {
+	if p == nil {
+		return nil
+	}
+	var errs []error
+	handlers := 0
+	for _, set := range []bool{p.HTTPGet != nil, p.TCPSocket != nil, p.Exec != nil} {
+		if set {
+			handlers++
+		}
+	}
+	if handlers != 1 {
+		errs = append(errs, fmt.Errorf("%s: exactly one of httpGet, tcpSocket and exec must be set", field))
+	}
+	validPort := func(f string, port int32) {
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be between 1 and 65535", f))
+		}
+	}
+	if p.HTTPGet != nil {
+		if p.HTTPGet.Path != "" && !strings.HasPrefix(p.HTTPGet.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s.httpGet.path must start with /", field))
+		}
+		if s := strings.ToUpper(p.HTTPGet.Scheme); s != "" && s != "HTTP" && s != "HTTPS" {
+			errs = append(errs, fmt.Errorf("%s.httpGet.scheme must be HTTP or HTTPS", field))
+		}
+		validPort(field+".httpGet.port", p.HTTPGet.Port)
+		if p.HTTPGet.Port == 0 && defaultPort == 0 {
+			errs = append(errs, fmt.Errorf("%s.httpGet.port is required when the app has no TCP port", field))
+		}
+	}
+	if p.TCPSocket != nil {
+		validPort(field+".tcpSocket.port", p.TCPSocket.Port)
+		if p.TCPSocket.Port == 0 && defaultPort == 0 {
+			errs = append(errs, fmt.Errorf("%s.tcpSocket.port is required when the app has no TCP port", field))
+		}
+	}
+	if p.Exec != nil && len(p.Exec.Command) == 0 {
+		errs = append(errs, fmt.Errorf("%s.exec.command is required", field))
+	}
+	for _, t := range []struct {
+		field string
+		value int32
+	}{
+		{"initialDelaySeconds", p.InitialDelaySeconds},
+		{"periodSeconds", p.PeriodSeconds},
+		{"timeoutSeconds", p.TimeoutSeconds},
+		{"successThreshold", p.SuccessThreshold},
+		{"failureThreshold", p.FailureThreshold},
+	} {
+		if t.value < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s must not be negative", field, t.field))
+		}
+	}
+	if singleSuccess && p.SuccessThreshold > 1 {
+		errs = append(errs, fmt.Errorf("%s.successThreshold must be 1", field))
+	}
+	return errs
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAppProbes(t *testing.T) {
+	t.Run("Defaults to a TCP readiness probe on the first port", func(t *testing.T) {
+		req := testDeploymentRequest()
+		req.Ports = append(req.Ports, PortRequest{ContainerPort: 9090})
+
+		c := appDeployment(req).Spec.Template.Spec.Containers[0]
+		if c.ReadinessProbe == nil || c.ReadinessProbe.TCPSocket == nil || c.ReadinessProbe.TCPSocket.Port.IntVal != 8080 {
+			t.Errorf("expected a TCP readiness probe on 8080, got %+v", c.ReadinessProbe)
+		}
+		if c.LivenessProbe != nil || c.StartupProbe != nil {
+			t.Errorf("expected no liveness or startup probe, got %+v, %+v", c.LivenessProbe, c.StartupProbe)
+		}
+	})
+
+	t.Run("Probes the first TCP port", func(t *testing.T) {
+		req := testDeploymentRequest()
+		req.Ports = []PortRequest{{ContainerPort: 5353, Protocol: corev1.ProtocolUDP}, {ContainerPort: 8080}}
+		req.LivenessProbe = &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "/healthz"}}
+
+		c := appDeployment(req).Spec.Template.Spec.Containers[0]
+		if c.ReadinessProbe == nil || c.ReadinessProbe.TCPSocket == nil || c.ReadinessProbe.TCPSocket.Port.IntVal != 8080 {
+			t.Errorf("expected a TCP readiness probe on 8080, got %+v", c.ReadinessProbe)
+		}
+		if c.LivenessProbe.HTTPGet.Port.IntVal != 8080 {
+			t.Errorf("expected the liveness probe on 8080, got %+v", c.LivenessProbe.HTTPGet)
+		}
+
+		// A UDP-only app has nothing to probe by default
+		req.Ports, req.LivenessProbe = req.Ports[:1], nil
+		if c := appDeployment(req).Spec.Template.Spec.Containers[0]; c.ReadinessProbe != nil {
+			t.Errorf("expected no readiness probe, got %+v", c.ReadinessProbe)
+		}
+	})
+
+	t.Run("Renders the requested probes", func(t *testing.T) {
+		req := testDeploymentRequest()
+		req.ReadinessProbe = &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "/healthz", Scheme: "https"}, PeriodSeconds: 5}
+		req.LivenessProbe = &ProbeRequest{Exec: &ExecProbe{Command: []string{"cat", "/tmp/alive"}}}
+		req.StartupProbe = &ProbeRequest{TCPSocket: &TCPSocketProbe{Port: 9000}, PeriodSeconds: 10, FailureThreshold: 60}
+
+		c := appDeployment(req).Spec.Template.Spec.Containers[0]
+		if get := c.ReadinessProbe.HTTPGet; get == nil || get.Path != "/healthz" || get.Port.IntVal != 8080 ||
+			get.Scheme != corev1.URISchemeHTTPS || c.ReadinessProbe.PeriodSeconds != 5 {
+			t.Errorf("unexpected readiness probe: %+v", c.ReadinessProbe)
+		}
+		if c.LivenessProbe.Exec == nil || c.LivenessProbe.Exec.Command[1] != "/tmp/alive" {
+			t.Errorf("unexpected liveness probe: %+v", c.LivenessProbe)
+		}
+		if c.StartupProbe.TCPSocket.Port.IntVal != 9000 || c.StartupProbe.FailureThreshold != 60 {
+			t.Errorf("unexpected startup probe: %+v", c.StartupProbe)
+		}
+	})
+}
+
+func TestValidateProbe(t *testing.T) {
+	tests := []struct {
+		name          string
+		probe         *ProbeRequest
+		singleSuccess bool
+		noTCPPort     bool
+		errs          []string
+	}{
+		{name: "Unset", probe: nil},
+		{name: "HTTP", probe: &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "/ready", Port: 8080, Scheme: "HTTP"}}},
+		{
+			name:  "No handler",
+			probe: &ProbeRequest{PeriodSeconds: 5},
+			errs:  []string{"probe: exactly one of httpGet, tcpSocket and exec must be set"},
+		},
+		{
+			name:  "Two handlers",
+			probe: &ProbeRequest{TCPSocket: &TCPSocketProbe{}, Exec: &ExecProbe{}},
+			errs:  []string{"probe: exactly one of", "probe.exec.command is required"},
+		},
+		{
+			name:  "Invalid HTTP probe",
+			probe: &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "ready", Port: 70000, Scheme: "ftp"}},
+			errs: []string{
+				"probe.httpGet.path must start with /",
+				"probe.httpGet.scheme must be HTTP or HTTPS",
+				"probe.httpGet.port must be between 1 and 65535",
+			},
+		},
+		{
+			name:  "Negative timings",
+			probe: &ProbeRequest{TCPSocket: &TCPSocketProbe{}, InitialDelaySeconds: -1, FailureThreshold: -3},
+			errs:  []string{"probe.initialDelaySeconds must not be negative", "probe.failureThreshold must not be negative"},
+		},
+		{
+			name:          "Success threshold of a liveness probe",
+			probe:         &ProbeRequest{TCPSocket: &TCPSocketProbe{}, SuccessThreshold: 2},
+			singleSuccess: true,
+			errs:          []string{"probe.successThreshold must be 1"},
+		},
+		{
+			name:      "Port-less TCP probe without TCP port",
+			probe:     &ProbeRequest{TCPSocket: &TCPSocketProbe{}},
+			noTCPPort: true,
+			errs:      []string{"probe.tcpSocket.port is required when the app has no TCP port"},
+		},
+		{
+			name:      "Port-less HTTP probe without TCP port",
+			probe:     &ProbeRequest{HTTPGet: &HTTPGetProbe{Path: "/ready"}},
+			noTCPPort: true,
+			errs:      []string{"probe.httpGet.port is required when the app has no TCP port"},
+		},
+		{name: "Exec probe without TCP port", probe: &ProbeRequest{Exec: &ExecProbe{Command: []string{"true"}}}, noTCPPort: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultPort := int32(8080)
+			if tt.noTCPPort {
+				defaultPort = 0
+			}
+			errs := validateProbe("probe", tt.probe, tt.singleSuccess, defaultPort)
+			if len(tt.errs) == 0 && len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(strings.Join(got, "\n"), want) {
+					t.Errorf("expected %q in %q", want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,763 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Release strategies.
+const (
+	// ReleaseCanary shifts any percentage of the traffic to the next version.
+	ReleaseCanary = "canary"
+	// ReleaseBlueGreen switches all the traffic from one version to the other.
+	ReleaseBlueGreen = "blue-green"
+)
+
+const (
+	// ReleaseStrategyAnnotation holds the strategy of a release on the
+	// Deployment running its next version.
+	ReleaseStrategyAnnotation = "aico.clappform.com/release-strategy"
+	// ReleaseWeightAnnotation holds the percentage of the traffic routed to
+	// the next version, on the same Deployment.
+	ReleaseWeightAnnotation = "aico.clappform.com/release-weight"
+)
+
+// releaseRoutePriority ranks the routes of a release above the Ingress
+// routes of the app, which Traefik ranks by the length of their rule.
+const releaseRoutePriority = 100000
+
+// ReleaseRequest is the body of POST /deployments/{namespace}/{name}/release.
+type ReleaseRequest struct {
+	Strategy string `json:"strategy"`
+	// Weight is the percentage of the traffic the next version starts with.
+	// A blue-green release starts at 0 and can only switch to 100.
+	Weight int32 `json:"weight"`
+	// Replicas of the next version. A canary defaults to 1 replica and a
+	// blue-green release to as many as the app runs.
+	Replicas int32 `json:"replicas"`
+	// Changes are the DeploymentRequest fields the next version changes, as
+	// in PATCH /deployments/{namespace}/{name}.
+	Changes json.RawMessage `json:"changes"`
+}
+
+// validateReleaseWeight checks that weight is a percentage strategy can route.
+func validateReleaseWeight(strategy string, weight int32) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("weight must be between 0 and 100, got %d", weight)
+	}
+	if strategy == ReleaseBlueGreen && weight != 0 && weight != 100 {
+		return fmt.Errorf("a blue-green release routes all the traffic to one version: weight must be 0 or 100, got %d", weight)
+	}
+	return nil
+}
+
+// releasePodLabels labels the pods of the next version apart from the app's,
+// so that only the release Service selects them.
+func releasePodLabels(app string) map[string]string {
+	return map[string]string{"app": app + "-next"}
+}
+
+// releaseDeployment renders the Deployment running the next version of the
+// app described by in, with replicas pods.
+func releaseDeployment(in DeploymentRequest, strategy string, weight, replicas int32) *appsv1.Deployment {
+	dep := appDeployment(in)
+	dep.Name = appNamesFor(in.DeploymentName).Release
+	dep.Annotations = map[string]string{
+		ReleaseStrategyAnnotation: strategy,
+		ReleaseWeightAnnotation:   strconv.Itoa(int(weight)),
+	}
+	dep.Spec.Replicas = int32Ptr(replicas)
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: releasePodLabels(in.DeploymentName)}
+	dep.Spec.Template.Labels = releasePodLabels(in.DeploymentName)
+	for i := range dep.Spec.Template.Spec.TopologySpreadConstraints {
+		dep.Spec.Template.Spec.TopologySpreadConstraints[i].LabelSelector = &metav1.LabelSelector{MatchLabels: releasePodLabels(in.DeploymentName)}
+	}
+	return dep
+}
+
+// releaseService renders the Service in front of the next version.
+func releaseService(in DeploymentRequest) *corev1.Service {
+	svc := appService(in)
+	svc.Name = appNamesFor(in.DeploymentName).ReleaseService
+	svc.Spec.Selector = releasePodLabels(in.DeploymentName)
+	return svc
+}
+
+// releaseSplitName names the TraefikService splitting the traffic of port p.
+func releaseSplitName(app string, p appPort) string {
+	return appNamesFor(app).Service + "-" + p.Name
+}
+
+// releaseSplits renders a weighted round-robin TraefikService per public
+// port of the app, sending weight percent of the port's traffic to the
+// release Service and the rest to the app's.
+func releaseSplits(in DeploymentRequest, weight int32) []*unstructured.Unstructured {
+	names := appNamesFor(in.DeploymentName)
+	var splits []*unstructured.Unstructured
+	for _, p := range appPorts(in) {
+		if !p.Public {
+			continue
+		}
+		split := &unstructured.Unstructured{
+			Object: map[string]any{
+				"apiVersion": "traefik.io/v1alpha1",
+				"kind":       "TraefikService",
+				"metadata":   map[string]any{"name": releaseSplitName(in.DeploymentName, p), "namespace": in.Namespace},
+				"spec": map[string]any{
+					"weighted": map[string]any{
+						"services": []any{
+							map[string]any{"name": names.Service, "port": int64(p.Number), "weight": int64(100 - weight)},
+							map[string]any{"name": names.ReleaseService, "port": int64(p.Number), "weight": int64(weight)},
+						},
+					},
+				},
+			},
+		}
+		split.SetLabels(appLabels(in.DeploymentName))
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+// releaseRoute renders the IngressRoute sending the public ports of the app
+// through releaseSplits. It routes the hosts and paths of appIngress through
+// the same Middleware, and Traefik prefers it to the Ingress while it exists.
+func releaseRoute(in DeploymentRequest, domain DomainConfig) *unstructured.Unstructured {
+	names := appNamesFor(in.DeploymentName)
+	ports := appPorts(in)
+	var middlewares []any
+	if len(strippedPrefixes(ports)) > 0 {
+		middlewares = []any{map[string]any{"name": names.Middleware}}
+	}
+
+	var routes []any
+	for _, p := range ports {
+		if !p.Public {
+			continue
+		}
+		// An Ingress Prefix path matches whole path segments, which PathPrefix
+		// alone does not: /web would take the traffic of /web-api
+		host := cmp.Or(p.Host, domain.Domain)
+		match := fmt.Sprintf("Host(`%s`) && PathPrefix(`/`)", host)
+		if path := strings.TrimSuffix(p.Path, "/"); path != "" {
+			match = fmt.Sprintf("Host(`%s`) && (Path(`%s`) || PathPrefix(`%s/`))", host, path, path)
+		}
+		route := map[string]any{
+			"kind":     "Rule",
+			"match":    match,
+			"priority": int64(releaseRoutePriority + len(p.Path)),
+			"services": []any{map[string]any{"kind": "TraefikService", "name": releaseSplitName(in.DeploymentName, p)}},
+		}
+		if middlewares != nil {
+			route["middlewares"] = middlewares
+		}
+		routes = append(routes, route)
+	}
+
+	route := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "traefik.io/v1alpha1",
+			"kind":       "IngressRoute",
+			"metadata":   map[string]any{"name": names.ReleaseRoute, "namespace": in.Namespace},
+			"spec": map[string]any{
+				"entryPoints": []any{"websecure"},
+				"routes":      routes,
+				"tls":         map[string]any{"secretName": TLSSecretName},
+			},
+		},
+	}
+	route.SetLabels(appLabels(in.DeploymentName))
+	return route
+}
+
+// errReleaseInProgress is returned by startRelease when the app is already
+// being released.
+var errReleaseInProgress = errors.New("a release is already in progress")
+
+// startRelease creates the objects of a release of the app described by
+// current, running next. annotations are set on the release Deployment. The
+// objects it created are deleted again when one of them cannot be. It
+// returns errReleaseInProgress when the release Deployment already exists.
+func startRelease(ctx context.Context, cluster *Cluster, current, next DeploymentRequest, domain DomainConfig, strategy string, weight, replicas int32, annotations map[string]string) (*appsv1.Deployment, error) {
+	ns := current.Namespace
+	dep := releaseDeployment(next, strategy, weight, replicas)
+	dep.Annotations = mergeLabels(dep.Annotations, annotations)
+
+	// Only what this call created is deleted on failure: a concurrent start
+	// may have created the rest
+	background := metav1.DeletePropagationBackground
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: &background}
+	var undo []func()
+	created, err := func() (*appsv1.Deployment, error) {
+		deployments := cluster.Clientset.AppsV1().Deployments(ns)
+		created, err := deployments.Create(ctx, dep, createOptions(false))
+		if apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("%w: deployment %s exists", errReleaseInProgress, dep.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create deployment %s: %w", dep.Name, err)
+		}
+		undo = append(undo, func() { _ = deployments.Delete(ctx, dep.Name, deleteOpts) })
+		svc := releaseService(next)
+		services := cluster.Clientset.CoreV1().Services(ns)
+		if _, err := services.Create(ctx, svc, createOptions(false)); err != nil {
+			return nil, fmt.Errorf("create service %s: %w", svc.Name, err)
+		}
+		undo = append(undo, func() { _ = services.Delete(ctx, svc.Name, deleteOpts) })
+		// The traffic is split by the live app's ports, which a release does
+		// not change
+		splits := cluster.Dynamic.Resource(traefikServiceGVR).Namespace(ns)
+		for _, split := range releaseSplits(current, weight) {
+			if _, err := splits.Create(ctx, split, createOptions(false)); err != nil {
+				return nil, fmt.Errorf("create traefikservice %s: %w", split.GetName(), err)
+			}
+			undo = append(undo, func() { _ = splits.Delete(ctx, split.GetName(), deleteOpts) })
+		}
+		route := releaseRoute(current, domain)
+		if _, err := cluster.Dynamic.Resource(ingressRouteGVR).Namespace(ns).Create(ctx, route, createOptions(false)); err != nil {
+			return nil, fmt.Errorf("create ingressroute %s: %w", route.GetName(), err)
+		}
+		return created, nil
+	}()
+	if err != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return nil, err
+	}
+	return created, nil
+}
+
+// restoreStable puts the pod template of stable, the app's Deployment as it
+// ran before a promotion, back on it. The deployment controller rolls it out
+// as a new revision. The replica count, which an autoscaler may have changed
+// since, is left alone.
+func restoreStable(ctx context.Context, cs kubernetes.Interface, stable *appsv1.Deployment, annotations map[string]string) error {
+	deployments := cs.AppsV1().Deployments(stable.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := deployments.Get(ctx, stable.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		live.Spec.Template = *stable.Spec.Template.DeepCopy()
+		live.Annotations = mergeLabels(live.Annotations, annotations)
+		_, err = deployments.Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+}
+
+// setReleaseWeight routes weight percent of the traffic of the app described
+// by current to the next version of its release.
+func setReleaseWeight(ctx context.Context, cluster *Cluster, current DeploymentRequest, weight int32) (*appsv1.Deployment, error) {
+	ns := current.Namespace
+	splits := cluster.Dynamic.Resource(traefikServiceGVR).Namespace(ns)
+	for _, want := range releaseSplits(current, weight) {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			live, err := splits.Get(ctx, want.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				_, err = splits.Create(ctx, want, createOptions(false))
+				return err
+			}
+			if err != nil {
+				return err
+			}
+			live.Object["spec"] = want.Object["spec"]
+			_, err = splits.Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("update traefikservice %s: %w", want.GetName(), err)
+		}
+	}
+
+	name := appNamesFor(current.DeploymentName).Release
+	var dep *appsv1.Deployment
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live, err := cluster.Clientset.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		live.Annotations = mergeLabels(live.Annotations, map[string]string{ReleaseWeightAnnotation: strconv.Itoa(int(weight))})
+		dep, err = cluster.Clientset.AppsV1().Deployments(ns).Update(ctx, live, metav1.UpdateOptions{FieldManager: FieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update deployment %s: %w", name, err)
+	}
+	return dep, nil
+}
+
+// deleteRelease deletes the release of app: its IngressRoute first, so that
+// the Ingress of the app takes all the traffic back, then the TraefikServices
+// and the next version. Deletion carries on past errors, which are joined in
+// the returned error.
+func deleteRelease(ctx context.Context, cluster *Cluster, namespace, app string) ([]appObjectRef, error) {
+	names := appNamesFor(app)
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+	deleted := []appObjectRef{}
+	var errs []error
+	remove := func(kind, name string, del func(string) error) {
+		err := del(name)
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			errs = append(errs, fmt.Errorf("delete %s %s: %w", kind, name, err))
+		default:
+			deleted = append(deleted, appObjectRef{Kind: kind, Name: name})
+		}
+	}
+
+	routes := cluster.Dynamic.Resource(ingressRouteGVR).Namespace(namespace)
+	remove("IngressRoute", names.ReleaseRoute, func(name string) error { return routes.Delete(ctx, name, opts) })
+	splits := cluster.Dynamic.Resource(traefikServiceGVR).Namespace(namespace)
+	list, err := splits.List(ctx, metav1.ListOptions{LabelSelector: appSelector(app)})
+	switch {
+	case err == nil:
+		for _, split := range list.Items {
+			remove("TraefikService", split.GetName(), func(name string) error { return splits.Delete(ctx, name, opts) })
+		}
+	case !apierrors.IsNotFound(err):
+		errs = append(errs, fmt.Errorf("list TraefikService objects: %w", err))
+	}
+	remove("Service", names.ReleaseService, func(name string) error {
+		return cluster.Clientset.CoreV1().Services(namespace).Delete(ctx, name, opts)
+	})
+	remove("Deployment", names.Release, func(name string) error {
+		return cluster.Clientset.AppsV1().Deployments(namespace).Delete(ctx, name, opts)
+	})
+	return deleted, errors.Join(errs...)
+}
+
+// releaseInProgress reports whether app is being released, in which case
+// changing its ports or routing would break the traffic split.
+func releaseInProgress(ctx context.Context, cluster *Cluster, namespace, app string) (bool, error) {
+	_, err := cluster.Clientset.AppsV1().Deployments(namespace).Get(ctx, appNamesFor(app).Release, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// mainImage returns the image of the main container of app in dep.
+func mainImage(app string, dep *appsv1.Deployment) string {
+	for _, c := range dep.Spec.Template.Spec.Containers {
+		if c.Name == mainContainerName(app) {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// releaseStatus describes a release in progress.
+type releaseStatus struct {
+	Namespace   string `json:"namespace"`
+	App         string `json:"app"`
+	Strategy    string `json:"strategy"`
+	Weight      int32  `json:"weight"`
+	StableImage string `json:"stableImage"`
+	Image       string `json:"image"`
+	// Rollout is the rollout status of the next version.
+	Rollout rolloutStatus `json:"rollout"`
+}
+
+// releaseStatusOf returns the status of the release of app, from the
+// Deployments of its stable and next versions.
+func releaseStatusOf(app string, stable, next *appsv1.Deployment) releaseStatus {
+	weight, _ := strconv.ParseInt(next.Annotations[ReleaseWeightAnnotation], 10, 32)
+	return releaseStatus{
+		Namespace:   next.Namespace,
+		App:         app,
+		Strategy:    next.Annotations[ReleaseStrategyAnnotation],
+		Weight:      int32(weight),
+		StableImage: mainImage(app, stable),
+		Image:       mainImage(app, next),
+		Rollout:     rolloutStatusOf(next),
+	}
+}
+
+// liveRelease is an app and its release as they run.
+type liveRelease struct {
+	App string
+	// Stable is the app; its autoscaler and disruption budget are not the
+	// next version's, which runs a fixed replica count.
+	Stable liveApp
+	Next   *appsv1.Deployment
+}
+
+// getRelease looks up the release of the app name refers to, writing the
+// error response and returning nil when there is none.
+func getRelease(w http.ResponseWriter, r *http.Request, cluster *Cluster, namespace, name string) *liveRelease {
+	app := resolveAppName(r.Context(), cluster.Dynamic, namespace, name)
+	next, err := cluster.Clientset.AppsV1().Deployments(namespace).Get(r.Context(), appNamesFor(app).Release, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("no release of app %s in progress", app), http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), kubeErrorStatus(err))
+		return nil
+	}
+	stable, err := getLiveApp(r.Context(), cluster.Clientset, namespace, app)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to describe app: %v", err), kubeErrorStatus(err))
+		return nil
+	}
+	return &liveRelease{App: app, Stable: stable, Next: next}
+}
+
+// handleReleaseStart starts a canary or blue-green release of an app. The
+// next version, the live app with the requested changes, runs in a
+// Deployment and Service of its own, and the public traffic of the app is
+// split between both versions by weighted TraefikServices.
+func (h *Handler) handleReleaseStart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req ReleaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Strategy != ReleaseCanary && req.Strategy != ReleaseBlueGreen {
+			http.Error(w, fmt.Sprintf("strategy must be %s or %s", ReleaseCanary, ReleaseBlueGreen), http.StatusBadRequest)
+			return
+		}
+		if err := validateReleaseWeight(req.Strategy, req.Weight); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Strategy == ReleaseBlueGreen && req.Weight != 0 {
+			http.Error(w, "a blue-green release starts with weight 0", http.StatusBadRequest)
+			return
+		}
+		if req.Replicas < 0 {
+			http.Error(w, "replicas cannot be negative", http.StatusBadRequest)
+			return
+		}
+		if len(req.Changes) == 0 {
+			http.Error(w, "changes must describe the next version", http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain := h.domainFor(cluster)
+
+		// Describe the app as it runs now
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, deploymentName)
+		inProgress, err := releaseInProgress(r.Context(), cluster, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get release: %v", err), kubeErrorStatus(err))
+			return
+		}
+		if inProgress {
+			http.Error(w, fmt.Sprintf("a release of app %s is already in progress", app), http.StatusConflict)
+			return
+		}
+		stable, err := getLiveApp(r.Context(), cluster.Clientset, namespace, app)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to describe app: %v", err), kubeErrorStatus(err))
+			return
+		}
+		if paused(stable.Deployment) {
+			http.Error(w, fmt.Sprintf("deployment %s is paused; resume it first", stable.Deployment.Name), http.StatusConflict)
+			return
+		}
+		current := requestFromDeployment(app, stable, domain)
+		if !slices.ContainsFunc(appPorts(current), func(p appPort) bool { return p.Public }) {
+			http.Error(w, fmt.Sprintf("app %s has no public port to split the traffic of", app), http.StatusBadRequest)
+			return
+		}
+
+		// The next version is the live app with the changes
+		next, err := patchDeploymentRequest(current, req.Changes)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode changes: %v", err), http.StatusBadRequest)
+			return
+		}
+		if next.Namespace != namespace || next.DeploymentName != app {
+			http.Error(w, "namespace and deploymentName cannot be changed", http.StatusBadRequest)
+			return
+		}
+		if !slices.Equal(appPorts(next), appPorts(current)) {
+			http.Error(w, "a release cannot change the ports of an app; change them with PATCH first", http.StatusBadRequest)
+			return
+		}
+		if err := validateDeploymentRequestBody(next); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if next, err = withAvailabilityTier(r.Context(), cluster.Clientset, next); err != nil {
+			http.Error(w, fmt.Sprintf("failed to resolve availability tier: %v", err), kubeErrorStatus(err))
+			return
+		}
+		auditObjects(r.Context(), app)
+
+		// Referenced ConfigMaps and Secrets must exist before anything is created
+		if err := checkReferences(r.Context(), cluster.Clientset, namespace, appDeployment(next).Spec.Template.Spec); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrMissingReference) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		replicas := req.Replicas
+		if replicas == 0 {
+			replicas = 1
+			if req.Strategy == ReleaseBlueGreen {
+				replicas = current.Replicas
+			}
+		}
+		cause := fmt.Sprintf("started %s release of image %s", req.Strategy, next.Image)
+		dep, err := startRelease(r.Context(), cluster, current, next, domain, req.Strategy, req.Weight, replicas, changeAnnotations(r, cause))
+		if errors.Is(err, errReleaseInProgress) {
+			http.Error(w, fmt.Sprintf("a release of app %s is already in progress", app), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "release failed to start",
+				"namespace", namespace, "app", app, "err", err)
+			http.Error(w, err.Error(), kubeErrorStatus(err))
+			return
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "release started", "cluster", cluster.Name,
+			"namespace", namespace, "app", app, "strategy", req.Strategy, "weight", req.Weight)
+		h.respondAfterRollout(w, r, cluster.Clientset, namespace, dep.Name, wait, releaseStatusOf(app, stable.Deployment, dep))
+	}
+}
+
+// handleReleaseStatus reports the release of an app in progress.
+func (h *Handler) handleReleaseStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		release := getRelease(w, r, cluster, r.PathValue("namespace"), r.PathValue("deploymentName"))
+		if release == nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(releaseStatusOf(release.App, release.Stable.Deployment, release.Next))
+	}
+}
+
+// handleReleaseWeight changes the percentage of the traffic routed to the
+// next version of a release.
+func (h *Handler) handleReleaseWeight() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Weight *int32 `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if body.Weight == nil {
+			http.Error(w, "weight is required", http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain := h.domainFor(cluster)
+
+		release := getRelease(w, r, cluster, r.PathValue("namespace"), r.PathValue("deploymentName"))
+		if release == nil {
+			return
+		}
+		if err := validateReleaseWeight(release.Next.Annotations[ReleaseStrategyAnnotation], *body.Weight); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		auditObjects(r.Context(), release.App)
+
+		current := requestFromDeployment(release.App, release.Stable, domain)
+		next, err := setReleaseWeight(r.Context(), cluster, current, *body.Weight)
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "release weight update failed",
+				"namespace", current.Namespace, "app", release.App, "err", err)
+			http.Error(w, err.Error(), kubeErrorStatus(err))
+			return
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "release weight updated", "cluster", cluster.Name,
+			"namespace", current.Namespace, "app", release.App, "weight", *body.Weight)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(releaseStatusOf(release.App, release.Stable.Deployment, next))
+	}
+}
+
+// handleReleasePromote makes the next version of a release the app's. The
+// app's Deployment is updated to it, and once that rollout completes the
+// release is deleted, sending all the traffic back through the Ingress. The
+// wait is not optional; timeout bounds it as for ?wait=true. When the
+// rollout does not complete the app's Deployment is rolled back to the pod
+// template it had and the release is left in place.
+func (h *Handler) handleReleasePromote() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain := h.domainFor(cluster)
+
+		release := getRelease(w, r, cluster, r.PathValue("namespace"), r.PathValue("deploymentName"))
+		if release == nil {
+			return
+		}
+		app, namespace := release.App, release.Stable.Deployment.Namespace
+		auditObjects(r.Context(), app)
+
+		// The next version runs the app's containers under the app's name, and
+		// keeps its replica count, autoscaler and availability
+		next := release.Stable
+		next.Deployment = release.Next
+		in := requestFromDeployment(app, next, domain)
+		stable := requestFromDeployment(app, release.Stable, domain)
+		in.Replicas, in.Availability = stable.Replicas, stable.Availability
+		if in, err = withAvailabilityTier(r.Context(), cluster.Clientset, in); err != nil {
+			http.Error(w, fmt.Sprintf("failed to resolve availability tier: %v", err), kubeErrorStatus(err))
+			return
+		}
+		cause := fmt.Sprintf("promoted %s release of image %s", release.Next.Annotations[ReleaseStrategyAnnotation], in.Image)
+		dep, err := updateApp(r.Context(), cluster, in, domain, changeAnnotations(r, cause))
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "release promotion failed",
+				"namespace", namespace, "app", app, "err", err)
+			http.Error(w, err.Error(), kubeErrorStatus(err))
+			return
+		}
+		revision := nextRevision(release.Stable.Deployment, dep)
+
+		extendWriteDeadline(w, opts.Timeout)
+		status, err := waitForRollout(r.Context(), cluster.Clientset, namespace, dep.Name, opts.Timeout)
+		var failure string
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, errRolloutTimeout):
+			failure, code = fmt.Sprintf("%s after %s: %s", err, opts.Timeout, status.Message), http.StatusGatewayTimeout
+		case err != nil:
+			failure, code = fmt.Sprintf("failed to wait for the rollout: %v", err), kubeErrorStatus(err)
+		case status.Phase == RolloutFailed:
+			failure = fmt.Sprintf("rollout failed: %s", status.Message)
+		}
+		if failure != "" {
+			// The app goes back to the version it ran before, which abort
+			// leaves it at
+			rollback := fmt.Sprintf("rolled back failed promotion of image %s", in.Image)
+			if err := restoreStable(context.WithoutCancel(r.Context()), cluster.Clientset, release.Stable.Deployment, changeAnnotations(r, rollback)); err != nil {
+				h.requestLogger(r).ErrorCtx(r.Context(), "failed promotion rollback failed",
+					"namespace", namespace, "app", app, "err", err)
+				http.Error(w, fmt.Sprintf("%s; rolling the app back failed: %v; the release is left in place", failure, err), code)
+				return
+			}
+			h.requestLogger(r).WarnCtx(r.Context(), "release promotion rolled back",
+				"namespace", namespace, "app", app, "reason", failure)
+			http.Error(w, failure+"; the app was rolled back and the release is left in place", code)
+			return
+		}
+
+		deleted, err := deleteRelease(r.Context(), cluster, namespace, app)
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "release cleanup failed",
+				"namespace", namespace, "app", app, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "release promoted", "cluster", cluster.Name,
+			"namespace", namespace, "app", app, "revision", revision)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Namespace string         `json:"namespace"`
+			App       string         `json:"app"`
+			Revision  int64          `json:"revision"`
+			Rollout   rolloutStatus  `json:"rollout"`
+			Deleted   []appObjectRef `json:"deleted"`
+		}{namespace, app, revision, status, deleted})
+	}
+}
+
+// handleReleaseAbort deletes the release of an app, sending all the traffic
+// back to the app as it ran before the release.
+func (h *Handler) handleReleaseAbort() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, deploymentName)
+		auditObjects(r.Context(), app)
+		deleted, err := deleteRelease(r.Context(), cluster, namespace, app)
+		if err != nil {
+			h.requestLogger(r).ErrorCtx(r.Context(), "release abort failed",
+				"namespace", namespace, "app", app, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(deleted) == 0 {
+			http.Error(w, fmt.Sprintf("no release of app %s in progress", app), http.StatusNotFound)
+			return
+		}
+
+		h.requestLogger(r).InfoCtx(r.Context(), "release aborted", "cluster", cluster.Name,
+			"namespace", namespace, "app", app)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Namespace string         `json:"namespace"`
+			App       string         `json:"app"`
+			Deleted   []appObjectRef `json:"deleted"`
+		}{namespace, app, deleted})
+	}
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestReleaseRoute(t *testing.T) {
+	in := testDeploymentRequest()
+	in.Ports = []PortRequest{
+		{ContainerPort: 8080},
+		{Name: "metrics", ContainerPort: 9090, Expose: ExposePublic},
+		{ContainerPort: 9000, Expose: ExposePublic, Host: "grpc.example.com"},
+		{ContainerPort: 5353, Expose: ExposeInternal},
+	}
+	route := releaseRoute(in, DomainConfig{Domain: "apps.example.com"})
+
+	routes, _, _ := unstructured.NestedSlice(route.Object, "spec", "routes")
+	if len(routes) != 3 {
+		t.Fatalf("expected a route per public port, got %d", len(routes))
+	}
+	for i, want := range []struct {
+		match, split string
+		priority     int64
+	}{
+		{"Host(`apps.example.com`) && (Path(`/web-deployment`) || PathPrefix(`/web-deployment/`))", "web-deployment-service-tcp-8080", releaseRoutePriority + 15},
+		{"Host(`apps.example.com`) && (Path(`/web-deployment/metrics`) || PathPrefix(`/web-deployment/metrics/`))", "web-deployment-service-metrics", releaseRoutePriority + 23},
+		{"Host(`grpc.example.com`) && PathPrefix(`/`)", "web-deployment-service-tcp-9000", releaseRoutePriority + 1},
+	} {
+		got := routes[i].(map[string]any)
+		split := got["services"].([]any)[0].(map[string]any)["name"]
+		if got["match"] != want.match || got["priority"] != want.priority || split != want.split {
+			t.Errorf("route %d: expected %s (%d) to %s, got %v", i, want.match, want.priority, want.split, got)
+		}
+		if _, ok := got["middlewares"]; !ok {
+			t.Errorf("route %d: expected the app's middleware", i)
+		}
+	}
+
+	splits := releaseSplits(in, 25)
+	if len(splits) != 3 {
+		t.Fatalf("expected a TraefikService per public port, got %d", len(splits))
+	}
+	services, _, _ := unstructured.NestedSlice(splits[1].Object, "spec", "weighted", "services")
+	stable, next := services[0].(map[string]any), services[1].(map[string]any)
+	if stable["name"] != "web-deployment-service" || stable["weight"] != int64(75) || stable["port"] != int64(9090) {
+		t.Errorf("unexpected stable service: %v", stable)
+	}
+	if next["name"] != "web-next-deployment-service" || next["weight"] != int64(25) {
+		t.Errorf("unexpected next service: %v", next)
+	}
+}
+
+func TestReleaseHandlers(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	newServer := func(t *testing.T) *testServer {
+		t.Helper()
+		s := newTestServer(t)
+		s.handle("POST /deployments", s.h.handleDeploymentCreation())
+		s.handle("GET /deployments/{namespace}/{deploymentName}/release", s.h.handleReleaseStatus())
+		s.handle("POST /deployments/{namespace}/{deploymentName}/release", s.h.handleReleaseStart())
+		s.handle("PUT /deployments/{namespace}/{deploymentName}/release/weight", s.h.handleReleaseWeight())
+		s.handle("POST /deployments/{namespace}/{deploymentName}/release/promote", s.h.handleReleasePromote())
+		s.handle("POST /deployments/{namespace}/{deploymentName}/release/abort", s.h.handleReleaseAbort())
+		s.handle("PATCH /deployments/{namespace}/{deploymentName}", s.h.handleDeploymentPatch())
+
+		// Create the app as POST /deployments does
+		body := `{"namespace":"apps","deploymentName":"web","image":"nginx:1.27","replicas":3,"ports":[{"containerPort":8080}],
+			"resources":{"cpuLimits":"1","cpuRequests":"100m","memoryLimits":"1Gi","memoryRequests":"256Mi"}}`
+		if rec := s.serve(http.MethodPost, "/deployments", body); rec.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+		}
+		return s
+	}
+	// rolledOut makes every Deployment read back completely rolled out
+	rolledOut := func(clientset *fake.Clientset) {
+		clientset.PrependReactor("get", "deployments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			get := a.(k8stesting.GetAction)
+			obj, err := clientset.Tracker().Get(deploymentGVR, get.GetNamespace(), get.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			dep := obj.(*appsv1.Deployment).DeepCopy()
+			n := *dep.Spec.Replicas
+			dep.Status = appsv1.DeploymentStatus{Replicas: n, UpdatedReplicas: n, ReadyReplicas: n, AvailableReplicas: n}
+			return true, dep, nil
+		})
+	}
+	weights := func(t *testing.T, dc *dynamicfake.FakeDynamicClient) []any {
+		t.Helper()
+		split, err := dc.Resource(traefikServiceGVR).Namespace("apps").Get(t.Context(), "web-deployment-service-tcp-8080", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get traefikservice: %v", err)
+		}
+		services, _, _ := unstructured.NestedSlice(split.Object, "spec", "weighted", "services")
+		return []any{services[0].(map[string]any)["weight"], services[1].(map[string]any)["weight"]}
+	}
+	const canary = `{"strategy":"canary","weight":10,"changes":{"image":"nginx:1.28"}}`
+
+	t.Run("Canary", func(t *testing.T) {
+		s := newServer(t)
+		rec := s.serve(http.MethodPost, "/deployments/apps/web/release", canary)
+		var got releaseStatus
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if got.Strategy != ReleaseCanary || got.Weight != 10 || got.StableImage != "nginx:1.27" || got.Image != "nginx:1.28" {
+			t.Errorf("unexpected release: %+v", got)
+		}
+		next, err := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-next-deployment", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get next deployment: %v", err)
+		}
+		if *next.Spec.Replicas != 1 || next.Spec.Template.Labels["app"] != "web-next" {
+			t.Errorf("expected 1 replica labelled apart from the app, got %d %v", *next.Spec.Replicas, next.Spec.Template.Labels)
+		}
+		svc, _ := s.clientset.CoreV1().Services("apps").Get(t.Context(), "web-next-deployment-service", metav1.GetOptions{})
+		if svc.Spec.Selector["app"] != "web-next" {
+			t.Errorf("expected the release service to select the next version, got %v", svc.Spec.Selector)
+		}
+		if w := weights(t, s.dynamic); w[0] != int64(90) || w[1] != int64(10) {
+			t.Errorf("expected a 90/10 split, got %v", w)
+		}
+		if _, err := s.dynamic.Resource(ingressRouteGVR).Namespace("apps").Get(t.Context(), "web-release", metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the release route: %v", err)
+		}
+
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release", canary); rec.Code != http.StatusConflict {
+			t.Errorf("expected 409 for a second release, got %d", rec.Code)
+		}
+		if rec := s.serve(http.MethodPatch, "/deployments/apps/web", `{"replicas":2}`); rec.Code != http.StatusConflict {
+			t.Errorf("expected 409 for a patch during a release, got %d", rec.Code)
+		}
+
+		rec = s.serve(http.MethodPut, "/deployments/apps/web/release/weight", `{"weight":50}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if w := weights(t, s.dynamic); w[0] != int64(50) || w[1] != int64(50) {
+			t.Errorf("expected a 50/50 split, got %v", w)
+		}
+		rec = s.serve(http.MethodGet, "/deployments/apps/web/release", "")
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.Weight != 50 {
+			t.Errorf("expected the status to report weight 50, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Promote", func(t *testing.T) {
+		s := newServer(t)
+		rolledOut(s.clientset)
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release", canary); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		rec := s.serve(http.MethodPost, "/deployments/apps/web/release/promote", "")
+		var got struct {
+			Rollout rolloutStatus
+			Deleted []appObjectRef
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if got.Rollout.Phase != RolloutComplete || len(got.Deleted) != 4 || got.Deleted[0].Kind != "IngressRoute" {
+			t.Errorf("unexpected promotion: %+v", got)
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.28" || *dep.Spec.Replicas != 3 {
+			t.Errorf("expected 3 replicas of nginx:1.28, got %d of %s", *dep.Spec.Replicas, image)
+		}
+		if dep.Spec.Template.Labels["app"] != "web" {
+			t.Errorf("expected the app's pod labels, got %v", dep.Spec.Template.Labels)
+		}
+		if _, err := s.dynamic.Resource(ingressRouteGVR).Namespace("apps").Get(t.Context(), "web-release", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected the release route to be deleted, got %v", err)
+		}
+		if _, err := s.clientset.NetworkingV1().Ingresses("apps").Get(t.Context(), "web-ingress", metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the ingress to be kept: %v", err)
+		}
+	})
+
+	t.Run("Promote times out", func(t *testing.T) {
+		s := newServer(t)
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release", canary); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release/promote?timeout=20ms", ""); rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("expected 504, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, err := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-next-deployment", metav1.GetOptions{}); err != nil {
+			t.Errorf("expected the release to be left in place: %v", err)
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.27" {
+			t.Errorf("expected the app to be rolled back, got %s", image)
+		}
+	})
+
+	t.Run("Promote fails, then abort", func(t *testing.T) {
+		s := newServer(t)
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release", canary); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		// The app's Deployment reads back past its progress deadline
+		s.clientset.PrependReactor("get", "deployments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+			get := a.(k8stesting.GetAction)
+			if get.GetName() != "web-deployment" {
+				return false, nil, nil
+			}
+			obj, err := s.clientset.Tracker().Get(deploymentGVR, get.GetNamespace(), get.GetName())
+			if err != nil {
+				return true, nil, err
+			}
+			dep := obj.(*appsv1.Deployment).DeepCopy()
+			dep.Status.Conditions = []appsv1.DeploymentCondition{{
+				Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse,
+				Reason: "ProgressDeadlineExceeded", Message: "ReplicaSet has timed out progressing",
+			}}
+			return true, dep, nil
+		})
+		rec := s.serve(http.MethodPost, "/deployments/apps/web/release/promote", "")
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "rolled back") {
+			t.Errorf("expected 500, got %d: %s", rec.Code, rec.Body.String())
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.27" {
+			t.Errorf("expected the app to be rolled back, got %s", image)
+		}
+
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release/abort", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		dep, _ = s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.27" || *dep.Spec.Replicas != 3 {
+			t.Errorf("expected the app to run 3 replicas of nginx:1.27 after the abort, got %d of %s", *dep.Spec.Replicas, image)
+		}
+	})
+
+	t.Run("Concurrent start", func(t *testing.T) {
+		s := newServer(t)
+		cluster, _ := s.h.clusters.Get(DefaultClusterName)
+		current := testDeploymentRequest()
+		current.Replicas = 3
+		next := current
+		next.Image = "nginx:1.28"
+		if _, err := startRelease(t.Context(), cluster, current, next, DomainConfig{}, ReleaseCanary, 10, 1, nil); err != nil {
+			t.Fatal(err)
+		}
+		// The loser of a race past releaseInProgress leaves the winner's release alone
+		if _, err := startRelease(t.Context(), cluster, current, next, DomainConfig{}, ReleaseCanary, 20, 1, nil); !errors.Is(err, errReleaseInProgress) {
+			t.Errorf("expected errReleaseInProgress, got %v", err)
+		}
+		for _, get := range []func() error{
+			func() error {
+				_, err := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-next-deployment", metav1.GetOptions{})
+				return err
+			},
+			func() error {
+				_, err := s.clientset.CoreV1().Services("apps").Get(t.Context(), "web-next-deployment-service", metav1.GetOptions{})
+				return err
+			},
+			func() error {
+				_, err := s.dynamic.Resource(ingressRouteGVR).Namespace("apps").Get(t.Context(), "web-release", metav1.GetOptions{})
+				return err
+			},
+		} {
+			if err := get(); err != nil {
+				t.Errorf("expected the first release to be kept: %v", err)
+			}
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		s := newServer(t)
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release", `{"strategy":"blue-green","changes":{"image":"nginx:1.28"}}`); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		next, _ := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-next-deployment", metav1.GetOptions{})
+		if *next.Spec.Replicas != 3 {
+			t.Errorf("expected blue-green to run as many replicas as the app, got %d", *next.Spec.Replicas)
+		}
+		if rec := s.serve(http.MethodPut, "/deployments/apps/web/release/weight", `{"weight":30}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for a partial blue-green switch, got %d", rec.Code)
+		}
+
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release/abort", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		dep, _ := s.clientset.AppsV1().Deployments("apps").Get(t.Context(), "web-deployment", metav1.GetOptions{})
+		if image := dep.Spec.Template.Spec.Containers[0].Image; image != "nginx:1.27" {
+			t.Errorf("expected the app to be left alone, got %s", image)
+		}
+		if rec := s.serve(http.MethodPost, "/deployments/apps/web/release/abort", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 once aborted, got %d", rec.Code)
+		}
+		if rec := s.serve(http.MethodGet, "/deployments/apps/web/release", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 once aborted, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Invalid releases", func(t *testing.T) {
+		s := newServer(t)
+		for name, tc := range map[string]struct {
+			target, body string
+			status       int
+		}{
+			"Unknown strategy":   {"/deployments/apps/web/release", `{"strategy":"yolo","changes":{}}`, http.StatusBadRequest},
+			"Weight too high":    {"/deployments/apps/web/release", `{"strategy":"canary","weight":150,"changes":{}}`, http.StatusBadRequest},
+			"Blue-green weight":  {"/deployments/apps/web/release", `{"strategy":"blue-green","weight":100,"changes":{}}`, http.StatusBadRequest},
+			"No changes":         {"/deployments/apps/web/release", `{"strategy":"canary"}`, http.StatusBadRequest},
+			"Port change":        {"/deployments/apps/web/release", `{"strategy":"canary","changes":{"ports":[{"containerPort":80}]}}`, http.StatusBadRequest},
+			"Invalid change":     {"/deployments/apps/web/release", `{"strategy":"canary","changes":{"replicas":-1}}`, http.StatusBadRequest},
+			"Unknown app":        {"/deployments/apps/api/release", canary, http.StatusNotFound},
+			"Weight, no release": {"/deployments/apps/web/release/weight", `{"weight":20}`, http.StatusNotFound},
+		} {
+			method := http.MethodPost
+			if strings.HasSuffix(tc.target, "/weight") {
+				method = http.MethodPut
+			}
+			if rec := s.serve(method, tc.target, tc.body); rec.Code != tc.status {
+				t.Errorf("%s: expected %d, got %d: %s", name, tc.status, rec.Code, rec.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultRolloutTimeout is how long ?wait=true waits for a rollout
+	// when no timeout is given.
+	DefaultRolloutTimeout = 5 * time.Minute
+	// MaxRolloutTimeout bounds the timeout a caller can ask to wait for.
+	MaxRolloutTimeout = 30 * time.Minute
+)
+
+// Rollout phases.
+const (
+	RolloutProgressing = "Progressing"
+	RolloutComplete    = "Complete"
+	RolloutFailed      = "Failed"
+)
+
+// rolloutPollInterval is how often waitForRollout checks on a Deployment.
+var rolloutPollInterval = 2 * time.Second
+
+// rolloutStatus describes how the rollout of a Deployment is going.
+type rolloutStatus struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Revision  int64  `json:"revision"`
+
+	// Phase is RolloutProgressing, RolloutComplete or RolloutFailed, with
+	// Message explaining it.
+	Phase   string `json:"phase"`
+	Message string `json:"message"`
+
+	Generation          int64 `json:"generation"`
+	ObservedGeneration  int64 `json:"observedGeneration"`
+	Replicas            int32 `json:"replicas"`
+	UpdatedReplicas     int32 `json:"updatedReplicas"`
+	ReadyReplicas       int32 `json:"readyReplicas"`
+	AvailableReplicas   int32 `json:"availableReplicas"`
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+
+	// Conditions are the Progressing and Available conditions of the Deployment.
+	Conditions []appsv1.DeploymentCondition `json:"conditions"`
+}
+
+// rolloutStatusOf returns the rollout status of dep, judged the way
+// kubectl rollout status judges it.
+func rolloutStatusOf(dep *appsv1.Deployment) rolloutStatus {
+	desired := int32(1)
+	if dep.Spec.Replicas != nil {
+		desired = *dep.Spec.Replicas
+	}
+	s := rolloutStatus{
+		Namespace:           dep.Namespace,
+		Name:                dep.Name,
+		Revision:            revisionOf(dep),
+		Generation:          dep.Generation,
+		ObservedGeneration:  dep.Status.ObservedGeneration,
+		Replicas:            desired,
+		UpdatedReplicas:     dep.Status.UpdatedReplicas,
+		ReadyReplicas:       dep.Status.ReadyReplicas,
+		AvailableReplicas:   dep.Status.AvailableReplicas,
+		UnavailableReplicas: dep.Status.UnavailableReplicas,
+		Conditions:          []appsv1.DeploymentCondition{},
+	}
+	var progressing *appsv1.DeploymentCondition
+	for i, c := range dep.Status.Conditions {
+		switch c.Type {
+		case appsv1.DeploymentProgressing:
+			progressing = &dep.Status.Conditions[i]
+		case appsv1.DeploymentAvailable:
+		default:
+			continue
+		}
+		s.Conditions = append(s.Conditions, c)
+	}
+
+	s.Phase = RolloutProgressing
+	switch {
+	case dep.Generation > dep.Status.ObservedGeneration:
+		s.Message = "waiting for the rollout to be observed"
+	case progressing != nil && progressing.Reason == "ProgressDeadlineExceeded":
+		s.Phase, s.Message = RolloutFailed, progressing.Message
+	case dep.Status.UpdatedReplicas < desired:
+		s.Message = fmt.Sprintf("%d of %d replicas updated", dep.Status.UpdatedReplicas, desired)
+	case dep.Status.Replicas > dep.Status.UpdatedReplicas:
+		s.Message = fmt.Sprintf("%d old replicas pending termination", dep.Status.Replicas-dep.Status.UpdatedReplicas)
+	case dep.Status.AvailableReplicas < dep.Status.UpdatedReplicas:
+		s.Message = fmt.Sprintf("%d of %d updated replicas available", dep.Status.AvailableReplicas, dep.Status.UpdatedReplicas)
+	default:
+		s.Phase, s.Message = RolloutComplete, "successfully rolled out"
+	}
+	return s
+}
+
+// errRolloutTimeout is returned by waitForRollout when the rollout is still
+// progressing once the timeout expires.
+var errRolloutTimeout = errors.New("timed out waiting for the rollout")
+
+// waitForRollout waits until the rollout of the Deployment name completes or
+// fails, and returns its last status. It returns errRolloutTimeout when the
+// rollout is still progressing after timeout.
+func waitForRollout(ctx context.Context, cs kubernetes.Interface, namespace, name string, timeout time.Duration) (rolloutStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	var status rolloutStatus
+	for {
+		dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			status = rolloutStatusOf(dep)
+			if status.Phase != RolloutProgressing {
+				return status, nil
+			}
+		case ctx.Err() == nil:
+			return status, err
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return status, errRolloutTimeout
+			}
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// rolloutWriteMargin is the time left to write the response once a wait
+// for a rollout is over.
+const rolloutWriteMargin = 30 * time.Second
+
+// extendWriteDeadline pushes the write deadline of w past a wait of timeout,
+// which may outlast the WriteTimeout of the server. Writers that cannot set
+// a deadline have none to extend.
+func extendWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + rolloutWriteMargin))
+}
+
+// rolloutWait holds the ?wait=true&timeout= options of a mutating call.
+type rolloutWait struct {
+	Wait    bool
+	Timeout time.Duration
+}
+
+// rolloutWaitRequested reads the wait and timeout query parameters of r. The
+// timeout is a Go duration such as 90s or 5m; it defaults to
+// DefaultRolloutTimeout and may not exceed MaxRolloutTimeout.
+func rolloutWaitRequested(r *http.Request) (rolloutWait, error) {
+	opts := rolloutWait{Timeout: DefaultRolloutTimeout}
+	query := r.URL.Query()
+	if v := query.Get("wait"); v != "" {
+		wait, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid wait value %q: must be true or false", v)
+		}
+		opts.Wait = wait
+	}
+	if v := query.Get("timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return opts, fmt.Errorf("invalid timeout %q: must be a positive duration such as 90s or 5m", v)
+		}
+		if timeout > MaxRolloutTimeout {
+			return opts, fmt.Errorf("timeout may not exceed %s", MaxRolloutTimeout)
+		}
+		opts.Timeout = timeout
+	}
+	return opts, nil
+}
+
+// respondAfterRollout writes result as the response of a mutating call on
+// the Deployment name. Without wait it is written as is. With wait the
+// response is sent once the rollout is over, as {"result", "rollout"}, with
+// 200 when it completed, 500 when it failed its progress deadline and 504
+// when the wait timed out.
+func (h *Handler) respondAfterRollout(w http.ResponseWriter, r *http.Request, cs kubernetes.Interface, namespace, name string, opts rolloutWait, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	if !opts.Wait {
+		_ = json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	extendWriteDeadline(w, opts.Timeout)
+	status, err := waitForRollout(r.Context(), cs, namespace, name, opts.Timeout)
+	code := http.StatusOK
+	switch {
+	case errors.Is(err, errRolloutTimeout):
+		code, status.Message = http.StatusGatewayTimeout, fmt.Sprintf("%s after %s: %s", err, opts.Timeout, status.Message)
+	case err != nil:
+		code, status.Message = kubeErrorStatus(err), err.Error()
+	case status.Phase == RolloutFailed:
+		code = http.StatusInternalServerError
+	}
+	if code != http.StatusOK {
+		h.requestLogger(r).WarnCtx(r.Context(), "rollout did not complete", "namespace", namespace,
+			"deployment", name, "phase", status.Phase, "message", status.Message)
+	}
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(struct {
+		Result  any           `json:"result"`
+		Rollout rolloutStatus `json:"rollout"`
+	}{result, status})
+}
+
+// handleRolloutStatus reports the rollout status of a Deployment.
+func (h *Handler) handleRolloutStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		deploymentName := r.PathValue("deploymentName")
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dep, err := cluster.Clientset.AppsV1().Deployments(namespace).Get(r.Context(), deploymentName, metav1.GetOptions{})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), kubeErrorStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rolloutStatusOf(dep))
+	}
+}
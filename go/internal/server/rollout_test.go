@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testRolloutDeployment returns Deployment web-deployment with 3 replicas,
+// completely rolled out at revision 2.
+func testRolloutDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "web-deployment", Namespace: "apps", Generation: 4,
+			Annotations: map[string]string{RevisionAnnotation: "2"},
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 4, Replicas: 3, UpdatedReplicas: 3, ReadyReplicas: 3, AvailableReplicas: 3,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue, Reason: "MinimumReplicasAvailable"},
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "NewReplicaSetAvailable"},
+				{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionFalse},
+			},
+		},
+	}
+}
+
+func TestRolloutStatusOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*appsv1.Deployment)
+		phase   string
+		message string
+	}{
+		{name: "Complete", modify: func(*appsv1.Deployment) {}, phase: RolloutComplete},
+		{
+			name:    "Not observed yet",
+			modify:  func(d *appsv1.Deployment) { d.Generation = 5 },
+			phase:   RolloutProgressing,
+			message: "waiting for the rollout to be observed",
+		},
+		{
+			name:    "Updating",
+			modify:  func(d *appsv1.Deployment) { d.Status.UpdatedReplicas = 1 },
+			phase:   RolloutProgressing,
+			message: "1 of 3 replicas updated",
+		},
+		{
+			name:    "Old replicas terminating",
+			modify:  func(d *appsv1.Deployment) { d.Status.Replicas = 4 },
+			phase:   RolloutProgressing,
+			message: "1 old replicas pending termination",
+		},
+		{
+			name:    "Waiting for availability",
+			modify:  func(d *appsv1.Deployment) { d.Status.AvailableReplicas = 2 },
+			phase:   RolloutProgressing,
+			message: "2 of 3 updated replicas available",
+		},
+		{
+			name: "Progress deadline exceeded",
+			modify: func(d *appsv1.Deployment) {
+				d.Status.UpdatedReplicas = 1
+				d.Status.Conditions[1] = appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse,
+					Reason: "ProgressDeadlineExceeded", Message: `ReplicaSet "web-deployment-abc" has timed out progressing.`}
+			},
+			phase:   RolloutFailed,
+			message: "has timed out progressing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := testRolloutDeployment()
+			tt.modify(dep)
+			got := rolloutStatusOf(dep)
+			if got.Phase != tt.phase || !strings.Contains(got.Message, tt.message) {
+				t.Errorf("expected %s %q, got %s %q", tt.phase, tt.message, got.Phase, got.Message)
+			}
+			if got.Revision != 2 || got.Replicas != 3 || len(got.Conditions) != 2 {
+				t.Errorf("unexpected status: %+v", got)
+			}
+		})
+	}
+}
+
+func TestRolloutWaitRequested(t *testing.T) {
+	for target, want := range map[string]rolloutWait{
+		"/deployments":                       {Timeout: DefaultRolloutTimeout},
+		"/deployments?wait=true":             {Wait: true, Timeout: DefaultRolloutTimeout},
+		"/deployments?wait=1&timeout=90s":    {Wait: true, Timeout: 90 * time.Second},
+		"/deployments?wait=false&timeout=1m": {Timeout: time.Minute},
+	} {
+		got, err := rolloutWaitRequested(httptest.NewRequest(http.MethodPost, target, nil))
+		if err != nil || got != want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", target, want, got, err)
+		}
+	}
+	for _, target := range []string{"/deployments?wait=soon", "/deployments?timeout=5", "/deployments?timeout=-1s", "/deployments?timeout=2h"} {
+		if _, err := rolloutWaitRequested(httptest.NewRequest(http.MethodPost, target, nil)); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
+
+func TestWaitForRollout(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	// progressing serves a Deployment that is still updating for the first
+	// polls, then in the state final leaves it in
+	progressing := func(polls int, final func(*appsv1.Deployment)) *fake.Clientset {
+		clientset := fake.NewClientset()
+		clientset.PrependReactor("get", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+			dep := testRolloutDeployment()
+			if polls--; polls > 0 {
+				dep.Status.UpdatedReplicas = 1
+			} else {
+				final(dep)
+			}
+			return true, dep, nil
+		})
+		return clientset
+	}
+
+	t.Run("Completes", func(t *testing.T) {
+		got, err := waitForRollout(t.Context(), progressing(3, func(*appsv1.Deployment) {}), "apps", "web-deployment", time.Second)
+		if err != nil || got.Phase != RolloutComplete {
+			t.Errorf("expected the rollout to complete, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Fails its progress deadline", func(t *testing.T) {
+		got, err := waitForRollout(t.Context(), progressing(2, func(d *appsv1.Deployment) {
+			d.Status.Conditions[1].Reason = "ProgressDeadlineExceeded"
+		}), "apps", "web-deployment", time.Second)
+		if err != nil || got.Phase != RolloutFailed {
+			t.Errorf("expected the rollout to fail, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Times out", func(t *testing.T) {
+		got, err := waitForRollout(t.Context(), progressing(1<<30, nil), "apps", "web-deployment", 20*time.Millisecond)
+		if err != errRolloutTimeout || got.Phase != RolloutProgressing {
+			t.Errorf("expected a timeout while progressing, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Missing deployment", func(t *testing.T) {
+		if _, err := waitForRollout(t.Context(), fake.NewClientset(), "apps", "web-deployment", time.Second); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestRolloutHandlers(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	newServer := func(t *testing.T, dep *appsv1.Deployment) *testServer {
+		t.Helper()
+		s := newTestServer(t, dep)
+		s.handle("GET /deployments/{namespace}/{deploymentName}/rollout", s.h.handleRolloutStatus())
+		s.handle("POST /deployments/{namespace}/{deploymentName}/restart", s.h.handleRolloutRestart())
+		return s
+	}
+
+	t.Run("Status", func(t *testing.T) {
+		rec := newServer(t, testRolloutDeployment()).serve(http.MethodGet, "/deployments/apps/web-deployment/rollout", "")
+		var got rolloutStatus
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if got.Phase != RolloutComplete || got.AvailableReplicas != 3 || got.ObservedGeneration != 4 {
+			t.Errorf("unexpected status: %+v", got)
+		}
+		if rec := newServer(t, testRolloutDeployment()).serve(http.MethodGet, "/deployments/apps/api/rollout", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for an unknown deployment, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Restart and wait", func(t *testing.T) {
+		rec := newServer(t, testRolloutDeployment()).serve(http.MethodPost, "/deployments/apps/web-deployment/restart?wait=true", "")
+		var got struct {
+			Result  string        `json:"result"`
+			Rollout rolloutStatus `json:"rollout"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d (%v)", rec.Code, err)
+		}
+		if got.Rollout.Phase != RolloutComplete || got.Result != "deployment restarted successfully" {
+			t.Errorf("unexpected response: %+v", got)
+		}
+	})
+
+	t.Run("Restart times out", func(t *testing.T) {
+		dep := testRolloutDeployment()
+		dep.Status.AvailableReplicas = 0
+		rec := newServer(t, dep).serve(http.MethodPost, "/deployments/apps/web-deployment/restart?wait=true&timeout=20ms", "")
+		if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "timed out waiting for the rollout") {
+			t.Errorf("expected 504, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Wait outlasts the write timeout", func(t *testing.T) {
+		dep := testRolloutDeployment()
+		dep.Status.AvailableReplicas = 0
+		srv := httptest.NewUnstartedServer(newServer(t, dep).mux)
+		srv.Config.WriteTimeout = 20 * time.Millisecond
+		srv.Start()
+		defer srv.Close()
+
+		resp, err := srv.Client().Post(srv.URL+"/deployments/apps/web-deployment/restart?wait=true&timeout=200ms", "", nil)
+		if err != nil {
+			t.Fatalf("expected a response, got %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("expected 504, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Invalid timeout", func(t *testing.T) {
+		rec := newServer(t, testRolloutDeployment()).serve(http.MethodPost, "/deployments/apps/web-deployment/restart?wait=true&timeout=forever", "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", rec.Code)
+		}
+	})
+}
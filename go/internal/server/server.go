@@ -8,31 +8,46 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
-	"slices"
 	"strings"
 	"time"
 
+	"github.com/ClappFormOrg/AI-CO/go/internal/audit"
 	"github.com/ClappFormOrg/AI-CO/go/pkg/kube/client"
 	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/client-go/dynamic"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
 	Component string = "internal.server"
+
+	// DefaultClusterName is the name of the cluster aico itself runs in. It is
+	// used when a request does not select a cluster through the "cluster-name" header.
+	DefaultClusterName string = "clappform"
+
+	// DefaultDomain is the domain apps are exposed on when their cluster has
+	// no domain of its own.
+	DefaultDomain string = "services.clappform.com"
+
+	// TLSSecretName is the secret holding the domain certificate in every
+	// namespace aico deploys to.
+	TLSSecretName string = "cert"
+
+	// ManagedByLabel marks the objects aico created, with ManagedByValue as value.
+	ManagedByLabel string = "app.kubernetes.io/managed-by"
+	ManagedByValue string = "aico"
+
+	// AppLabel names the app an object belongs to. Together with
+	// ManagedByLabel it selects every object aico created for the app.
+	AppLabel string = "aico.clappform.com/app"
 )
 
 type DomainConfig struct {
@@ -42,20 +57,25 @@ type DomainConfig struct {
 }
 
 type Handler struct {
-	mux            *http.ServeMux
-	logger         log.Logger
-	clientset      *kubernetes.Clientset
-	clientctrl     ctrlclient.Client
-	clients        map[string]*kubernetes.Clientset
-	clientsConfig  map[string]*rest.Config
-	clientsDomains map[string]DomainConfig
-	tlsKey         []byte // WARN: Check for emptiness before use!
-	tlsCrt         []byte // WARN: Check for emptiness before use!
+	mux              *http.ServeMux
+	logger           log.Logger
+	clusters         *ClusterRegistry
+	authenticator    Authenticator
+	authorizer       Authorizer
+	clusterStore     ClusterStore
+	health           *HealthMonitor
+	healthInterval   time.Duration
+	healthTimeout    time.Duration
+	allowExecPlugins bool
+	auditSink        audit.Sink
+	clientDefaults   ClientSettings
+	tlsKey           []byte // WARN: Check for emptiness before use!
+	tlsCrt           []byte // WARN: Check for emptiness before use!
 }
 
 func int32Ptr(i int32) *int32 { return &i }
 
-func validateNamespaceExists(clientset *kubernetes.Clientset, namespace string) error {
+func validateNamespaceExists(clientset kubernetes.Interface, namespace string) error {
 	_, err := clientset.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("namespace %s does not exist: %w", namespace, err)
@@ -63,141 +83,143 @@ func validateNamespaceExists(clientset *kubernetes.Clientset, namespace string)
 	return nil
 }
 
-// AuthMiddleware checks for a valid token in the Authorization header
-func AuthMiddleware(next http.HandlerFunc, validToken string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" || token != validToken {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		next(w, r)
+// requestLogger returns the handler logger annotated with the identity of the
+// caller, as stored in the request context by AuthMiddleware.
+func (h *Handler) requestLogger(r *http.Request) log.Logger {
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		return h.logger.With("subject", id.Subject, "groups", id.Groups)
 	}
+	return h.logger
 }
 
-func switchClientset(h *Handler, clusterName string) (*kubernetes.Clientset, error) {
-	clientset, exists := h.clients[clusterName]
-	if !exists {
-		return nil, fmt.Errorf("unknown cluster: %s", clusterName)
+// clusterFor returns the cluster targeted by r, as selected by clusterFromRequest.
+func (h *Handler) clusterFor(r *http.Request) (*Cluster, error) {
+	name := clusterFromRequest(r)
+	cluster, ok := h.clusters.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, name)
 	}
-	return clientset, nil
-}
-
-type DeploymentRequest struct {
-	Namespace      string `json:"namespace"`
-	DeploymentName string `json:"deploymentName"`
-	Image          string `json:"image"`
-	Replicas       int32  `json:"replicas"`
-	Resources      struct {
-		CPULimits      string `json:"cpuLimits"`
-		CPURequests    string `json:"cpuRequests"`
-		MemoryLimits   string `json:"memoryLimits"`
-		MemoryRequests string `json:"memoryRequests"`
-	} `json:"resources"`
-	Ports []corev1.ContainerPort `json:"ports"`
+	return cluster, nil
 }
 
-// validateDeploymentRequestBody checks all required fields in the deployment request body
-func validateDeploymentRequestBody(req DeploymentRequest) error {
-	var err []error
-	if req.Namespace == "" {
-		err = append(err, fmt.Errorf("namespace is required"))
+// domainFor returns the domain apps on cluster are exposed on, falling back
+// to DefaultDomain and the configured TLS certificate.
+func (h *Handler) domainFor(cluster *Cluster) DomainConfig {
+	if cluster.Domain.Domain != "" {
+		return cluster.Domain
 	}
-	if req.DeploymentName == "" {
-		err = append(err, fmt.Errorf("deploymentName is required"))
-	}
-	if req.Image == "" {
-		err = append(err, fmt.Errorf("image is required"))
-	}
-	if req.Replicas <= 0 {
-		err = append(err, fmt.Errorf("replicas must be greater than 0"))
-	}
-	if req.Resources.CPULimits == "" {
-		err = append(err, fmt.Errorf("resources.cpuLimits is required"))
-	}
-	if req.Resources.CPURequests == "" {
-		err = append(err, fmt.Errorf("resources.cpuRequests is required"))
-	}
-	if req.Resources.MemoryLimits == "" {
-		err = append(err, fmt.Errorf("resources.memoryLimits is required"))
-	}
-	if req.Resources.MemoryRequests == "" {
-		err = append(err, fmt.Errorf("resources.memoryRequests is required"))
-	}
-	if len(req.Ports) == 0 {
-		err = append(err, fmt.Errorf("at least one port is required in ports"))
-	}
-	for i, port := range req.Ports {
-		if port.ContainerPort == 0 {
-			err = append(err, fmt.Errorf("ports[%d].containerPort is required and must be > 0", i))
-		}
+	return DomainConfig{
+		Domain:      DefaultDomain,
+		Certificate: h.tlsCrt,
+		PrivateKey:  h.tlsKey,
 	}
-	if len(err) > 0 {
-		return fmt.Errorf("validation failed: %v", err)
-	}
-	return nil
 }
 
+// clusterNameRe matches the names clusters can be onboarded under.
+var clusterNameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,80}$`)
+
 func NewHandler(opts ...Option) (h *Handler, err error) {
 	h = &Handler{
-		mux:            new(http.ServeMux),
-		logger:         log.NewNoOpLogger(),
-		clients:        make(map[string]*kubernetes.Clientset),
-		clientsConfig:  make(map[string]*rest.Config),
-		clientsDomains: make(map[string]DomainConfig),
-		tlsKey:         []byte{},
-		tlsCrt:         []byte{},
+		mux:      new(http.ServeMux),
+		logger:   log.NewNoOpLogger(),
+		clusters: NewClusterRegistry(),
+		tlsKey:   []byte{},
+		tlsCrt:   []byte{},
+
+		healthInterval: DefaultHealthCheckInterval,
+		healthTimeout:  DefaultHealthCheckTimeout,
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
 
-	// Create the main clientset
-	clientset, clientConfig, err := client.CreateKubernetesClient()
+	if h.authenticator == nil {
+		h.logger.Warn("no authenticator configured, all API requests will be rejected")
+		h.authenticator = ChainAuthenticator{}
+	}
+	if h.authorizer == nil {
+		h.logger.Warn("no authorization policy configured, all API requests will be denied")
+		h.authorizer = &Policy{}
+	}
+
+	// Create the main cluster config
+	_, clientConfig, err := client.CreateKubernetesClient()
 	if err != nil {
 		message := "failed to create kubernetes clientset"
 		h.logger.Error(message, "err", err)
 		return nil, fmt.Errorf("%s: %w", message, err)
 	}
-	// Set as active clientset
-	h.clientset = clientset
-
-	// Add to selectable clients list and the config
-	h.clients["clappform"] = clientset
-	h.clientsConfig["clappform"] = clientConfig
+	h.clientDefaults.apply(clientConfig)
 
-	h.clientctrl, err = client.CreateControllerRuntimeClient()
+	// Register the main cluster, it serves the default domain
+	mainCluster, err := newCluster(DefaultClusterName, clientConfig, DomainConfig{})
 	if err != nil {
-		message := "failed to create kubernetes controller runtime client"
+		message := "failed to create kubernetes clients"
 		h.logger.Error(message, "err", err)
 		return nil, fmt.Errorf("%s: %w", message, err)
 	}
-
-	print("Handler initialized successfully, Following clusters are configured:\n")
-	for clusterName := range h.clients {
-		print(fmt.Sprintf(" - %s\n", clusterName))
+	if err := h.clusters.Add(mainCluster); err != nil {
+		return nil, err
 	}
 
-	h.mux.HandleFunc("GET /pods/{namespace}", AuthMiddleware(h.handleActivePods(), ""))
-	h.mux.HandleFunc("GET /pods/{namespace}/{podname}/logs", AuthMiddleware(h.handlePodLogs(), ""))
-
-	h.mux.HandleFunc("GET /deployments/{namespace}", AuthMiddleware(h.handleDeploymentGetAll(), ""))
-	h.mux.HandleFunc("GET /deployments/{namespace}/{deploymentName}", AuthMiddleware(h.handleDeploymentGet(), ""))
-	h.mux.HandleFunc("POST /deployments", AuthMiddleware(h.handleDeploymentCreation(), ""))
-	h.mux.HandleFunc("DELETE /deployments/{namespace}/{deploymentName}", AuthMiddleware(h.handleDeploymentDeletion(), ""))
-	h.mux.HandleFunc("PUT /deployments/{namespace}/{deploymentName}", AuthMiddleware(h.handleDeploymentUpdate(), ""))
-	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/restart", AuthMiddleware(h.handleRolloutRestart(), ""))
-
-	h.mux.HandleFunc("GET /clusters", AuthMiddleware(h.handleListClusters(), ""))
-	h.mux.HandleFunc("GET /clusters/{clusterName}", AuthMiddleware(h.handleGetCluster(), ""))
-	h.mux.HandleFunc("POST /clusters", AuthMiddleware(h.handleAddClusterContext(), ""))
-
-	h.mux.HandleFunc("POST /secrets", AuthMiddleware(h.handleCreateSecret(), ""))
-	h.mux.HandleFunc("GET /secrets/{namespace}", AuthMiddleware(h.handleGetSecrets(), ""))
+	if h.clusterStore != nil {
+		if err := h.loadStoredClusters(context.Background()); err != nil {
+			message := "failed to load stored clusters"
+			h.logger.Error(message, "err", err)
+			return nil, fmt.Errorf("%s: %w", message, err)
+		}
+	} else {
+		h.logger.Warn("no cluster store configured, onboarded clusters are lost on restart")
+	}
 
-	h.mux.HandleFunc("POST /configmap", AuthMiddleware(h.handleCreateConfigMap(), ""))
-	h.mux.HandleFunc("GET /configmap/{namespace}", AuthMiddleware(h.handleGetConfigMaps(), ""))
+	// Probe clusters in the background so requests are served from the cache
+	h.health = NewHealthMonitor(h.clusters, h.healthInterval, h.healthTimeout, h.logger)
+	h.health.Start()
+
+	h.logger.Info("handler initialized", "clusters", h.clusters.List())
+
+	// Routes that carry their namespace or cluster in the request body are
+	// only authenticated here and authorize the caller in the handler.
+	h.mux.HandleFunc("GET /pods/{namespace}", h.protect(PermPodsRead, h.handleActivePods()))
+	h.mux.HandleFunc("GET /pods/{namespace}/{podname}/logs", h.protect(PermPodsRead, h.handlePodLogs()))
+
+	h.mux.HandleFunc("GET /deployments/{namespace}", h.protect(PermDeploymentsRead, h.handleDeploymentGetAll()))
+	h.mux.HandleFunc("GET /deployments/{namespace}/{deploymentName}", h.protect(PermDeploymentsRead, h.handleDeploymentGet()))
+	h.mux.HandleFunc("POST /deployments", AuthMiddleware(h.handleDeploymentCreation(), h.authenticator, h.logger))
+	h.mux.HandleFunc("DELETE /deployments/{namespace}/{deploymentName}", h.protect(PermDeploymentsWrite, h.handleDeploymentDeletion()))
+	h.mux.HandleFunc("PUT /deployments/{namespace}/{deploymentName}", h.protect(PermDeploymentsWrite, h.handleDeploymentUpdate()))
+	h.mux.HandleFunc("PATCH /deployments/{namespace}/{deploymentName}", h.protect(PermDeploymentsWrite, h.handleDeploymentPatch()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/restart", h.protect(PermDeploymentsWrite, h.handleRolloutRestart()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/pause", h.protect(PermDeploymentsWrite, h.handleDeploymentPause()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/resume", h.protect(PermDeploymentsWrite, h.handleDeploymentResume()))
+	h.mux.HandleFunc("GET /deployments/{namespace}/{deploymentName}/rollout", h.protect(PermDeploymentsRead, h.handleRolloutStatus()))
+	h.mux.HandleFunc("GET /deployments/{namespace}/{deploymentName}/history", h.protect(PermDeploymentsRead, h.handleDeploymentHistory()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/rollback", h.protect(PermDeploymentsWrite, h.handleDeploymentRollback()))
+	h.mux.HandleFunc("GET /deployments/{namespace}/{deploymentName}/release", h.protect(PermDeploymentsRead, h.handleReleaseStatus()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/release", h.protect(PermDeploymentsWrite, h.handleReleaseStart()))
+	h.mux.HandleFunc("PUT /deployments/{namespace}/{deploymentName}/release/weight", h.protect(PermDeploymentsWrite, h.handleReleaseWeight()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/release/promote", h.protect(PermDeploymentsWrite, h.handleReleasePromote()))
+	h.mux.HandleFunc("POST /deployments/{namespace}/{deploymentName}/release/abort", h.protect(PermDeploymentsWrite, h.handleReleaseAbort()))
+
+	h.mux.HandleFunc("PUT /apps/{namespace}/{name}", h.protect(PermDeploymentsWrite, h.handleApplyApp()))
+	h.mux.HandleFunc("GET /apps/{namespace}/{name}/manifests", h.protect(PermDeploymentsRead, h.handleAppManifests()))
+
+	h.mux.HandleFunc("GET /clusters", AuthMiddleware(h.handleListClusters(), h.authenticator, h.logger))
+	h.mux.HandleFunc("GET /clusters/{clusterName}", h.protect(PermClustersRead, h.handleGetCluster()))
+	h.mux.HandleFunc("POST /clusters", AuthMiddleware(h.handleAddClusterContext(), h.authenticator, h.logger))
+	h.mux.HandleFunc("POST /clusters/kubeconfig", AuthMiddleware(h.handleAddClusterKubeconfig(), h.authenticator, h.logger))
+	h.mux.HandleFunc("PUT /clusters/{clusterName}", h.protect(PermClustersWrite, h.handleUpdateCluster(false)))
+	h.mux.HandleFunc("PATCH /clusters/{clusterName}", h.protect(PermClustersWrite, h.handleUpdateCluster(true)))
+	h.mux.HandleFunc("DELETE /clusters/{clusterName}", h.protect(PermClustersWrite, h.handleRemoveCluster()))
+
+	h.mux.HandleFunc("POST /secrets", AuthMiddleware(h.handleCreateSecret(), h.authenticator, h.logger))
+	h.mux.HandleFunc("GET /secrets/{namespace}", h.protect(PermSecretsRead, h.handleGetSecrets()))
+
+	h.mux.HandleFunc("POST /configmap", AuthMiddleware(h.handleCreateConfigMap(), h.authenticator, h.logger))
+	h.mux.HandleFunc("GET /configmap/{namespace}", h.protect(PermConfigMapsRead, h.handleGetConfigMaps()))
+
+	h.mux.HandleFunc("GET /audit", AuthMiddleware(h.handleAuditQuery(), h.authenticator, h.logger))
 
 	// Health check endpoints (no auth required)
 	h.mux.HandleFunc("GET /health", h.handleHealth())
@@ -208,15 +230,22 @@ func NewHandler(opts ...Option) (h *Handler, err error) {
 
 func (h *Handler) Stop(ctx context.Context) error {
 	h.logger.DebugCtx(ctx, "stopping handler")
-	return nil
+	return h.health.Stop(ctx)
 }
 
 func (h *Handler) Close() error {
 	h.logger.Debug("closing handler")
+	if closer, ok := h.auditSink.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auditSink != nil && audited(r.Method) {
+		h.serveAudited(w, r)
+		return
+	}
 	h.mux.ServeHTTP(w, r)
 }
 
@@ -229,17 +258,13 @@ func (h *Handler) handleActivePods() http.HandlerFunc {
 			return
 		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for handling active pods
 		pods, err := activeClientset.CoreV1().Pods(namespace).List(r.Context(), metav1.ListOptions{})
@@ -281,9 +306,17 @@ func (h *Handler) handlePodLogs() http.HandlerFunc {
 			return
 		}
 
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		activeClientset := cluster.Clientset
+
 		// Get the logs for the specified pod
 		podLogOpts := corev1.PodLogOptions{}
-		req := h.clientset.CoreV1().Pods(namespace).GetLogs(podName, &podLogOpts)
+		req := activeClientset.CoreV1().Pods(namespace).GetLogs(podName, &podLogOpts)
 		podLogs, err := req.Stream(r.Context())
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to get pod logs: %v", err), http.StatusInternalServerError)
@@ -315,9 +348,22 @@ func (h *Handler) handleRolloutRestart() http.HandlerFunc {
 			http.Error(w, "deploymentName is empty", http.StatusBadRequest)
 			return
 		}
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for handling rollout restart
-		deploymentsClient := h.clientset.AppsV1().Deployments(namespace)
+		deploymentsClient := activeClientset.AppsV1().Deployments(namespace)
 		deployment, err := deploymentsClient.Get(r.Context(), deploymentName, metav1.GetOptions{})
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), http.StatusInternalServerError)
@@ -329,12 +375,17 @@ func (h *Handler) handleRolloutRestart() http.HandlerFunc {
 			deployment.Spec.Template.Annotations = make(map[string]string)
 		}
 		deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().UTC().Format(time.RFC3339)
+		deployment.Annotations = mergeLabels(deployment.Annotations, changeAnnotations(r, "restarted"))
 		_, err = deploymentsClient.Update(r.Context(), deployment, metav1.UpdateOptions{})
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to update deployment: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		if wait.Wait {
+			h.respondAfterRollout(w, r, activeClientset, namespace, deploymentName, wait, "deployment restarted successfully")
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("deployment restarted successfully"))
 	}
@@ -352,215 +403,217 @@ func (h *Handler) handleDeploymentCreation() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if len(in.Ports) == 0 {
-			http.Error(w, "at least one container port is required", http.StatusBadRequest)
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		// 1) pick cluster client
-		cs := h.clientset
-		domainConfig := DomainConfig{
-			Domain:      "services.clappform.com",
-			Certificate: h.tlsCrt,
-			PrivateKey:  h.tlsKey,
-		}
-		clientConfig := h.clientsConfig["clappform"]
-		if name := r.Header.Get("cluster-name"); name != "" {
-			var err error
-			cs, err = switchClientset(h, name)
-			clientConfig = h.clientsConfig[name]
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-
-			if dc, ok := h.clientsDomains[name]; ok {
-				domainConfig = dc
-			}
-		}
-
-		// 2) ensure namespace exists
-		if err := validateNamespaceExists(cs, in.Namespace); err != nil {
-			if _, err := cs.CoreV1().Namespaces().Create(r.Context(), &corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{Name: in.Namespace},
-			}, metav1.CreateOptions{}); err != nil {
-				http.Error(w, fmt.Sprintf("failed to create namespace: %v", err), http.StatusInternalServerError)
-				return
-			}
-		}
-
-		// 3) ensure TLS secret (only if you really need TLS now)
-		// NOTE: replace these with real cert/key data or skip TLS until ready.
-		if _, err := cs.CoreV1().Secrets(in.Namespace).Get(r.Context(), "cert", metav1.GetOptions{}); err != nil {
-			secret := &corev1.Secret{
-				ObjectMeta: metav1.ObjectMeta{Name: "cert", Namespace: in.Namespace},
-				Type:       corev1.SecretTypeTLS,
-				Data: map[string][]byte{
-					"tls.crt": slices.Clone(domainConfig.Certificate),
-					"tls.key": slices.Clone(domainConfig.PrivateKey),
-				},
-			}
-			if _, err := cs.CoreV1().Secrets(in.Namespace).Create(r.Context(), secret, metav1.CreateOptions{}); err != nil {
-				http.Error(w, fmt.Sprintf("failed to create TLS secret: %v", err), http.StatusInternalServerError)
-				return
-			}
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		// Common names & labels
-		depName := in.DeploymentName + "-deployment"
-		svcName := in.DeploymentName + "-deployment-service"
-		appLabel := map[string]string{"app": in.DeploymentName}
-
-		// 4) Deployment
-		deployment := &appsv1.Deployment{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      depName,
-				Namespace: in.Namespace,
-				Labels:    appLabel,
-			},
-			Spec: appsv1.DeploymentSpec{
-				Replicas: int32Ptr(in.Replicas),
-				Selector: &metav1.LabelSelector{MatchLabels: appLabel},
-				Template: corev1.PodTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{Labels: appLabel},
-					Spec: corev1.PodSpec{
-						Containers: []corev1.Container{
-							{
-								Name:  in.DeploymentName + "-container",
-								Image: in.Image,
-								Ports: in.Ports, // []corev1.ContainerPort
-								Resources: corev1.ResourceRequirements{
-									Limits: corev1.ResourceList{
-										corev1.ResourceCPU:    resource.MustParse(in.Resources.CPULimits),
-										corev1.ResourceMemory: resource.MustParse(in.Resources.MemoryLimits),
-									},
-									Requests: corev1.ResourceList{
-										corev1.ResourceCPU:    resource.MustParse(in.Resources.CPURequests),
-										corev1.ResourceMemory: resource.MustParse(in.Resources.MemoryRequests),
-									},
-								},
-								// Optional but recommended:
-								// ReadinessProbe: ...,
-								// LivenessProbe:  ...,
-							},
-						},
-					},
-				},
-			},
+		auditObjects(r.Context(), in.DeploymentName)
+		if !h.authorize(w, r, PermDeploymentsWrite, in.Namespace) {
+			return
 		}
 
-		depClient := cs.AppsV1().Deployments(in.Namespace)
-		createdDep, err := depClient.Create(r.Context(), deployment, metav1.CreateOptions{})
+		// 1) pick cluster client
+		cluster, err := h.clusterFor(r)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to create deployment: %v", err), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		// 5) Service (name must match Ingress backend!)
-		svc := &corev1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      svcName,
-				Namespace: in.Namespace,
-				Labels:    appLabel,
-			},
-			Spec: corev1.ServiceSpec{
-				Selector: appLabel,
-				Ports: []corev1.ServicePort{
-					{
-						Protocol:   corev1.ProtocolTCP,
-						Port:       in.Ports[0].ContainerPort,                      // service port
-						TargetPort: intstr.FromInt(int(in.Ports[0].ContainerPort)), // forward to container
-						// Name: optional but good if you add more ports
-					},
-				},
-				Type: corev1.ServiceTypeClusterIP,
-			},
-		}
-		if _, err := cs.CoreV1().Services(in.Namespace).Create(r.Context(), svc, metav1.CreateOptions{}); err != nil {
-			http.Error(w, fmt.Sprintf("failed to create service: %v", err), http.StatusInternalServerError)
+		cs := cluster.Clientset
+		domainConfig := h.domainFor(cluster)
+		if in, err = withAvailabilityTier(r.Context(), cs, in); err != nil {
+			http.Error(w, fmt.Sprintf("failed to resolve availability tier: %v", err), kubeErrorStatus(err))
 			return
 		}
 
-		// 6) Ingress (Traefik)
-		// Use spec.IngressClassName and make sure traefik is installed & watching this namespace.
-		// ingressClass := "traefik"
-		pathPrefix := "/" + depName // if you want /<deployment>-deployment
-		middleWareName := "strip-" + depName + "-prefix"
-
-		gvr := schema.GroupVersionResource{Group: "traefik.io", Version: "v1alpha1", Resource: "middlewares"}
-		dc, _ := dynamic.NewForConfig(clientConfig)
-
-		obj := &unstructured.Unstructured{
-			Object: map[string]interface{}{
-				"apiVersion": "traefik.io/v1alpha1",
-				"kind":       "Middleware",
-				"metadata":   map[string]interface{}{"name": middleWareName, "namespace": in.Namespace},
-				"spec": map[string]interface{}{
-					"stripPrefixRegex": map[string]interface{}{"regex": []string{"^/" + depName}},
-				},
-			},
-		}
-
-		response, err := dc.Resource(gvr).Namespace(in.Namespace).Create(r.Context(), obj, metav1.CreateOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to create middleware: %v", err), http.StatusInternalServerError)
+		// Referenced ConfigMaps and Secrets must exist before anything is created
+		if err := checkReferences(r.Context(), cs, in.Namespace, appDeployment(in).Spec.Template.Spec); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrMissingReference) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
-		_ = response // avoid unused var warning, though you might want to log or inspect it
-
-		print("Domain config:\n")
-		print(fmt.Sprintf(" - Domain: %s\n", domainConfig.Domain))
-		print(fmt.Sprintf(" - Cert: %d bytes\n", len(domainConfig.Certificate)))
-		print(fmt.Sprintf(" - Key: %d bytes\n", len(domainConfig.PrivateKey)))
-
-		ing := &networkingv1.Ingress{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      in.DeploymentName + "-ingress",
-				Namespace: in.Namespace,
-				Annotations: map[string]string{
-					"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
-					"traefik.ingress.kubernetes.io/router.tls":         "true",
-					"traefik.ingress.kubernetes.io/router.middlewares": fmt.Sprintf("%s-%s@kubernetescrd", in.Namespace, middleWareName),
-				},
-			},
-			Spec: networkingv1.IngressSpec{
-				// IngressClassName: &ingressClass,
-				Rules: []networkingv1.IngressRule{
-					{
-						Host: domainConfig.Domain,
-						IngressRuleValue: networkingv1.IngressRuleValue{
-							HTTP: &networkingv1.HTTPIngressRuleValue{
-								Paths: []networkingv1.HTTPIngressPath{
-									{
-										Path:     pathPrefix,
-										PathType: ptrPathType(networkingv1.PathTypePrefix),
-										Backend: networkingv1.IngressBackend{
-											Service: &networkingv1.IngressServiceBackend{
-												Name: svcName, // <-- must match the Service name
-												Port: networkingv1.ServiceBackendPort{
-													Number: in.Ports[0].ContainerPort,
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-				TLS: []networkingv1.IngressTLS{
-					{Hosts: []string{"services.clappform.com"}, SecretName: "cert"},
-				},
-			},
-		}
-		if _, err := cs.NetworkingV1().Ingresses(in.Namespace).Create(r.Context(), ing, metav1.CreateOptions{}); err != nil {
-			http.Error(w, fmt.Sprintf("failed to create ingress: %v", err), http.StatusInternalServerError)
+		// 2) create the app step by step, undoing what was done if a step fails.
+		// A dry run goes through the same steps without persisting anything.
+		ns := in.Namespace
+		names := appNamesFor(in.DeploymentName)
+		createOpts := createOptions(dryRun)
+		background := metav1.DeletePropagationBackground
+		deleteOpts := metav1.DeleteOptions{PropagationPolicy: &background}
+		undoable := func(undo func(context.Context) error) func(context.Context) error {
+			if dryRun {
+				return nil
+			}
+			return undo
+		}
+		// The API server refuses dry runs in a namespace that does not exist yet
+		var createsNamespace bool
+		serverSide := func() bool { return !dryRun || !createsNamespace }
+		var createdDep *appsv1.Deployment
+		var rendered []runtime.Object
+		steps := []deployStep{
+			{Name: "namespace", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				created, err := ensureNamespace(ctx, cs, ns, dryRun)
+				if !created {
+					return nil, err
+				}
+				createsNamespace = true
+				return undoable(func(ctx context.Context) error {
+					return cs.CoreV1().Namespaces().Delete(ctx, ns, deleteOpts)
+				}), nil
+			}},
+			{Name: "tls-secret", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				if !serverSide() {
+					return nil, nil
+				}
+				created, err := ensureTLSSecret(ctx, cs, ns, domainConfig, dryRun)
+				if !created {
+					return nil, err
+				}
+				return undoable(func(ctx context.Context) error {
+					return cs.CoreV1().Secrets(ns).Delete(ctx, TLSSecretName, deleteOpts)
+				}), nil
+			}},
+			{Name: "deployment", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				dep := appDeployment(in)
+				dep.Annotations = changeAnnotations(r, "created with image "+in.Image)
+				if serverSide() {
+					created, err := cs.AppsV1().Deployments(ns).Create(ctx, dep, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					created.TypeMeta = dep.TypeMeta
+					dep = created
+				}
+				createdDep = dep
+				rendered = append(rendered, dep)
+				return undoable(func(ctx context.Context) error {
+					return cs.AppsV1().Deployments(ns).Delete(ctx, names.Deployment, deleteOpts)
+				}), nil
+			}},
+			{Name: "autoscaler", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				hpa := appAutoscaler(in)
+				if hpa == nil {
+					return nil, nil
+				}
+				if serverSide() {
+					created, err := cs.AutoscalingV2().HorizontalPodAutoscalers(ns).Create(ctx, hpa, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					created.TypeMeta = hpa.TypeMeta
+					hpa = created
+				}
+				rendered = append(rendered, hpa)
+				return undoable(func(ctx context.Context) error {
+					return cs.AutoscalingV2().HorizontalPodAutoscalers(ns).Delete(ctx, names.Autoscaler, deleteOpts)
+				}), nil
+			}},
+			{Name: "disruption-budget", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				pdb := appDisruptionBudget(in)
+				if pdb == nil {
+					return nil, nil
+				}
+				if serverSide() {
+					created, err := cs.PolicyV1().PodDisruptionBudgets(ns).Create(ctx, pdb, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					created.TypeMeta = pdb.TypeMeta
+					pdb = created
+				}
+				rendered = append(rendered, pdb)
+				return undoable(func(ctx context.Context) error {
+					return cs.PolicyV1().PodDisruptionBudgets(ns).Delete(ctx, names.DisruptionBudget, deleteOpts)
+				}), nil
+			}},
+			{Name: "service", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				svc := appService(in)
+				if serverSide() {
+					created, err := cs.CoreV1().Services(ns).Create(ctx, svc, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					created.TypeMeta = svc.TypeMeta
+					svc = created
+				}
+				rendered = append(rendered, svc)
+				return undoable(func(ctx context.Context) error {
+					return cs.CoreV1().Services(ns).Delete(ctx, names.Service, deleteOpts)
+				}), nil
+			}},
+			{Name: "middleware", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				mw := appMiddleware(in)
+				if mw == nil {
+					return nil, nil
+				}
+				if serverSide() {
+					created, err := cluster.Dynamic.Resource(middlewareGVR).Namespace(ns).Create(ctx, mw, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					mw = created
+				}
+				rendered = append(rendered, mw)
+				return undoable(func(ctx context.Context) error {
+					return cluster.Dynamic.Resource(middlewareGVR).Namespace(ns).Delete(ctx, names.Middleware, deleteOpts)
+				}), nil
+			}},
+			{Name: "ingress", Run: func(ctx context.Context) (func(context.Context) error, error) {
+				ing := appIngress(in, domainConfig)
+				if ing == nil {
+					return nil, nil
+				}
+				if serverSide() {
+					created, err := cs.NetworkingV1().Ingresses(ns).Create(ctx, ing, createOpts)
+					if err != nil {
+						return nil, err
+					}
+					created.TypeMeta = ing.TypeMeta
+					ing = created
+				}
+				rendered = append(rendered, ing)
+				return undoable(func(ctx context.Context) error {
+					return cs.NetworkingV1().Ingresses(ns).Delete(ctx, names.Ingress, deleteOpts)
+				}), nil
+			}},
+		}
+		if report, err := runDeploySteps(r.Context(), steps); err != nil {
+			logger := h.requestLogger(r)
+			logger.ErrorCtx(r.Context(), "deployment failed", "namespace", ns, "deployment", in.DeploymentName,
+				"dryRun", dryRun, "step", report.FailedStep, "rolledBack", report.RolledBack, "err", err)
+			if len(report.Leftovers) > 0 {
+				logger.WarnCtx(r.Context(), "deployment left objects behind", "namespace", ns,
+					"deployment", in.DeploymentName, "leftovers", report.Leftovers)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(kubeErrorStatus(err))
+			_ = json.NewEncoder(w).Encode(report)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(createdDep)
+		if dryRun {
+			_ = json.NewEncoder(w).Encode(dryRunResult{
+				DryRun:           true,
+				Namespace:        ns,
+				CreatesNamespace: createsNamespace,
+				ServerValidated:  serverSide(),
+				Objects:          rendered,
+			})
+			return
+		}
+		h.requestLogger(r).InfoCtx(r.Context(), "deployment created",
+			"namespace", in.Namespace, "deployment", createdDep.Name)
+		h.respondAfterRollout(w, r, cs, ns, createdDep.Name, wait, createdDep)
 	}
 }
 
@@ -576,17 +629,13 @@ func (h *Handler) handleDeploymentDeletion() http.HandlerFunc {
 			return
 		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Validate namespace exists
 		if err := validateNamespaceExists(activeClientset, namespace); err != nil {
@@ -594,43 +643,49 @@ func (h *Handler) handleDeploymentDeletion() http.HandlerFunc {
 			return
 		}
 
-		// Delete the specified deployment
-		err := activeClientset.AppsV1().Deployments(namespace).Delete(r.Context(), deploymentName, metav1.DeleteOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to delete deployment: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Also delete the associated service
-		err = activeClientset.CoreV1().Services(namespace).Delete(r.Context(), deploymentName+"-service", metav1.DeleteOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to delete service: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Also delete the associated ingress
-		err = activeClientset.NetworkingV1().Ingresses(namespace).Delete(r.Context(), deploymentName+"-ingress", metav1.DeleteOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to delete ingress: %v", err), http.StatusInternalServerError)
+		// Delete everything the app is made of
+		app := resolveAppName(r.Context(), cluster.Dynamic, namespace, deploymentName)
+		deleted, deleteErr := deleteApp(r.Context(), cluster.Dynamic, namespace, app)
+		if deleteErr == nil && len(deleted) == 0 {
+			http.Error(w, fmt.Sprintf("app %s not found in namespace %s", app, namespace), http.StatusNotFound)
 			return
 		}
+		report := struct {
+			Namespace        string         `json:"namespace"`
+			App              string         `json:"app"`
+			Deleted          []appObjectRef `json:"deleted"`
+			NamespaceDeleted bool           `json:"namespaceDeleted"`
+			Error            string         `json:"error,omitempty"`
+		}{Namespace: namespace, App: app, Deleted: deleted}
 
 		// Check if there are any deployments left in the namespace otherwise delete the namespace
-		deployments, err := activeClientset.AppsV1().Deployments(namespace).List(r.Context(), metav1.ListOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to list deployments: %v", err), http.StatusInternalServerError)
-			return
-		}
-		if len(deployments.Items) == 0 {
-			err = activeClientset.CoreV1().Namespaces().Delete(r.Context(), namespace, metav1.DeleteOptions{})
-			if err != nil {
-				http.Error(w, fmt.Sprintf("failed to delete namespace: %v", err), http.StatusInternalServerError)
-				return
+		if deleteErr == nil {
+			deployments, err := activeClientset.AppsV1().Deployments(namespace).List(r.Context(), metav1.ListOptions{})
+			switch {
+			case err != nil:
+				deleteErr = fmt.Errorf("list deployments: %w", err)
+			case len(deployments.Items) == 0:
+				if err := activeClientset.CoreV1().Namespaces().Delete(r.Context(), namespace, metav1.DeleteOptions{}); err != nil {
+					deleteErr = fmt.Errorf("delete namespace: %w", err)
+				} else {
+					report.NamespaceDeleted = true
+				}
 			}
 		}
 
-		// Return success response
-		w.WriteHeader(http.StatusNoContent)
+		status := http.StatusOK
+		if deleteErr != nil {
+			report.Error = deleteErr.Error()
+			status = http.StatusInternalServerError
+			h.requestLogger(r).ErrorCtx(r.Context(), "failed to delete app",
+				"namespace", namespace, "app", app, "deleted", deleted, "err", deleteErr)
+		} else {
+			h.requestLogger(r).InfoCtx(r.Context(), "app deleted",
+				"namespace", namespace, "app", app, "deleted", deleted, "namespaceDeleted", report.NamespaceDeleted)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(report)
 	}
 }
 
@@ -657,13 +712,45 @@ func (h *Handler) handleDeploymentUpdate() http.HandlerFunc {
 			http.Error(w, "replicas must be greater than 0", http.StatusBadRequest)
 			return
 		}
+		wait, err := rolloutWaitRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		activeClientset := cluster.Clientset
+
+		// An autoscaled deployment is scaled by its autoscaler, within the
+		// bounds PATCH changes
+		hpa, err := autoscalerFor(r.Context(), activeClientset, namespace, deploymentName)
+		if err != nil {
+			http.Error(w, err.Error(), kubeErrorStatus(err))
+			return
+		}
+		if hpa != nil {
+			a := autoscalingRequestFrom(hpa)
+			http.Error(w, fmt.Sprintf("deployment %s is autoscaled between %d and %d replicas by %s; change autoscaling.minReplicas and maxReplicas instead",
+				deploymentName, a.MinReplicas, a.MaxReplicas, hpa.Name), http.StatusConflict)
+			return
+		}
+
 		// Get the existing deployment
-		deploymentsClient := h.clientset.AppsV1().Deployments(namespace)
+		deploymentsClient := activeClientset.AppsV1().Deployments(namespace)
 		deployment, err := deploymentsClient.Get(r.Context(), deploymentName, metav1.GetOptions{})
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to get deployment: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if paused(deployment) {
+			http.Error(w, fmt.Sprintf("deployment %s is paused; resume it first", deploymentName), http.StatusConflict)
+			return
+		}
 		// Update the replicas
 		deployment.Spec.Replicas = int32Ptr(requestBody.Replicas)
 		updatedDeployment, err := deploymentsClient.Update(r.Context(), deployment, metav1.UpdateOptions{})
@@ -672,8 +759,7 @@ func (h *Handler) handleDeploymentUpdate() http.HandlerFunc {
 			return
 		}
 		// Return the updated deployment in JSON format
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(updatedDeployment)
+		h.respondAfterRollout(w, r, activeClientset, namespace, deploymentName, wait, updatedDeployment)
 	}
 }
 
@@ -687,17 +773,13 @@ func (h *Handler) handleDeploymentGet() http.HandlerFunc {
 			return
 		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for handling deployment retrieval
 		deployment, err := activeClientset.AppsV1().Deployments(namespace).Get(r.Context(), deploymentName, metav1.GetOptions{})
@@ -721,17 +803,13 @@ func (h *Handler) handleDeploymentGetAll() http.HandlerFunc {
 			return
 		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for handling deployment retrieval
 		deployments, err := activeClientset.AppsV1().Deployments(namespace).List(r.Context(), metav1.ListOptions{})
@@ -746,39 +824,57 @@ func (h *Handler) handleDeploymentGetAll() http.HandlerFunc {
 }
 
 // CLUSTERS
+// clusterStatus is the cached state of a cluster as reported by the cluster endpoints.
+type clusterStatus struct {
+	Name                string     `json:"name"`
+	Server              string     `json:"server"`
+	K8sVersion          string     `json:"k8sVersion"`
+	Status              string     `json:"status"` // healthy, unhealthy or unknown
+	LatencyMs           int64      `json:"latencyMs"`
+	LastChecked         *time.Time `json:"lastChecked,omitempty"`
+	LastSeen            *time.Time `json:"lastSeen,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	Error               string     `json:"error,omitempty"`
+}
+
+func (h *Handler) clusterStatus(cluster *Cluster) clusterStatus {
+	out := clusterStatus{
+		Name:   cluster.Name,
+		Server: cluster.Config.Host,
+		Status: "unknown",
+	}
+	health, ok := h.health.Status(cluster.Name)
+	if !ok {
+		return out
+	}
+	out.Status = "unhealthy"
+	if health.Healthy {
+		out.Status = "healthy"
+	}
+	out.K8sVersion = health.Version
+	out.LatencyMs = health.Latency.Milliseconds()
+	out.LastChecked = &health.LastChecked
+	if !health.LastSeen.IsZero() {
+		out.LastSeen = &health.LastSeen
+	}
+	out.ConsecutiveFailures = health.ConsecutiveFailures
+	out.Error = health.Error
+	return out
+}
+
 func (h *Handler) handleListClusters() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		type clusterInfo struct {
-			Name       string `json:"name"`
-			Server     string `json:"server"`
-			K8sVersion string `json:"k8sVersion"`
-		}
+		id, _ := IdentityFromContext(r.Context())
 
-		var clusters []clusterInfo
-		for name, cfg := range h.clientsConfig {
-			if name == "clappform" {
+		clusters := []clusterStatus{}
+		for _, cluster := range h.clusters.Snapshot() {
+			if cluster.Name == DefaultClusterName {
 				continue // skip the main cluster
 			}
-			// Create a new clientset for the cluster
-			cs, err := kubernetes.NewForConfig(cfg)
-			if err != nil {
-				continue // skip unreachable clusters
-			}
-
-			// get server version
-			info, err := cs.Discovery().ServerVersion()
-			if err != nil {
-				http.Error(w, "failed to get server version", http.StatusInternalServerError)
-				continue
-			}
-
-			out := clusterInfo{
-				Name:       name,
-				Server:     cfg.Host,
-				K8sVersion: info.GitVersion,
+			if err := h.authorizer.Authorize(id, PermClustersRead, Scope{Cluster: cluster.Name}); err != nil {
+				continue // only list clusters the caller may see
 			}
-
-			clusters = append(clusters, out)
+			clusters = append(clusters, h.clusterStatus(cluster))
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(clusters)
@@ -793,36 +889,14 @@ func (h *Handler) handleGetCluster() http.HandlerFunc {
 			return
 		}
 
-		cfg, ok := h.clientsConfig[clusterName]
+		cluster, ok := h.clusters.Get(clusterName)
 		if !ok {
 			http.Error(w, "cluster not found", http.StatusNotFound)
 			return
 		}
 
-		cs, err := kubernetes.NewForConfig(cfg)
-		if err != nil {
-			http.Error(w, "failed to create client", http.StatusInternalServerError)
-			return
-		}
-
-		info, err := cs.Discovery().ServerVersion()
-		if err != nil {
-			http.Error(w, "failed to get server version", http.StatusInternalServerError)
-			return
-		}
-
-		out := struct {
-			Name       string `json:"name"`
-			Server     string `json:"server"`
-			K8sVersion string `json:"k8sVersion"`
-		}{
-			Name:       clusterName,
-			Server:     cfg.Host,
-			K8sVersion: info.GitVersion,
-		}
-
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(out)
+		_ = json.NewEncoder(w).Encode(h.clusterStatus(cluster))
 	}
 }
 
@@ -850,18 +924,23 @@ func (h *Handler) handleCreateSecret() http.HandlerFunc {
 			http.Error(w, "data is required", http.StatusBadRequest)
 			return
 		}
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		auditObjects(r.Context(), in.SecretName)
+		if !h.authorize(w, r, PermSecretsWrite, in.Namespace) {
+			return
+		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Validate namespace exists
 		if err := validateNamespaceExists(activeClientset, in.Namespace); err != nil {
@@ -888,12 +967,24 @@ func (h *Handler) handleCreateSecret() http.HandlerFunc {
 			},
 			StringData: secretData,
 		}
-		_, err := activeClientset.CoreV1().Secrets(in.Namespace).Create(r.Context(), secret, metav1.CreateOptions{})
+		created, err := activeClientset.CoreV1().Secrets(in.Namespace).Create(r.Context(), secret, createOptions(dryRun))
 		if err != nil {
 			http.Error(w, fmt.Sprintf("failed to create secret: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		if dryRun {
+			created.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+			redactSecret(created)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(dryRunResult{
+				DryRun:          true,
+				Namespace:       in.Namespace,
+				ServerValidated: true,
+				Objects:         []runtime.Object{created},
+			})
+			return
+		}
 		w.WriteHeader(http.StatusCreated)
 	}
 }
@@ -907,17 +998,13 @@ func (h *Handler) handleGetSecrets() http.HandlerFunc {
 			return
 		}
 
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for getting secrets
 		secrets, err := activeClientset.CoreV1().Secrets(namespace).List(r.Context(), metav1.ListOptions{})
@@ -946,47 +1033,65 @@ func (h *Handler) handleCreateConfigMap() http.HandlerFunc {
 			http.Error(w, "invalid JSON", http.StatusBadRequest)
 			return
 		}
-
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		if in.ConfigMapName == "" {
+			http.Error(w, "configMapName is required", http.StatusBadRequest)
+			return
+		}
+		dryRun, err := dryRunRequested(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		auditObjects(r.Context(), in.ConfigMapName)
+		if !h.authorize(w, r, PermConfigMapsWrite, in.Namespace) {
+			return
 		}
 
-		// Validate namespace exists
-		if err := validateNamespaceExists(activeClientset, in.Namespace); err != nil {
-			// Create the namespace if it does not exist
-			if _, err := activeClientset.CoreV1().Namespaces().Create(r.Context(), &corev1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{Name: in.Namespace},
-			}, metav1.CreateOptions{}); err != nil {
-				http.Error(w, fmt.Sprintf("failed to create namespace: %v", err), http.StatusInternalServerError)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
-		// Implementation for creating a config map
-		configMapName := in.ConfigMapName
-		if configMapName == "" {
-			http.Error(w, "configMapName is required", http.StatusBadRequest)
+		// Create the namespace if it does not exist
+		createsNamespace, err := ensureNamespace(r.Context(), activeClientset, in.Namespace, dryRun)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to create namespace: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		// Implementation for creating a config map
 		configMap := &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      configMapName,
+				Name:      in.ConfigMapName,
 				Namespace: in.Namespace,
 			},
 			Data: in.Data,
 		}
-		_, err := activeClientset.CoreV1().ConfigMaps(in.Namespace).Create(r.Context(), configMap, metav1.CreateOptions{})
-		if err != nil {
-			http.Error(w, fmt.Sprintf("failed to create config map: %v", err), http.StatusInternalServerError)
+		// The API server refuses dry runs in a namespace that does not exist yet
+		serverValidated := !dryRun || !createsNamespace
+		if serverValidated {
+			created, err := activeClientset.CoreV1().ConfigMaps(in.Namespace).Create(r.Context(), configMap, createOptions(dryRun))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to create config map: %v", err), http.StatusInternalServerError)
+				return
+			}
+			created.TypeMeta = configMap.TypeMeta
+			configMap = created
+		}
+
+		if dryRun {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(dryRunResult{
+				DryRun:           true,
+				Namespace:        in.Namespace,
+				CreatesNamespace: createsNamespace,
+				ServerValidated:  serverValidated,
+				Objects:          []runtime.Object{configMap},
+			})
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
@@ -1001,17 +1106,13 @@ func (h *Handler) handleGetConfigMaps() http.HandlerFunc {
 			http.Error(w, "namespace is required", http.StatusBadRequest)
 			return
 		}
-		// Determine which clientset to use
-		activeClientset := h.clientset
-		clusterName := r.Header.Get("cluster-name")
-		if clusterName != "" {
-			var err error
-			activeClientset, err = switchClientset(h, clusterName)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Determine which cluster to use
+		cluster, err := h.clusterFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		activeClientset := cluster.Clientset
 
 		// Implementation for getting config maps
 		configMaps, err := activeClientset.CoreV1().ConfigMaps(namespace).List(r.Context(), metav1.ListOptions{})
@@ -1045,6 +1146,8 @@ func (h *Handler) handleAddClusterContext() http.HandlerFunc {
 		Domain           string `json:"domain,omitempty"`
 		Certificate      []byte `json:"certificate,omitempty"`
 		PrivateKey       []byte `json:"privateKey,omitempty"`
+
+		Client *clientSettingsRequest `json:"client,omitempty"`
 	}
 	type resp struct {
 		Name      string `json:"name"`
@@ -1053,8 +1156,6 @@ func (h *Handler) handleAddClusterContext() http.HandlerFunc {
 		Namespace string `json:"defaultNamespace,omitempty"`
 	}
 
-	nameRe := regexp.MustCompile(`^[a-zA-Z0-9._-]{1,80}$`)
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MB
 		var in req
@@ -1063,17 +1164,14 @@ func (h *Handler) handleAddClusterContext() http.HandlerFunc {
 			return
 		}
 
-		print("Received onboarding request with parameters:\n")
-		fmt.Printf("%+v\n", in)
-
-		print(fmt.Sprintf(" - Domain: %s\n", in.Domain))
-		print(fmt.Sprintf(" - Cert: %d bytes\n", len(in.Certificate)))
-		print(fmt.Sprintf(" - Key: %d bytes\n", len(in.PrivateKey)))
-
-		if !nameRe.MatchString(in.Name) {
+		if !clusterNameRe.MatchString(in.Name) {
 			http.Error(w, "invalid name", http.StatusBadRequest)
 			return
 		}
+		auditObjects(r.Context(), in.Name)
+		if !h.authorizeScope(w, r, PermClustersWrite, Scope{Cluster: in.Name}) {
+			return
+		}
 		if !strings.HasPrefix(in.Server, "https://") {
 			http.Error(w, "server must start with https://", http.StatusBadRequest)
 			return
@@ -1090,65 +1188,68 @@ func (h *Handler) handleAddClusterContext() http.HandlerFunc {
 		}
 
 		// Check if we already have a client for this name
-		if _, exists := h.clients[in.Name]; exists {
+		if _, exists := h.clusters.Get(in.Name); exists {
 			http.Error(w, "cluster with this name already exists", http.StatusConflict)
 			return
 		}
 
-		print("Onboarding new cluster:\n")
-		print(fmt.Sprintf(" - Name: %s\n", in.Name))
-		print(fmt.Sprintf(" - Server: %s\n", in.Server))
-		print(fmt.Sprintf(" - CA: %d bytes\n", len(caBytes)))
-		print(fmt.Sprintf(" - Token: %d bytes\n", len(in.BearerToken)))
-		print(fmt.Sprintf(" - Default Namespace: %s\n", in.DefaultNamespace))
-
-		// Build the Domain config if provided and store in map
-		if (in.Domain != "" && (in.Certificate == nil || in.PrivateKey == nil)) ||
-			(in.Domain == "" && (in.Certificate != nil || in.PrivateKey != nil)) {
-			http.Error(w, "domain, certificate and privateKey must be all provided or all omitted", http.StatusBadRequest)
-			return
-		} else {
-			domainConfig := DomainConfig{
+		rec := ClusterRecord{
+			Name:             in.Name,
+			Server:           in.Server,
+			CAData:           caBytes,
+			BearerToken:      in.BearerToken,
+			DefaultNamespace: in.DefaultNamespace,
+			Domain: DomainConfig{
 				Domain:      in.Domain,
 				Certificate: in.Certificate,
 				PrivateKey:  in.PrivateKey,
-			}
-
-			h.clientsDomains[in.Name] = domainConfig
-		}
-
-		// Build the rest.Config
-		cfg := &rest.Config{
-			Host:        in.Server,
-			BearerToken: in.BearerToken,
-			TLSClientConfig: rest.TLSClientConfig{
-				CAData: caBytes,
 			},
-			UserAgent: "myapp/sa-onboarder",
-			// Add the domain to the config if provided (used for ingress rules)
+		}
 
-			// Optional client-side rate limits:
-			// QPS: 5, Burst: 10,
+		// The domain config is optional, but must be complete when provided
+		if err := validateDomainConfig(rec.Domain); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rec.Client, err = h.clientSettings(in.Client, ClientSettings{}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		cs, err := kubernetes.NewForConfig(cfg)
+		cluster, err := newClusterFromRecord(rec, h.clientDefaults)
 		if err != nil {
 			http.Error(w, "failed to build client", http.StatusInternalServerError)
 			return
 		}
 
 		// Probe /version with a short timeout
-		_, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
-		info, err := cs.Discovery().ServerVersion()
+		probeStart := time.Now()
+		info, err := probeCluster(r.Context(), cluster, h.healthTimeout)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("unable to contact cluster: %v", err), http.StatusBadGateway)
 			return
 		}
 
-		// Store the client
-		h.clients[in.Name] = cs
-		h.clientsConfig[in.Name] = cfg
+		// Store the client, a concurrent onboarding may have taken the name
+		if err := h.clusters.Add(cluster); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		// Persist the cluster so it survives restarts
+		if h.clusterStore != nil {
+			if err := h.clusterStore.Put(r.Context(), rec); err != nil {
+				h.clusters.Remove(rec.Name)
+				h.requestLogger(r).ErrorCtx(r.Context(), "failed to store cluster", "cluster", in.Name, "err", err)
+				http.Error(w, "failed to store cluster", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		h.health.Observe(cluster, info.GitVersion, time.Since(probeStart), nil)
+
+		h.requestLogger(r).InfoCtx(r.Context(), "cluster onboarded",
+			"cluster", in.Name, "server", in.Server, "version", info.GitVersion)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -1161,6 +1262,32 @@ func (h *Handler) handleAddClusterContext() http.HandlerFunc {
 	}
 }
 
+// loadStoredClusters registers every cluster kept in the cluster store. A
+// cluster whose client cannot be built is logged and skipped, so one broken
+// record does not keep the others from loading.
+func (h *Handler) loadStoredClusters(ctx context.Context) error {
+	records, err := h.clusterStore.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if rec.Name == DefaultClusterName {
+			h.logger.WarnCtx(ctx, "ignoring stored cluster with reserved name", "cluster", rec.Name)
+			continue
+		}
+		cluster, err := newClusterFromRecord(rec, h.clientDefaults)
+		if err == nil {
+			err = h.clusters.Add(cluster)
+		}
+		if err != nil {
+			h.logger.ErrorCtx(ctx, "failed to restore cluster", "cluster", rec.Name, "err", err)
+			continue
+		}
+		h.logger.InfoCtx(ctx, "restored cluster", "cluster", rec.Name, "server", rec.Server)
+	}
+	return nil
+}
+
 func normalizePEM(s string) ([]byte, error) {
 	// Accept raw PEM or base64-encoded PEM
 	raw := strings.TrimSpace(s)
@@ -1207,28 +1334,27 @@ func (h *Handler) handleHealth() http.HandlerFunc {
 // Checks if the Kubernetes clientset is available
 func (h *Handler) handleReady() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if we can connect to Kubernetes
-		if h.clientset == nil {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Check the last known connectivity to Kubernetes
+		health, ok := h.health.Status(DefaultClusterName)
+		if !ok {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_ = json.NewEncoder(w).Encode(map[string]string{
 				"status": "not ready",
-				"reason": "kubernetes client not initialized",
+				"reason": "kubernetes connection not checked yet",
 			})
 			return
 		}
-
-		// Try to get server version as a connectivity check
-		_, err := h.clientset.Discovery().ServerVersion()
-		if err != nil {
+		if !health.Healthy {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_ = json.NewEncoder(w).Encode(map[string]string{
 				"status": "not ready",
-				"reason": fmt.Sprintf("kubernetes connection failed: %v", err),
+				"reason": fmt.Sprintf("kubernetes connection failed: %s", health.Error),
 			})
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]string{
 			"status": "ready",
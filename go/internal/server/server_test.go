@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ClappFormOrg/AI-CO/go/pkg/log"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testListKinds are the list kinds of every resource the handlers list
+// through the dynamic client, which the fake needs to be told.
+var testListKinds = map[schema.GroupVersionResource]string{
+	deploymentGVR:       "DeploymentList",
+	serviceGVR:          "ServiceList",
+	ingressGVR:          "IngressList",
+	middlewareGVR:       "MiddlewareList",
+	autoscalerGVR:       "HorizontalPodAutoscalerList",
+	disruptionBudgetGVR: "PodDisruptionBudgetList",
+	ingressRouteGVR:     "IngressRouteList",
+	traefikServiceGVR:   "TraefikServiceList",
+}
+
+// testServer is a Handler for a default cluster backed by fake clients,
+// serving the routes a test registers as alice, who may do anything.
+type testServer struct {
+	h         *Handler
+	mux       *http.ServeMux
+	clientset *fake.Clientset
+	dynamic   *dynamicfake.FakeDynamicClient
+}
+
+// newTestServer returns a testServer whose cluster holds objects. The fake
+// clients do not share storage: unstructured objects go to the dynamic client
+// and the others to the clientset.
+func newTestServer(t *testing.T, objects ...runtime.Object) *testServer {
+	t.Helper()
+	var typed, dynamic []runtime.Object
+	for _, obj := range objects {
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			dynamic = append(dynamic, obj)
+		} else {
+			typed = append(typed, obj)
+		}
+	}
+	s := &testServer{
+		h: &Handler{
+			logger:     log.NewNoOpLogger(),
+			clusters:   NewClusterRegistry(),
+			authorizer: &Policy{Bindings: []RoleBinding{{Role: RoleClusterAdmin, Subjects: []string{"alice"}}}},
+		},
+		mux:       http.NewServeMux(),
+		clientset: fake.NewClientset(typed...),
+		dynamic:   dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), testListKinds, dynamic...),
+	}
+	if err := s.h.clusters.Add(&Cluster{Name: DefaultClusterName, Clientset: s.clientset, Dynamic: s.dynamic}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// handle registers handler for pattern.
+func (s *testServer) handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// serve sends a request with body to the registered routes.
+func (s *testServer) serve(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = r.WithContext(WithIdentity(r.Context(), &Identity{Subject: "alice"}))
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, r)
+	return rec
+}
@@ -374,7 +374,7 @@ func TestCreateKubernetesClient(t *testing.T) {
 					return nil, tc.clusterErr
 				}
 
-				client, err := CreateKubernetesClient()
+				client, _, err := CreateKubernetesClient()
 				if client != nil {
 					t.Errorf("expected nil client, got %+v", client)
 				}
@@ -417,7 +417,7 @@ func TestCreateKubernetesClient(t *testing.T) {
 				t.Fatal("expected panic on unexpected error, but did not panic")
 			}
 		}()
-		_, _ = CreateKubernetesClient()
+		_, _, _ = CreateKubernetesClient()
 	})
 
 	t.Run("Client creation error", func(t *testing.T) {
@@ -433,7 +433,7 @@ func TestCreateKubernetesClient(t *testing.T) {
 			return nil, inner
 		}
 
-		client, err := CreateKubernetesClient()
+		client, _, err := CreateKubernetesClient()
 		if client != nil {
 			t.Errorf("expected nil client on creation error, got %+v", client)
 		}
@@ -472,7 +472,7 @@ func TestCreateKubernetesClient(t *testing.T) {
 			return fakeClient, nil
 		}
 
-		client, err := CreateKubernetesClient()
+		client, _, err := CreateKubernetesClient()
 		if err != nil {
 			t.Fatalf("unexpected error in happy path: %v", err)
 		}
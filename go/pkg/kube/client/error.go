@@ -105,3 +105,31 @@ func (e *ErrClientCreation) Error() string {
 
 // Unwrap returns the underlying wrapped error.
 func (e *ErrClientCreation) Unwrap() error { return e.Err }
+
+// ErrKubeconfig is returned when an uploaded kubeconfig cannot be parsed or
+// one of its contexts cannot be turned into a client configuration.
+//
+// Context is empty when the kubeconfig as a whole is invalid.
+type ErrKubeconfig struct {
+	Context string // Context is the kubeconfig context the error applies to, if any.
+	Err     error  // Err is the underlying error.
+}
+
+// NewErrKubeconfig constructs an ErrKubeconfig for the named context.
+//
+//	err := NewErrKubeconfig("prod", errors.New("exec plugins are not allowed"))
+//	fmt.Println(err.Error()) // prints: `invalid kubeconfig context "prod": exec plugins are not allowed`
+func NewErrKubeconfig(context string, err error) *ErrKubeconfig {
+	return &ErrKubeconfig{Context: context, Err: err}
+}
+
+// Error returns a descriptive error message including the context and the wrapped error.
+func (e *ErrKubeconfig) Error() string {
+	if e.Context == "" {
+		return fmt.Sprintf("invalid kubeconfig: %v", e.Err)
+	}
+	return fmt.Sprintf("invalid kubeconfig context %q: %v", e.Context, e.Err)
+}
+
+// Unwrap returns the underlying wrapped error.
+func (e *ErrKubeconfig) Unwrap() error { return e.Err }
@@ -99,3 +99,25 @@ func TestErrClientCreation_Unwrap(t *testing.T) {
 		t.Errorf("e = %v, doesnt contain original %v", e, orig)
 	}
 }
+
+func TestErrKubeconfig_Error(t *testing.T) {
+	orig := errors.New("underlying failure")
+
+	if got := NewErrKubeconfig("", orig).Error(); !strings.Contains(got, orig.Error()) {
+		t.Errorf("Error() = %q, want it to contain %q", got, orig.Error())
+	}
+
+	got := NewErrKubeconfig("prod", orig).Error()
+	if !strings.Contains(got, orig.Error()) || !strings.Contains(got, `"prod"`) {
+		t.Errorf("Error() = %q, want it to contain %q and the context name", got, orig.Error())
+	}
+}
+
+func TestErrKubeconfig_Unwrap(t *testing.T) {
+	orig := errors.New("root cause")
+	e := NewErrKubeconfig("prod", orig)
+
+	if !errors.Is(e, orig) {
+		t.Errorf("e = %v, doesnt contain original %v", e, orig)
+	}
+}
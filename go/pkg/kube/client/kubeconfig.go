@@ -0,0 +1,137 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubeconfigContext is a single context taken from an uploaded kubeconfig.
+type KubeconfigContext struct {
+	Name       string // Name is the name of the context in the kubeconfig.
+	Namespace  string // Namespace is the default namespace of the context, if any.
+	Server     string // Server is the API server URL of the context's cluster.
+	Kubeconfig []byte // Kubeconfig is a self-contained kubeconfig holding only this context.
+}
+
+// ParseKubeconfig splits an uploaded kubeconfig into its contexts.
+//
+// When contexts is empty every context in the kubeconfig is returned, sorted
+// by name; otherwise only the listed contexts are returned, in the given order.
+// Each returned context carries a minified kubeconfig that references nothing
+// but its own cluster and user, suitable for storing and for
+// RESTConfigFromKubeconfig.
+//
+// Because the kubeconfig comes from a remote caller, any reference to a file
+// on the local disk (certificate-authority, client-certificate, client-key,
+// tokenFile) is rejected, as are insecure-skip-tls-verify and the deprecated
+// auth-provider plugins. Exec credential plugins run a command on this host
+// and are only accepted when allowExec is set.
+//
+// Possible Errors:
+//   - *ErrKubeconfig: Returned when the kubeconfig cannot be parsed, a requested
+//     context does not exist, or a context fails one of the checks above.
+func ParseKubeconfig(data []byte, contexts []string, allowExec bool) ([]KubeconfigContext, error) {
+	raw, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, NewErrKubeconfig("", err)
+	}
+	if len(raw.Contexts) == 0 {
+		return nil, NewErrKubeconfig("", errors.New("no contexts found"))
+	}
+
+	if len(contexts) == 0 {
+		contexts = slices.Sorted(maps.Keys(raw.Contexts))
+	}
+
+	out := make([]KubeconfigContext, 0, len(contexts))
+	for _, name := range contexts {
+		kc, err := extractContext(raw, name, allowExec)
+		if err != nil {
+			return nil, NewErrKubeconfig(name, err)
+		}
+		out = append(out, kc)
+	}
+	return out, nil
+}
+
+// extractContext validates the named context of raw and minifies raw down to it.
+func extractContext(raw *clientcmdapi.Config, name string, allowExec bool) (KubeconfigContext, error) {
+	kctx, ok := raw.Contexts[name]
+	if !ok {
+		return KubeconfigContext{}, errors.New("context not found")
+	}
+	cluster, ok := raw.Clusters[kctx.Cluster]
+	if !ok {
+		return KubeconfigContext{}, fmt.Errorf("cluster %q not found", kctx.Cluster)
+	}
+	user, ok := raw.AuthInfos[kctx.AuthInfo]
+	if !ok {
+		return KubeconfigContext{}, fmt.Errorf("user %q not found", kctx.AuthInfo)
+	}
+
+	if !strings.HasPrefix(cluster.Server, "https://") {
+		return KubeconfigContext{}, errors.New("server must start with https://")
+	}
+	if cluster.InsecureSkipTLSVerify {
+		return KubeconfigContext{}, errors.New("insecure-skip-tls-verify is not allowed")
+	}
+	if cluster.CertificateAuthority != "" {
+		return KubeconfigContext{}, errors.New("certificate-authority file references are not allowed, use certificate-authority-data")
+	}
+	if user.ClientCertificate != "" || user.ClientKey != "" {
+		return KubeconfigContext{}, errors.New("client-certificate and client-key file references are not allowed, use the -data fields")
+	}
+	if user.TokenFile != "" {
+		return KubeconfigContext{}, errors.New("tokenFile references are not allowed, use token")
+	}
+	if user.AuthProvider != nil {
+		return KubeconfigContext{}, fmt.Errorf("auth-provider %q is not supported, use an exec plugin", user.AuthProvider.Name)
+	}
+	if user.Exec != nil && !allowExec {
+		return KubeconfigContext{}, fmt.Errorf("exec plugin %q is not allowed", user.Exec.Command)
+	}
+
+	minified := raw.DeepCopy()
+	minified.CurrentContext = name
+	if err := clientcmdapi.MinifyConfig(minified); err != nil {
+		return KubeconfigContext{}, err
+	}
+	if exec := minified.AuthInfos[kctx.AuthInfo].Exec; exec != nil {
+		// There is no terminal to prompt on, so plugins must never be interactive
+		exec.InteractiveMode = clientcmdapi.NeverExecInteractiveMode
+	}
+	data, err := clientcmd.Write(*minified)
+	if err != nil {
+		return KubeconfigContext{}, err
+	}
+	if _, err := clientcmd.NewDefaultClientConfig(*minified, nil).ClientConfig(); err != nil {
+		return KubeconfigContext{}, err
+	}
+
+	return KubeconfigContext{
+		Name:       name,
+		Namespace:  kctx.Namespace,
+		Server:     cluster.Server,
+		Kubeconfig: data,
+	}, nil
+}
+
+// RESTConfigFromKubeconfig builds a client configuration from a kubeconfig
+// returned by ParseKubeconfig, using its current context.
+//
+// Possible Errors:
+//   - *ErrKubeconfig: Returned when the kubeconfig cannot be turned into a client configuration.
+func RESTConfigFromKubeconfig(data []byte) (*rest.Config, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, NewErrKubeconfig("", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example:6443
+    certificate-authority-data: ` + "Y2E=" + `
+- name: staging
+  cluster:
+    server: https://staging.example:6443
+- name: local
+  cluster:
+    server: https://127.0.0.1:6443
+    certificate-authority: /etc/kubernetes/ca.crt
+users:
+- name: prod-admin
+  user:
+    token: prod-token
+- name: staging-exec
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: aws
+      args: ["eks", "get-token"]
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod-admin
+    namespace: apps
+- name: staging
+  context:
+    cluster: staging
+    user: staging-exec
+- name: local
+  context:
+    cluster: local
+    user: prod-admin
+`
+
+func TestParseKubeconfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		contexts  []string
+		allowExec bool
+		want      []string
+		wantErr   string
+	}{
+		{
+			name:     "Selected context",
+			contexts: []string{"prod"},
+			want:     []string{"prod"},
+		},
+		{
+			name:      "Exec plugin allowed",
+			contexts:  []string{"staging", "prod"},
+			allowExec: true,
+			want:      []string{"staging", "prod"},
+		},
+		{
+			name:     "Exec plugin rejected",
+			contexts: []string{"staging"},
+			wantErr:  "exec plugin",
+		},
+		{
+			name:     "File reference rejected",
+			contexts: []string{"local"},
+			wantErr:  "certificate-authority",
+		},
+		{
+			name:      "All contexts by default",
+			allowExec: true,
+			wantErr:   `"local"`,
+		},
+		{
+			name:     "Unknown context",
+			contexts: []string{"missing"},
+			wantErr:  "context not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKubeconfig([]byte(testKubeconfig), tt.contexts, tt.allowExec)
+			if tt.wantErr != "" {
+				var kerr *ErrKubeconfig
+				if !errors.As(err, &kerr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected *ErrKubeconfig containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d contexts, got %d", len(tt.want), len(got))
+			}
+			for i, kc := range got {
+				if kc.Name != tt.want[i] {
+					t.Errorf("context %d: expected %q, got %q", i, tt.want[i], kc.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestParseKubeconfigMinifies(t *testing.T) {
+	got, err := ParseKubeconfig([]byte(testKubeconfig), []string{"prod"}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	kc := got[0]
+	if kc.Namespace != "apps" || kc.Server != "https://prod.example:6443" {
+		t.Errorf("unexpected context %+v", kc)
+	}
+	if strings.Contains(string(kc.Kubeconfig), "staging") {
+		t.Errorf("minified kubeconfig still references other contexts:\n%s", kc.Kubeconfig)
+	}
+
+	config, err := RESTConfigFromKubeconfig(kc.Kubeconfig)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Host != "https://prod.example:6443" || config.BearerToken != "prod-token" || string(config.CAData) != "ca" {
+		t.Errorf("unexpected rest config: host=%q token=%q ca=%q", config.Host, config.BearerToken, config.CAData)
+	}
+}
+
+func TestParseKubeconfigInvalid(t *testing.T) {
+	for _, data := range []string{"not: [valid", "apiVersion: v1\nkind: Config\n"} {
+		var kerr *ErrKubeconfig
+		if _, err := ParseKubeconfig([]byte(data), nil, false); !errors.As(err, &kerr) {
+			t.Errorf("expected *ErrKubeconfig for %q, got %v", data, err)
+		}
+	}
+}